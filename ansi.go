@@ -0,0 +1,246 @@
+package formatting
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ansiReset    = "\x1b[0m"
+	ansiQuoteBar = "\x1b[90m▎\x1b[39m "
+)
+
+// ansiClassColors maps HighlightNode classes to ANSI colors, for spans without an explicit color.
+var ansiClassColors = map[string]string{
+	"keyword":  "\x1b[35m",
+	"built_in": "\x1b[36m",
+	"type":     "\x1b[36m",
+	"literal":  "\x1b[36m",
+	"number":   "\x1b[36m",
+	"string":   "\x1b[32m",
+	"regexp":   "\x1b[32m",
+	"comment":  "\x1b[90m",
+	"meta":     "\x1b[90m",
+	"title":    "\x1b[34m",
+	"function": "\x1b[34m",
+	"variable": "\x1b[33m",
+	"attr":     "\x1b[33m",
+}
+
+/*
+RenderANSI renders an AST to text with ANSI escape sequences, for display in a terminal.
+
+Formatting is rendered with the usual SGR attributes, such as bold for a BoldNode. Links are rendered
+as OSC 8 hyperlinks. Code blocks are syntax-highlighted with the Highlighter of the options, if any.
+Control characters other than newlines, such as ESC, are removed from the text and URLs of the AST, so that
+untrusted messages cannot write escape sequences to the terminal.
+
+The options parameter can be nil, which is equivalent to passing an empty RenderOptions.
+*/
+func RenderANSI(n Node, options *RenderOptions) string {
+	var sb strings.Builder
+	WriteANSI(&sb, n, options)
+	return sb.String()
+}
+
+/*
+WriteANSI renders an AST to text with ANSI escape sequences like RenderANSI, writing it to w.
+
+Rendering stops at the first write error, which is returned.
+*/
+func WriteANSI(w io.Writer, n Node, options *RenderOptions) error {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+	r := ansiRenderer{
+		options: options,
+		w:       &errWriter{w: w},
+	}
+	return r.render(n)
+}
+
+type ansiRenderer struct {
+	options *RenderOptions
+	w       *errWriter
+	styles  []string
+	quote   int
+	// bar is set when a quote bar should be written before the next text of a block quote.
+	bar bool
+}
+
+func (r *ansiRenderer) push(style string) {
+	r.styles = append(r.styles, style)
+	r.w.WriteString(style)
+}
+
+func (r *ansiRenderer) pop() {
+	r.styles = r.styles[:len(r.styles)-1]
+	r.w.WriteString(ansiReset)
+	for _, style := range r.styles {
+		r.w.WriteString(style)
+	}
+}
+
+func (r *ansiRenderer) style(style string, entering bool) {
+	if entering {
+		r.push(style)
+	} else {
+		r.pop()
+	}
+}
+
+func (r *ansiRenderer) text(s string) {
+	s = stripControl(s)
+	if r.quote == 0 {
+		r.w.WriteString(s)
+		return
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if r.bar {
+			r.w.WriteString(ansiQuoteBar)
+		}
+		r.w.WriteString(line)
+		r.bar = strings.HasSuffix(line, "\n")
+	}
+}
+
+func (r *ansiRenderer) render(n Node) error {
+	return WalkErr(n, func(n Node, entering bool) error {
+		switch n := n.(type) {
+		case *TextNode:
+			if entering {
+				r.text(n.Content)
+			}
+		case *BlockQuoteNode:
+			if entering {
+				r.quote++
+				r.bar = true
+			} else {
+				r.quote--
+				r.bar = false
+			}
+		case *CodeNode:
+			if !entering {
+				break
+			}
+			if n.Inline {
+				r.push("\x1b[100m")
+				r.text(n.Content)
+				r.pop()
+				break
+			}
+			if h := highlight(n, r.options); h != nil {
+				r.render(h)
+			} else {
+				r.push("\x1b[37m")
+				r.text(n.Content)
+				r.pop()
+			}
+		case *SpoilerNode:
+			r.style("\x1b[7m", entering)
+		case *URLNode:
+			if !entering {
+				break
+			}
+			text := n.Mask
+			if text == "" {
+				text = n.URL
+			}
+			r.push("\x1b[4;34m")
+			r.w.WriteString("\x1b]8;;" + strings.ReplaceAll(stripControl(n.URL), "\n", "") + "\x1b\\")
+			r.text(text)
+			r.w.WriteString("\x1b]8;;\x1b\\")
+			r.pop()
+		case *EmojiNode:
+			if entering {
+				_, altText := emojiImage(n, r.options)
+				r.text(altText)
+			}
+		case *NamedEmojiNode:
+			if entering {
+				r.text(nodeText(n))
+			}
+		case *ChannelMentionNode:
+			if entering {
+				r.mention("#" + n.ID)
+			}
+		case *RoleMentionNode:
+			if entering {
+				r.mention("@&" + n.ID)
+			}
+		case *UserMentionNode:
+			if entering {
+				r.mention("@" + n.ID)
+			}
+		case *SpecialMentionNode:
+			if entering {
+				r.mention("@" + n.Mention)
+			}
+		case *TimestampNode:
+			if !entering {
+				break
+			}
+			r.push("\x1b[100m")
+			r.text(timestampText(n, r.options))
+			r.pop()
+		case *UnknownTagNode:
+			if entering {
+				r.text(n.Raw)
+			}
+		case *HeaderNode:
+			r.style("\x1b[1;4m", entering)
+		case *BulletListNode:
+			if entering {
+				if n.NestedLevel > 1 {
+					r.text(strings.Repeat("  ", n.NestedLevel-1))
+				}
+				r.text("• ")
+			} else if n.IncludesNewline {
+				r.text("\n")
+			}
+		case *BoldNode:
+			r.style("\x1b[1m", entering)
+		case *UnderlineNode:
+			r.style("\x1b[4m", entering)
+		case *ItalicsNode:
+			r.style("\x1b[3m", entering)
+		case *StrikethroughNode:
+			r.style("\x1b[9m", entering)
+		case *HighlightNode:
+			r.style(ansiColor(n), entering)
+		case *LineBreakNode:
+			if entering {
+				r.text("\n")
+			}
+		}
+		return r.w.err
+	})
+}
+
+func (r *ansiRenderer) mention(text string) {
+	r.push("\x1b[1;34m")
+	r.text(text)
+	r.pop()
+}
+
+// stripControl returns s without its C0 and C1 control characters other than \n, such as ESC,
+// so that untrusted text cannot write escape sequences to the terminal.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && (r < 0x20 || r >= 0x7F && r < 0xA0) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func ansiColor(n *HighlightNode) string {
+	if rgb, ok := hexColor(n.Color); ok {
+		return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", rgb>>16, (rgb>>8)&0xFF, rgb&0xFF)
+	}
+	return ansiClassColors[n.Class]
+}
@@ -0,0 +1,83 @@
+package formatting
+
+/*
+Arena allocates the nodes of parsed messages from large blocks of nodes, set in ParserOptions.Arena,
+to reduce the garbage collection work of jobs parsing many short-lived messages, such as re-rendering a message history.
+
+The nodes of all the messages parsed with an Arena are freed as a unit when the Arena is no longer referenced,
+or reused by the next parsed messages after calling Reset.
+
+An Arena must not be used concurrently. The zero value is an empty Arena ready to use.
+*/
+type Arena struct {
+	slabs map[NodeKind]arenaResetter
+}
+
+/*
+NewArena returns a new empty Arena.
+*/
+func NewArena() *Arena {
+	return &Arena{}
+}
+
+/*
+Reset makes the nodes allocated by the Arena available for the next parsed messages.
+
+The trees parsed with the Arena before calling Reset must not be used after calling it.
+*/
+func (a *Arena) Reset() {
+	for _, s := range a.slabs {
+		s.reset()
+	}
+}
+
+type arenaResetter interface {
+	reset()
+}
+
+// arenaSlab is the list of blocks of the nodes of type T of an Arena.
+type arenaSlab[T any] struct {
+	blocks [][]T
+	// block and next are the index of the current block, and of the next free node in it.
+	block int
+	next  int
+}
+
+// arenaBlockSize is the size of the first block of a slab. Each next block is twice as large as the previous one.
+const arenaBlockSize = 64
+
+func (s *arenaSlab[T]) alloc() *T {
+	for s.block < len(s.blocks) && s.next == len(s.blocks[s.block]) {
+		s.block++
+		s.next = 0
+	}
+	if s.block == len(s.blocks) {
+		size := arenaBlockSize
+		if len(s.blocks) > 0 {
+			size = 2 * len(s.blocks[len(s.blocks)-1])
+		}
+		s.blocks = append(s.blocks, make([]T, size))
+	}
+	n := &s.blocks[s.block][s.next]
+	s.next++
+	return n
+}
+
+func (s *arenaSlab[T]) reset() {
+	s.block = 0
+	s.next = 0
+}
+
+// arenaAlloc returns a node of type T from the Arena. The node can contain the values of a previous node.
+func arenaAlloc[T any](a *Arena) *T {
+	kind := kindOf[T]()
+	s, ok := a.slabs[kind].(*arenaSlab[T])
+	if !ok {
+		if a.slabs == nil {
+			a.slabs = make(map[NodeKind]arenaResetter)
+		}
+		s = &arenaSlab[T]{}
+		a.slabs[kind] = s
+	}
+	return s.alloc()
+}
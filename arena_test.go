@@ -0,0 +1,39 @@
+package formatting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	options := MessageParserOptions
+	options.Arena = NewArena()
+	parser := NewParser(&options)
+	reference := NewParser(&MessageParserOptions)
+	text := strings.Repeat("**bold _italics_** <@1234> https://example.com\n> quote\n", 50)
+	want := Debug(reference.Parse(text))
+	for i := 0; i < 3; i++ {
+		root := parser.Parse(text)
+		if got := Debug(root); got != want {
+			t.Errorf("parsing with an arena: want %s, got %s", want, got)
+		}
+		// parse a second message without resetting, which must not modify the first one
+		parser.Parse("__other__ message")
+		if got := Debug(root); got != want {
+			t.Errorf("parsing with an arena: message modified by the next parse: got %s", got)
+		}
+		options.Arena.Reset()
+	}
+}
+
+func BenchmarkParseArena(b *testing.B) {
+	source := strings.Repeat("Hello **world**, this is a _message_ with <@1234> and https://example.com links.\n", 100)
+	options := MessageParserOptions
+	options.Arena = NewArena()
+	parser := NewParser(&options)
+	b.SetBytes(int64(len(source)))
+	for i := 0; i < b.N; i++ {
+		parser.Parse(source)
+		options.Arena.Reset()
+	}
+}
@@ -0,0 +1,91 @@
+package formatting
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parseWorkers returns the maximum number of messages parsed at the same time by ParseAll and ParseStream.
+func (p *Parser) parseWorkers() int {
+	if p.options.Arena != nil {
+		// an Arena must not be used concurrently
+		return 1
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+/*
+ParseAll parses the passed Discord messages into ASTs concurrently, like Parse, and returns their root nodes
+in the same order as the messages.
+
+At most runtime.GOMAXPROCS(0) messages are parsed at the same time. If ParserOptions.Arena is set,
+the messages are parsed one at a time instead, as an Arena must not be used concurrently.
+*/
+func (p *Parser) ParseAll(sources []string) []Node {
+	nodes := make([]Node, len(sources))
+	workers := p.parseWorkers()
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				nodes[i] = p.Parse(sources[i])
+			}
+		}()
+	}
+	for i := range sources {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return nodes
+}
+
+/*
+ParseStream parses the Discord messages received from the passed channel into ASTs concurrently, like ParseAll,
+and sends their root nodes to the returned channel, in the same order as the messages.
+
+The returned channel is closed once the sources channel is closed and all its messages are parsed.
+Messages are only received from the sources channel as the root nodes are received from the returned channel,
+so that at most runtime.GOMAXPROCS(0) messages are parsed but not yet received.
+*/
+func (p *Parser) ParseStream(sources <-chan string) <-chan Node {
+	workers := p.parseWorkers()
+	// pending are the results of the messages being parsed, by order of the messages, including the one
+	// being waited for, so that at most workers messages are parsed at the same time
+	pending := make(chan chan Node, workers-1)
+	nodes := make(chan Node)
+	type job struct {
+		source string
+		result chan<- Node
+	}
+	jobs := make(chan job)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.result <- p.Parse(j.source)
+			}
+		}()
+	}
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for source := range sources {
+			result := make(chan Node, 1)
+			pending <- result
+			jobs <- job{source: source, result: result}
+		}
+	}()
+	go func() {
+		defer close(nodes)
+		for result := range pending {
+			nodes <- <-result
+		}
+	}()
+	return nodes
+}
@@ -0,0 +1,48 @@
+package formatting
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseAll(t *testing.T) {
+	sources := make([]string, 100)
+	for i := range sources {
+		sources[i] = "**" + strconv.Itoa(i) + "** <@" + strconv.Itoa(i) + ">"
+	}
+	arenaOptions := DefaultParserOptions
+	arenaOptions.Arena = NewArena()
+	for _, options := range []*ParserOptions{nil, &arenaOptions} {
+		p := NewParser(options)
+		nodes := p.ParseAll(sources)
+		if len(nodes) != len(sources) {
+			t.Fatalf("want %d nodes, got %d", len(sources), len(nodes))
+		}
+		for i, n := range nodes {
+			if want, got := Debug(NewParser(nil).Parse(sources[i])), Debug(n); got != want {
+				t.Errorf("source %q: want %s, got %s", sources[i], want, got)
+			}
+		}
+
+		in := make(chan string)
+		go func() {
+			for _, source := range sources {
+				in <- source
+			}
+			close(in)
+		}()
+		i := 0
+		for n := range p.ParseStream(in) {
+			if want, got := Debug(NewParser(nil).Parse(sources[i])), Debug(n); got != want {
+				t.Errorf("source %q: want %s, got %s", sources[i], want, got)
+			}
+			i++
+		}
+		if i != len(sources) {
+			t.Errorf("want %d nodes, got %d", len(sources), i)
+		}
+	}
+	if nodes := NewParser(nil).ParseAll(nil); len(nodes) != 0 {
+		t.Errorf("want no nodes, got %d", len(nodes))
+	}
+}
@@ -0,0 +1,37 @@
+package formatting
+
+/*
+BehaviorVersion is a version of the Discord markdown behavior, used to pin the parsing semantics
+with ParserOptions.BehaviorVersion.
+
+Discord changes how messages are rendered over time. Newer versions enable rules regardless of the
+other options, so that consumers can opt into newer behavior deliberately, with a single setting.
+*/
+type BehaviorVersion int
+
+const (
+	// BehaviorLegacy is the behavior before the 2023 markdown update, where headers and lists
+	// were only rendered in forum posts, and masked links only in embeds.
+	// Only the rules enabled by the other options are parsed. This is the default version.
+	BehaviorLegacy BehaviorVersion = iota
+	// Behavior2023 is the behavior since the 2023 markdown update, where headers, lists and masked links
+	// are rendered in all messages. Headers, lists and masked links are parsed regardless of
+	// EnableHeaders, EnableLists and EnableMaskedLinks.
+	Behavior2023
+
+	// BehaviorLatest is the latest behavior version. Its value changes as new versions are added,
+	// so it should only be used by consumers that always want the current Discord behavior.
+	BehaviorLatest = Behavior2023
+)
+
+func (o *ParserOptions) headers() bool {
+	return o.EnableHeaders || o.EnableForumMarkdown || o.BehaviorVersion >= Behavior2023
+}
+
+func (o *ParserOptions) lists() bool {
+	return o.EnableLists || o.EnableForumMarkdown || o.BehaviorVersion >= Behavior2023
+}
+
+func (o *ParserOptions) maskedLinks() bool {
+	return o.EnableMaskedLinks || o.BehaviorVersion >= Behavior2023
+}
@@ -0,0 +1,363 @@
+package formatting
+
+import (
+	"strconv"
+	"time"
+)
+
+/*
+NewRoot returns a new root Node, the container of a message, with the passed children.
+*/
+func NewRoot(children ...Node) Node {
+	root := &node{}
+	root.self = root
+	return withChildren(root, children)
+}
+
+// withChildren appends children to n, and returns n.
+func withChildren(n Node, children []Node) Node {
+	for _, c := range children {
+		n.AppendChild(c)
+	}
+	return n
+}
+
+/*
+NewText returns a new TextNode with the passed content.
+*/
+func NewText(content string) *TextNode {
+	return &TextNode{Content: content}
+}
+
+/*
+NewLineBreak returns a new LineBreakNode.
+*/
+func NewLineBreak() *LineBreakNode {
+	return &LineBreakNode{}
+}
+
+/*
+NewParagraph returns a new ParagraphNode with the passed children.
+*/
+func NewParagraph(children ...Node) *ParagraphNode {
+	n := &ParagraphNode{}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewBold returns a new BoldNode with the passed children.
+*/
+func NewBold(children ...Node) *BoldNode {
+	n := &BoldNode{}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewUnderline returns a new UnderlineNode with the passed children.
+*/
+func NewUnderline(children ...Node) *UnderlineNode {
+	n := &UnderlineNode{}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewItalics returns a new ItalicsNode with the passed children.
+*/
+func NewItalics(children ...Node) *ItalicsNode {
+	n := &ItalicsNode{}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewStrikethrough returns a new StrikethroughNode with the passed children.
+*/
+func NewStrikethrough(children ...Node) *StrikethroughNode {
+	n := &StrikethroughNode{}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewSpoiler returns a new SpoilerNode with the passed children.
+*/
+func NewSpoiler(children ...Node) *SpoilerNode {
+	n := &SpoilerNode{}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewBlockQuote returns a new BlockQuoteNode with the passed children, quoting the rest of the message.
+*/
+func NewBlockQuote(children ...Node) *BlockQuoteNode {
+	n := &BlockQuoteNode{Delimiter: ">>>"}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewHeader returns a new HeaderNode of the passed level, from 1 to 3, with the passed children.
+*/
+func NewHeader(level int, children ...Node) *HeaderNode {
+	n := &HeaderNode{Level: level}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewListItem returns a new BulletListNode, a list item ending with a newline, with the passed children.
+*/
+func NewListItem(children ...Node) *BulletListNode {
+	n := &BulletListNode{
+		NestedLevel:     1,
+		IncludesNewline: true,
+		Delimiter:       "-",
+	}
+	withChildren(n, children)
+	return n
+}
+
+/*
+NewCode returns a new inline CodeNode with the passed content.
+*/
+func NewCode(content string) *CodeNode {
+	return &CodeNode{
+		Content:   content,
+		Inline:    true,
+		Delimiter: "`",
+	}
+}
+
+/*
+NewCodeBlock returns a new code block CodeNode with the passed optional language and content.
+*/
+func NewCodeBlock(language string, content string) *CodeNode {
+	return &CodeNode{
+		Content:     content,
+		Language:    language,
+		RawLanguage: language,
+		Delimiter:   "```",
+	}
+}
+
+/*
+NewURL returns a new URLNode for the passed URL, which is displayed as is.
+*/
+func NewURL(url string) *URLNode {
+	return &URLNode{URL: url}
+}
+
+/*
+NewMaskedLink returns a new URLNode for the passed URL, which is displayed as mask.
+*/
+func NewMaskedLink(mask string, url string) *URLNode {
+	return &URLNode{URL: url, Mask: mask}
+}
+
+/*
+NewEmoji returns a new EmojiNode for the custom emoji with the passed name and ID.
+*/
+func NewEmoji(name string, id string, animated bool) *EmojiNode {
+	return &EmojiNode{Text: name, ID: id, Animated: animated}
+}
+
+/*
+NewNamedEmoji returns a new NamedEmojiNode for the emoji with the passed shortcode, without colons,
+and skin tone, from 1 to 5, or 0 for none.
+*/
+func NewNamedEmoji(name string, skinTone int) *NamedEmojiNode {
+	return &NamedEmojiNode{Name: name, SkinTone: skinTone}
+}
+
+/*
+NewUserMention returns a new UserMentionNode for the user with the passed ID.
+*/
+func NewUserMention(id string) *UserMentionNode {
+	return &UserMentionNode{ID: id}
+}
+
+/*
+NewRoleMention returns a new RoleMentionNode for the role with the passed ID.
+*/
+func NewRoleMention(id string) *RoleMentionNode {
+	return &RoleMentionNode{ID: id}
+}
+
+/*
+NewChannelMention returns a new ChannelMentionNode for the channel with the passed ID.
+*/
+func NewChannelMention(id string) *ChannelMentionNode {
+	return &ChannelMentionNode{ID: id}
+}
+
+/*
+NewSpecialMention returns a new SpecialMentionNode for the passed mention: everyone or here.
+*/
+func NewSpecialMention(mention string) *SpecialMentionNode {
+	return &SpecialMentionNode{Mention: mention}
+}
+
+/*
+NewTimestamp returns a new TimestampNode for the passed time, displayed with the passed style.
+The style can be empty, which is displayed as TimestampShortDateTime.
+*/
+func NewTimestamp(t time.Time, style TimestampStyle) *TimestampNode {
+	return &TimestampNode{
+		Stamp:  strconv.FormatInt(t.Unix(), 10),
+		Format: string(style),
+	}
+}
+
+/*
+MessageBuilder builds a message AST by appending nodes to it, and serializes it to a Discord message with String.
+Its methods return the MessageBuilder, so that calls can be chained:
+
+	s := NewMessageBuilder().Bold("Hi").Text(" ").UserMention(id).Text(", welcome!").String()
+
+The zero value is not ready to use: create a MessageBuilder with NewMessageBuilder.
+*/
+type MessageBuilder struct {
+	root Node
+}
+
+/*
+NewMessageBuilder returns a new empty MessageBuilder.
+*/
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{
+		root: NewRoot(),
+	}
+}
+
+/*
+Add appends the passed nodes to the message.
+*/
+func (b *MessageBuilder) Add(nodes ...Node) *MessageBuilder {
+	withChildren(b.root, nodes)
+	return b
+}
+
+/*
+Text appends text to the message, which is escaped when serialized.
+*/
+func (b *MessageBuilder) Text(text string) *MessageBuilder {
+	return b.Add(NewText(text))
+}
+
+/*
+Line appends text followed by a newline to the message.
+*/
+func (b *MessageBuilder) Line(text string) *MessageBuilder {
+	return b.Add(NewText(text + "\n"))
+}
+
+/*
+Bold appends bold text to the message.
+*/
+func (b *MessageBuilder) Bold(text string) *MessageBuilder {
+	return b.Add(NewBold(NewText(text)))
+}
+
+/*
+Italics appends italic text to the message.
+*/
+func (b *MessageBuilder) Italics(text string) *MessageBuilder {
+	return b.Add(NewItalics(NewText(text)))
+}
+
+/*
+Underline appends underlined text to the message.
+*/
+func (b *MessageBuilder) Underline(text string) *MessageBuilder {
+	return b.Add(NewUnderline(NewText(text)))
+}
+
+/*
+Strikethrough appends struck text to the message.
+*/
+func (b *MessageBuilder) Strikethrough(text string) *MessageBuilder {
+	return b.Add(NewStrikethrough(NewText(text)))
+}
+
+/*
+Spoiler appends text hidden as a spoiler to the message.
+*/
+func (b *MessageBuilder) Spoiler(text string) *MessageBuilder {
+	return b.Add(NewSpoiler(NewText(text)))
+}
+
+/*
+Code appends inline code to the message.
+*/
+func (b *MessageBuilder) Code(code string) *MessageBuilder {
+	return b.Add(NewCode(code))
+}
+
+/*
+CodeBlock appends a code block with an optional language to the message.
+*/
+func (b *MessageBuilder) CodeBlock(language string, code string) *MessageBuilder {
+	return b.Add(NewCodeBlock(language, code))
+}
+
+/*
+Link appends a link to the message, displayed as mask if it is not empty.
+*/
+func (b *MessageBuilder) Link(mask string, url string) *MessageBuilder {
+	return b.Add(NewMaskedLink(mask, url))
+}
+
+/*
+UserMention appends a mention of the user with the passed ID to the message.
+*/
+func (b *MessageBuilder) UserMention(id string) *MessageBuilder {
+	return b.Add(NewUserMention(id))
+}
+
+/*
+RoleMention appends a mention of the role with the passed ID to the message.
+*/
+func (b *MessageBuilder) RoleMention(id string) *MessageBuilder {
+	return b.Add(NewRoleMention(id))
+}
+
+/*
+ChannelMention appends a mention of the channel with the passed ID to the message.
+*/
+func (b *MessageBuilder) ChannelMention(id string) *MessageBuilder {
+	return b.Add(NewChannelMention(id))
+}
+
+/*
+Emoji appends the custom emoji with the passed name and ID to the message.
+*/
+func (b *MessageBuilder) Emoji(name string, id string, animated bool) *MessageBuilder {
+	return b.Add(NewEmoji(name, id, animated))
+}
+
+/*
+Timestamp appends a timestamp displayed with the passed style to the message.
+*/
+func (b *MessageBuilder) Timestamp(t time.Time, style TimestampStyle) *MessageBuilder {
+	return b.Add(NewTimestamp(t, style))
+}
+
+/*
+Node returns the root of the message AST built so far. Later calls to the MessageBuilder modify it.
+*/
+func (b *MessageBuilder) Node() Node {
+	return b.root
+}
+
+/*
+String serializes the message built so far with RenderMarkdown, with minimal escaping.
+*/
+func (b *MessageBuilder) String() string {
+	return RenderMarkdown(b.root, &MarkdownOptions{
+		MinimalEscaping: true,
+	})
+}
@@ -0,0 +1,45 @@
+package formatting
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewNodes(t *testing.T) {
+	root := NewRoot(
+		NewHeader(1, NewText("title")),
+		NewText("\n"),
+		NewBold(NewText("a "), NewItalics(NewText("b"))),
+		NewSpoiler(NewUserMention("1")),
+		NewMaskedLink("mask", "https://example.com"),
+		NewCodeBlock("go", "x"),
+	)
+	want := `[[header 1 [text "title"]] [text "\n"] [bold [text "a "] [italics [text "b"]]] [spoiler [usermention "1"]] [url "mask" "https://example.com"] [code "go" "x"]]`
+	if got := Debug(root); got != want {
+		t.Errorf("error building nodes: want %s, got %s", want, got)
+	}
+	if root.Children()[2].Children()[1].Parent() != root.Children()[2] {
+		t.Errorf("unexpected parent of built node")
+	}
+}
+
+func TestMessageBuilder(t *testing.T) {
+	got := NewMessageBuilder().
+		Bold("Hi").
+		Text(" ").
+		UserMention("1234").
+		Line(", 2*3 = *6*").
+		Code("go run").
+		Text(" at ").
+		Timestamp(time.Unix(1234567890, 0), TimestampRelative).
+		String()
+	want := "**Hi** <@1234>, 2\\*3 = \\*6*\n`go run` at <t:1234567890:R>"
+	if got != want {
+		t.Errorf("error building message: want %q, got %q", want, got)
+	}
+	root := NewParser(nil).Parse(got)
+	MergeText(root)
+	if got, want := Text(root), "Hi @1234, 2*3 = *6*\ngo run at <t:1234567890:R>"; got != want {
+		t.Errorf("error parsing built message: want %q, got %q", want, got)
+	}
+}
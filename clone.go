@@ -0,0 +1,125 @@
+package formatting
+
+import "fmt"
+
+/*
+Clone returns a deep copy of the passed Node and all its children.
+
+The copy has the same spans and attributes as the original, and its own parent and sibling links,
+the copied Node being its root. Attribute values are copied shallowly.
+Modifying the copy does not modify the original tree. Clone returns nil if the Node is nil.
+*/
+func Clone(n Node) Node {
+	if n == nil {
+		return nil
+	}
+	var root Node
+	// parents are the copies of the nodes being walked
+	var parents []Node
+	walk(n, func(n Node, entering bool) WalkStatus {
+		if !entering {
+			parents = parents[:len(parents)-1]
+			return WalkContinue
+		}
+		c := cloneNode(n)
+		if len(parents) == 0 {
+			root = c
+		} else {
+			parents[len(parents)-1].AppendChild(c)
+		}
+		parents = append(parents, c)
+		return WalkContinue
+	})
+	return root
+}
+
+// cloneNode returns a copy of n without its children, and without parent and siblings.
+func cloneNode(n Node) Node {
+	var c Node
+	switch n := n.(type) {
+	case *node:
+		cc := *n
+		c = &cc
+	case *TextNode:
+		cc := *n
+		c = &cc
+	case *BlockQuoteNode:
+		cc := *n
+		c = &cc
+	case *CodeNode:
+		cc := *n
+		c = &cc
+	case *SpoilerNode:
+		cc := *n
+		c = &cc
+	case *URLNode:
+		cc := *n
+		c = &cc
+	case *EmojiNode:
+		cc := *n
+		c = &cc
+	case *NamedEmojiNode:
+		cc := *n
+		c = &cc
+	case *ChannelMentionNode:
+		cc := *n
+		c = &cc
+	case *RoleMentionNode:
+		cc := *n
+		c = &cc
+	case *UserMentionNode:
+		cc := *n
+		c = &cc
+	case *SpecialMentionNode:
+		cc := *n
+		c = &cc
+	case *TimestampNode:
+		cc := *n
+		c = &cc
+	case *UnknownTagNode:
+		cc := *n
+		c = &cc
+	case *HeaderNode:
+		cc := *n
+		c = &cc
+	case *BulletListNode:
+		cc := *n
+		c = &cc
+	case *BoldNode:
+		cc := *n
+		c = &cc
+	case *UnderlineNode:
+		cc := *n
+		c = &cc
+	case *ItalicsNode:
+		cc := *n
+		c = &cc
+	case *StrikethroughNode:
+		cc := *n
+		c = &cc
+	case *HighlightNode:
+		cc := *n
+		c = &cc
+	case *LineBreakNode:
+		cc := *n
+		c = &cc
+	case *ParagraphNode:
+		cc := *n
+		c = &cc
+	default:
+		panic(fmt.Sprintf("invalid node type: %T", n))
+	}
+	b := c.base()
+	b.children = nil
+	b.self = c
+	b.parent = nil
+	b.index = 0
+	if b.attrs != nil {
+		attrs := make(map[string]any, len(b.attrs))
+		for k, v := range b.attrs {
+			attrs[k] = v
+		}
+		b.attrs = attrs
+	}
+	return c
+}
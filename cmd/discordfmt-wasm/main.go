@@ -0,0 +1,96 @@
+//go:build js && wasm
+
+/*
+Command discordfmt-wasm exposes the parser and renderers to JavaScript, for example to preview Discord messages
+in a web frontend with the exact same rendering as a Go backend.
+
+Build it with:
+
+	GOOS=js GOARCH=wasm go build -o discordfmt.wasm ./cmd/discordfmt-wasm
+
+and load it with the wasm_exec.js support file of the Go distribution. It defines a global discordFormatting object
+with the following functions, whose optional preset argument is one of default, message, forum, embed, embed-title
+or bio, and defaults to default:
+
+	debug(text, preset)          the AST, formatted with Debug
+	json(text, preset)           the AST, serialized with MarshalJSON
+	renderHTML(text, preset)     the message rendered to HTML
+	renderANSI(text, preset)     the message rendered with ANSI escape sequences
+	renderIRC(text, preset)      the message rendered with IRC formatting codes
+	text(text, preset)           the plain text of the message
+	visibleLength(text, preset)  the visible length of the message
+	escape(text)                 the text escaped with Escape
+*/
+package main
+
+import (
+	"syscall/js"
+
+	formatting "github.com/delthas/discord-formatting"
+)
+
+var presets = map[string]*formatting.ParserOptions{
+	"default":     &formatting.DefaultParserOptions,
+	"message":     &formatting.MessageParserOptions,
+	"forum":       &formatting.ForumPostParserOptions,
+	"embed":       &formatting.EmbedDescriptionParserOptions,
+	"embed-title": &formatting.EmbedTitleParserOptions,
+	"bio":         &formatting.BioParserOptions,
+}
+
+// parse parses the message of the first argument, with the preset of the optional second argument.
+func parse(args []js.Value) formatting.Node {
+	if len(args) == 0 {
+		return formatting.NewRoot()
+	}
+	options := presets["default"]
+	if len(args) > 1 && args[1].Type() == js.TypeString {
+		if o, ok := presets[args[1].String()]; ok {
+			options = o
+		}
+	}
+	return formatting.NewParser(options).Parse(args[0].String())
+}
+
+func export(name string, fn func(args []js.Value) any) {
+	js.Global().Get("discordFormatting").Set(name, js.FuncOf(func(this js.Value, args []js.Value) any {
+		return fn(args)
+	}))
+}
+
+func main() {
+	js.Global().Set("discordFormatting", js.ValueOf(map[string]any{}))
+	export("debug", func(args []js.Value) any {
+		return formatting.Debug(parse(args))
+	})
+	export("json", func(args []js.Value) any {
+		b, err := formatting.MarshalJSON(parse(args))
+		if err != nil {
+			return js.Null()
+		}
+		return string(b)
+	})
+	export("renderHTML", func(args []js.Value) any {
+		return formatting.RenderHTML(parse(args), nil)
+	})
+	export("renderANSI", func(args []js.Value) any {
+		return formatting.RenderANSI(parse(args), nil)
+	})
+	export("renderIRC", func(args []js.Value) any {
+		return formatting.RenderIRC(parse(args), nil)
+	})
+	export("text", func(args []js.Value) any {
+		return formatting.Text(parse(args))
+	})
+	export("visibleLength", func(args []js.Value) any {
+		return formatting.VisibleLength(parse(args), nil)
+	})
+	export("escape", func(args []js.Value) any {
+		if len(args) == 0 {
+			return ""
+		}
+		return formatting.Escape(args[0].String())
+	})
+	// keep the functions available
+	select {}
+}
@@ -0,0 +1,126 @@
+/*
+Command discordfmt parses a Discord message and prints its AST, or renders it to another format.
+
+The message is read from the arguments, joined with spaces, or from the standard input if there are none,
+without its final newline.
+
+Usage:
+
+	discordfmt [flags] [message...]
+
+Flags:
+
+	-format string
+		output format: debug, json, html, ansi, irc, plain or markdown (default "debug")
+	-preset string
+		parser options preset: default, message, forum, embed, embed-title or bio (default "default")
+	-disable string
+		comma-separated list of rules to disable, such as spoiler,header
+	-inline-only
+		only parse inline formatting
+	-unknown-tags
+		parse unknown tags such as <x:y>
+	-merge-text
+		merge adjacent text nodes
+	-max-depth int
+		maximum nesting depth of the AST, or 0 for the default
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	formatting "github.com/delthas/discord-formatting"
+)
+
+var presets = map[string]*formatting.ParserOptions{
+	"default":     &formatting.DefaultParserOptions,
+	"message":     &formatting.MessageParserOptions,
+	"forum":       &formatting.ForumPostParserOptions,
+	"embed":       &formatting.EmbedDescriptionParserOptions,
+	"embed-title": &formatting.EmbedTitleParserOptions,
+	"bio":         &formatting.BioParserOptions,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("discordfmt: ")
+
+	format := flag.String("format", "debug", "output format: debug, json, html, ansi, irc, plain or markdown")
+	preset := flag.String("preset", "default", "parser options preset: default, message, forum, embed, embed-title or bio")
+	disable := flag.String("disable", "", "comma-separated list of rules to disable, such as spoiler,header")
+	inlineOnly := flag.Bool("inline-only", false, "only parse inline formatting")
+	unknownTags := flag.Bool("unknown-tags", false, "parse unknown tags such as <x:y>")
+	mergeText := flag.Bool("merge-text", false, "merge adjacent text nodes")
+	maxDepth := flag.Int("max-depth", 0, "maximum nesting depth of the AST, or 0 for the default")
+	flag.Parse()
+
+	base, ok := presets[*preset]
+	if !ok {
+		log.Fatalf("unknown preset: %q", *preset)
+	}
+	options := *base
+	if *disable != "" {
+		options.DisabledRules = strings.Split(*disable, ",")
+	}
+	options.InlineOnly = options.InlineOnly || *inlineOnly
+	options.EnableUnknownTags = options.EnableUnknownTags || *unknownTags
+	options.MergeText = options.MergeText || *mergeText
+	if *maxDepth > 0 {
+		options.MaxDepth = *maxDepth
+	}
+
+	var text string
+	if flag.NArg() > 0 {
+		text = strings.Join(flag.Args(), " ")
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("reading message: %v", err)
+		}
+		// ignore the newline ending the input of most shell commands
+		text = strings.TrimSuffix(string(b), "\n")
+	}
+
+	root, err := formatting.NewParser(&options).ParseStrict(text)
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+
+	switch *format {
+	case "debug":
+		fmt.Println(formatting.Debug(root))
+	case "json":
+		b, err := formatting.MarshalJSON(root)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, b, "", "  "); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(out.String())
+	case "html":
+		fmt.Println(formatting.RenderHTML(root, nil))
+	case "ansi":
+		fmt.Println(formatting.RenderANSI(root, nil))
+	case "irc":
+		fmt.Println(formatting.RenderIRC(root, nil))
+	case "plain":
+		fmt.Println(formatting.Text(root))
+	case "markdown":
+		fmt.Println(formatting.RenderMarkdown(root, &formatting.MarkdownOptions{
+			MinimalEscaping: true,
+			Parser:          &options,
+		}))
+	default:
+		log.Fatalf("unknown format: %q", *format)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Package discordgofmt parses the content of discordgo messages to a formatting AST.
+
+It is a separate module, so that the formatting package does not depend on discordgo.
+
+The main entrypoint is Parse, which parses the content, embeds and forwarded messages of a *discordgo.Message
+with the parser options matching how Discord renders each of them, and resolves the names of its mentions.
+*/
+package discordgofmt
+
+import (
+	"github.com/bwmarrin/discordgo"
+	formatting "github.com/delthas/discord-formatting"
+)
+
+/*
+AttrName is the attribute key set by Parse on the mention nodes whose display name is known, without the @ or # prefix.
+Its value is a string.
+*/
+const AttrName = "name"
+
+/*
+Message is the parsed content of a discordgo Message.
+*/
+type Message struct {
+	// Content is the parsed content of the message.
+	Content formatting.Node
+	// Embeds are the parsed embeds of the message.
+	Embeds []*Embed
+	// Attachments are the files attached to the message, as links with the file name as their Mask.
+	Attachments []*formatting.URLNode
+	// Snapshots are the parsed forwarded messages.
+	Snapshots []*Message
+}
+
+/*
+Embed is the parsed content of a discordgo MessageEmbed.
+
+Title and field names are parsed with formatting.EmbedTitleParserOptions, and the description and field values
+with formatting.EmbedDescriptionParserOptions. Nodes of empty parts are nil.
+*/
+type Embed struct {
+	Title       formatting.Node
+	Description formatting.Node
+	Fields      []*EmbedField
+}
+
+/*
+EmbedField is the parsed content of a discordgo MessageEmbedField.
+*/
+type EmbedField struct {
+	Name   formatting.Node
+	Value  formatting.Node
+	Inline bool
+}
+
+/*
+Options is a configuration object used for parsing messages with Parse.
+
+An empty Options, or passing nil instead, is the default configuration.
+*/
+type Options struct {
+	// State is an optional discordgo State, used to resolve the names of mentioned roles and channels,
+	// and the server nicknames of mentioned users. If nil, only users mentioned in the message are resolved.
+	State *discordgo.State
+}
+
+/*
+Parse parses the content, embeds and forwarded messages of m, and lists its attachments.
+
+The message content is parsed with formatting.MessageParserOptions. The mention nodes whose name is known
+by the resolver returned by NewResolver have their name set as their AttrName attribute, so that they can be displayed
+without further lookups.
+
+The options parameter can be nil, which is equivalent to passing an empty Options.
+*/
+func Parse(m *discordgo.Message, options *Options) *Message {
+	if options == nil {
+		options = &Options{}
+	}
+	return parse(m, NewResolver(m, options.State))
+}
+
+func parse(m *discordgo.Message, r formatting.Resolver) *Message {
+	message := &Message{
+		Content: parseText(m.Content, &formatting.MessageParserOptions, r),
+	}
+	for _, e := range m.Embeds {
+		embed := &Embed{
+			Title:       parseText(e.Title, &formatting.EmbedTitleParserOptions, r),
+			Description: parseText(e.Description, &formatting.EmbedDescriptionParserOptions, r),
+		}
+		for _, f := range e.Fields {
+			embed.Fields = append(embed.Fields, &EmbedField{
+				Name:   parseText(f.Name, &formatting.EmbedTitleParserOptions, r),
+				Value:  parseText(f.Value, &formatting.EmbedDescriptionParserOptions, r),
+				Inline: f.Inline,
+			})
+		}
+		message.Embeds = append(message.Embeds, embed)
+	}
+	for _, a := range m.Attachments {
+		message.Attachments = append(message.Attachments, &formatting.URLNode{
+			URL:  a.URL,
+			Mask: a.Filename,
+		})
+	}
+	for _, s := range m.MessageSnapshots {
+		if s.Message == nil {
+			continue
+		}
+		// forwarded messages can be from other servers, whose members and roles are not resolved
+		message.Snapshots = append(message.Snapshots, parse(s.Message, NewResolver(s.Message, nil)))
+	}
+	return message
+}
+
+func parseText(text string, options *formatting.ParserOptions, r formatting.Resolver) formatting.Node {
+	if text == "" {
+		return nil
+	}
+	root := formatting.NewParser(options).Parse(text)
+	formatting.Walk(root, func(n formatting.Node, entering bool) {
+		if !entering {
+			return
+		}
+		var name string
+		switch n := n.(type) {
+		case *formatting.UserMentionNode:
+			name = r.UserName(n.ID)
+		case *formatting.RoleMentionNode:
+			name = r.RoleName(n.ID)
+		case *formatting.ChannelMentionNode:
+			name = r.ChannelName(n.ID)
+		}
+		if name != "" {
+			n.SetAttr(AttrName, name)
+		}
+	})
+	return root
+}
+
+/*
+NewResolver returns a formatting.Resolver for the mentions of m, for example to be used with formatting.ResolveMentionsPass.
+
+Users are resolved from the users mentioned in m, and from state, if it is not nil. Roles and channels are resolved
+from state only, except for the channels of crossposted messages, which are listed in m.
+*/
+func NewResolver(m *discordgo.Message, state *discordgo.State) formatting.Resolver {
+	return &resolver{
+		message: m,
+		state:   state,
+	}
+}
+
+type resolver struct {
+	message *discordgo.Message
+	state   *discordgo.State
+}
+
+func (r *resolver) UserName(id string) string {
+	if m, err := r.state.Member(r.message.GuildID, id); err == nil && m.User != nil {
+		return m.DisplayName()
+	}
+	for _, u := range r.message.Mentions {
+		if u.ID == id {
+			return u.DisplayName()
+		}
+	}
+	return ""
+}
+
+func (r *resolver) RoleName(id string) string {
+	if role, err := r.state.Role(r.message.GuildID, id); err == nil {
+		return role.Name
+	}
+	return ""
+}
+
+func (r *resolver) ChannelName(id string) string {
+	for _, c := range r.message.MentionChannels {
+		if c.ID == id {
+			return c.Name
+		}
+	}
+	if c, err := r.state.Channel(id); err == nil {
+		return c.Name
+	}
+	return ""
+}
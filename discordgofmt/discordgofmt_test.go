@@ -0,0 +1,69 @@
+package discordgofmt
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	formatting "github.com/delthas/discord-formatting"
+)
+
+func TestParse(t *testing.T) {
+	m := &discordgo.Message{
+		GuildID: "1",
+		Content: "hi <@2> and <@3> in <#4>",
+		Mentions: []*discordgo.User{
+			{ID: "2", Username: "alice"},
+			{ID: "3", Username: "bob", GlobalName: "Bob"},
+		},
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "**title**",
+			Description: "[link](https://example.com)",
+			Fields: []*discordgo.MessageEmbedField{{
+				Name:  "name",
+				Value: "value",
+			}},
+		}},
+		Attachments: []*discordgo.MessageAttachment{{
+			URL:      "https://cdn.discordapp.com/attachments/1/2/cat.png",
+			Filename: "cat.png",
+		}},
+		MessageSnapshots: []discordgo.MessageSnapshot{{
+			Message: &discordgo.Message{Content: "*forwarded*"},
+		}},
+	}
+	message := Parse(m, nil)
+
+	mentions := formatting.FindAll[*formatting.UserMentionNode](message.Content)
+	name := func(n formatting.Node) any {
+		name, _ := n.Attr(AttrName)
+		return name
+	}
+	if len(mentions) != 2 || name(mentions[0]) != "alice" || name(mentions[1]) != "Bob" {
+		t.Errorf("error resolving user mentions: got %s", formatting.Debug(message.Content))
+	}
+	if c := formatting.FindAll[*formatting.ChannelMentionNode](message.Content); len(c) != 1 || name(c[0]) != nil {
+		t.Errorf("unexpected channel mention resolution: got %s", formatting.Debug(message.Content))
+	}
+	root := formatting.ResolveMentionsPass(NewResolver(m, nil))(message.Content)
+	if got, want := formatting.Text(root), "hi @alice and @Bob in #unknown"; got != want {
+		t.Errorf("error resolving mentions: want %q, got %q", want, got)
+	}
+
+	if len(message.Embeds) != 1 || len(message.Embeds[0].Fields) != 1 {
+		t.Fatalf("error parsing embeds: got %d embeds", len(message.Embeds))
+	}
+	embed := message.Embeds[0]
+	if got, want := formatting.Debug(embed.Title), `[[bold [text "title"]]]`; got != want {
+		t.Errorf("error parsing embed title: want %s, got %s", want, got)
+	}
+	if got, want := formatting.Debug(embed.Description), `[[url "link" "https://example.com"]]`; got != want {
+		t.Errorf("error parsing embed description: want %s, got %s", want, got)
+	}
+
+	if len(message.Attachments) != 1 || message.Attachments[0].Mask != "cat.png" {
+		t.Errorf("error listing attachments: got %v", message.Attachments)
+	}
+	if len(message.Snapshots) != 1 || formatting.Debug(message.Snapshots[0].Content) != `[[italics [text "forwarded"]]]` {
+		t.Errorf("error parsing forwarded messages: got %v", message.Snapshots)
+	}
+}
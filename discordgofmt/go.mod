@@ -0,0 +1,15 @@
+module github.com/delthas/discord-formatting/discordgofmt
+
+go 1.18
+
+require (
+	github.com/bwmarrin/discordgo v0.29.0
+	github.com/delthas/discord-formatting v0.0.0-20261016151629-267fe1d31ab5
+)
+
+require (
+	github.com/gorilla/websocket v1.4.2 // indirect
+	golang.org/x/crypto v0.9.0 // indirect
+)
+
+replace github.com/delthas/discord-formatting => ../
@@ -0,0 +1,140 @@
+package formatting
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// emojiNames are the Discord shortcodes of the most common unicode emoji, without their variation selectors.
+var emojiNames = []struct {
+	emoji string
+	name  string
+}{
+	// smileys
+	{"😀", "grinning"}, {"😃", "smiley"}, {"😄", "smile"}, {"😁", "grin"}, {"😆", "laughing"},
+	{"😅", "sweat_smile"}, {"🤣", "rofl"}, {"😂", "joy"}, {"🙂", "slight_smile"}, {"🙃", "upside_down"},
+	{"🫠", "melting_face"}, {"😉", "wink"}, {"😊", "blush"}, {"😇", "innocent"}, {"🥰", "smiling_face_with_3_hearts"},
+	{"😍", "heart_eyes"}, {"🤩", "star_struck"}, {"😘", "kissing_heart"}, {"😋", "yum"}, {"😛", "stuck_out_tongue"},
+	{"😜", "stuck_out_tongue_winking_eye"}, {"🤪", "zany_face"}, {"😝", "stuck_out_tongue_closed_eyes"},
+	{"🤑", "money_mouth"}, {"🤗", "hugging"}, {"🤭", "face_with_hand_over_mouth"}, {"🫡", "saluting_face"},
+	{"🤫", "shushing_face"}, {"🤔", "thinking"}, {"🤐", "zipper_mouth"}, {"🤨", "face_with_raised_eyebrow"},
+	{"😐", "neutral_face"}, {"😑", "expressionless"}, {"😶", "no_mouth"}, {"😏", "smirk"}, {"😒", "unamused"},
+	{"🙄", "rolling_eyes"}, {"😬", "grimacing"}, {"🤥", "lying_face"}, {"😌", "relieved"}, {"😔", "pensive"},
+	{"😪", "sleepy"}, {"🤤", "drooling_face"}, {"😴", "sleeping"}, {"😷", "mask"}, {"🤒", "thermometer_face"},
+	{"🤕", "head_bandage"}, {"🤢", "nauseated_face"}, {"🤮", "face_vomiting"}, {"🤧", "sneezing_face"},
+	{"🥵", "hot_face"}, {"🥶", "cold_face"}, {"🥴", "woozy_face"}, {"😵", "dizzy_face"}, {"🤯", "exploding_head"},
+	{"🤠", "cowboy"}, {"🥳", "partying_face"}, {"😎", "sunglasses"}, {"🤓", "nerd"}, {"🧐", "face_with_monocle"},
+	{"😕", "confused"}, {"😟", "worried"}, {"🙁", "slight_frown"}, {"☹", "frowning2"}, {"😮", "open_mouth"},
+	{"😯", "hushed"}, {"😲", "astonished"}, {"😳", "flushed"}, {"🥺", "pleading_face"}, {"🥹", "face_holding_back_tears"},
+	{"😦", "frowning"}, {"😧", "anguished"}, {"😨", "fearful"}, {"😰", "cold_sweat"}, {"😥", "disappointed_relieved"},
+	{"😢", "cry"}, {"😭", "sob"}, {"😱", "scream"}, {"😖", "confounded"}, {"😣", "persevere"}, {"😞", "disappointed"},
+	{"😓", "sweat"}, {"😩", "weary"}, {"😫", "tired_face"}, {"🥱", "yawning_face"}, {"😤", "triumph"}, {"😡", "rage"},
+	{"😠", "angry"}, {"🤬", "face_with_symbols_over_mouth"}, {"😈", "smiling_imp"}, {"👿", "imp"}, {"💀", "skull"},
+	{"☠", "skull_crossbones"}, {"💩", "poop"}, {"🤡", "clown"}, {"👹", "japanese_ogre"}, {"👺", "japanese_goblin"},
+	{"👻", "ghost"}, {"👽", "alien"}, {"👾", "space_invader"}, {"🤖", "robot"}, {"🙈", "see_no_evil"},
+	{"🙉", "hear_no_evil"}, {"🙊", "speak_no_evil"},
+	// people
+	{"👋", "wave"}, {"🤚", "raised_back_of_hand"}, {"✋", "raised_hand"}, {"🖖", "vulcan"}, {"👌", "ok_hand"},
+	{"🤏", "pinching_hand"}, {"✌", "v"}, {"🤞", "fingers_crossed"}, {"🤟", "love_you_gesture"}, {"🤘", "metal"},
+	{"🤙", "call_me"}, {"👈", "point_left"}, {"👉", "point_right"}, {"👆", "point_up_2"}, {"👇", "point_down"},
+	{"☝", "point_up"}, {"👍", "thumbsup"}, {"👎", "thumbsdown"}, {"✊", "fist"}, {"👊", "punch"}, {"👏", "clap"},
+	{"🙌", "raised_hands"}, {"👐", "open_hands"}, {"🤲", "palms_up_together"}, {"🤝", "handshake"}, {"🙏", "pray"},
+	{"✍", "writing_hand"}, {"💪", "muscle"}, {"🧠", "brain"}, {"👀", "eyes"}, {"👁", "eye"}, {"👅", "tongue"},
+	{"👄", "lips"}, {"🤷", "person_shrugging"}, {"🤦", "person_facepalming"},
+	// hearts and symbols
+	{"❤", "heart"}, {"🧡", "orange_heart"}, {"💛", "yellow_heart"}, {"💚", "green_heart"}, {"💙", "blue_heart"},
+	{"💜", "purple_heart"}, {"🖤", "black_heart"}, {"🤍", "white_heart"}, {"🤎", "brown_heart"}, {"💔", "broken_heart"},
+	{"❤\u200d🔥", "heart_on_fire"}, {"❣", "heart_exclamation"}, {"💕", "two_hearts"}, {"💞", "revolving_hearts"},
+	{"💓", "heartbeat"}, {"💗", "heartpulse"}, {"💖", "sparkling_heart"}, {"💘", "cupid"}, {"💝", "gift_heart"},
+	{"💯", "100"}, {"💢", "anger"}, {"💥", "boom"}, {"💫", "dizzy"}, {"💦", "sweat_drops"}, {"💨", "dash"},
+	{"💬", "speech_balloon"}, {"💤", "zzz"}, {"✅", "white_check_mark"}, {"❌", "x"}, {"❎", "negative_squared_cross_mark"},
+	{"⚠", "warning"}, {"❗", "exclamation"}, {"❓", "question"}, {"➕", "heavy_plus_sign"}, {"➖", "heavy_minus_sign"},
+	{"🆗", "ok"}, {"🆕", "new"}, {"🆒", "cool"}, {"🔴", "red_circle"}, {"🟢", "green_circle"}, {"🔵", "blue_circle"},
+	{"⬆", "arrow_up"}, {"⬇", "arrow_down"}, {"⬅", "arrow_left"}, {"➡", "arrow_right"},
+	// objects, nature and food
+	{"🔥", "fire"}, {"✨", "sparkles"}, {"⭐", "star"}, {"🌟", "star2"}, {"🎉", "tada"}, {"🎊", "confetti_ball"},
+	{"🎁", "gift"}, {"🏆", "trophy"}, {"🚀", "rocket"}, {"💡", "bulb"}, {"📌", "pushpin"}, {"🔗", "link"},
+	{"🔒", "lock"}, {"🔑", "key"}, {"⏰", "alarm_clock"}, {"⌛", "hourglass"}, {"📅", "date"}, {"📝", "pencil"},
+	{"🎵", "musical_note"}, {"🎶", "notes"}, {"🍕", "pizza"}, {"🍔", "hamburger"}, {"🍺", "beer"}, {"🍻", "beers"},
+	{"☕", "coffee"}, {"🍰", "cake"}, {"🎂", "birthday"}, {"🐶", "dog"}, {"🐱", "cat"}, {"🐍", "snake"}, {"🦀", "crab"},
+	{"🐛", "bug"}, {"🌈", "rainbow"}, {"☀", "sunny"}, {"🌙", "crescent_moon"}, {"⚡", "zap"}, {"❄", "snowflake"},
+	{"🌍", "earth_africa"},
+	// flags
+	{"🏳\u200d🌈", "rainbow_flag"}, {"🇺🇸", "flag_us"}, {"🇬🇧", "flag_gb"}, {"🇫🇷", "flag_fr"}, {"🇩🇪", "flag_de"},
+	{"🇯🇵", "flag_jp"},
+}
+
+/*
+URL returns the URL of the image of the custom emoji on the Discord CDN, such as https://cdn.discordapp.com/emojis/1234.png.
+
+format is the image format, such as "png", "webp" or "gif". If empty, animated emoji are returned as gif,
+and other emoji as png. size, if not zero, is the requested size of the image in pixels, which Discord expects
+to be a power of two from 16 to 4096.
+*/
+func (n *EmojiNode) URL(format string, size int) string {
+	if format == "" {
+		format = "png"
+		if n.Animated {
+			format = "gif"
+		}
+	}
+	u := "https://cdn.discordapp.com/emojis/" + url.PathEscape(n.ID) + "." + format
+	if size != 0 {
+		u += "?size=" + strconv.Itoa(size)
+	}
+	return u
+}
+
+var emojiShortcodesOnce sync.Once
+
+// emojiShortcodes are the shortcodes of emojiNames, by emoji.
+var emojiShortcodes map[string]string
+
+// stripVariationSelectors returns emoji without its emoji and text variation selectors.
+func stripVariationSelectors(emoji string) string {
+	if !strings.ContainsAny(emoji, "\ufe0e\ufe0f") {
+		return emoji
+	}
+	return strings.NewReplacer("\ufe0e", "", "\ufe0f", "").Replace(emoji)
+}
+
+/*
+EmojiShortcode returns the Discord shortcode of the passed unicode emoji, such as :slight_smile: for 🙂, for example
+to display emoji in plain text or on IRC, where they may not be displayed. ok is false if the emoji is not known.
+
+Variation selectors are ignored, and skin tones are returned as in Discord, for example :thumbsup::skin-tone-3: for 👍🏽.
+Only the most common emoji are known.
+*/
+func EmojiShortcode(emoji string) (shortcode string, ok bool) {
+	emojiShortcodesOnce.Do(func() {
+		emojiShortcodes = make(map[string]string, len(emojiNames))
+		for _, e := range emojiNames {
+			emojiShortcodes[e.emoji] = e.name
+		}
+	})
+	emoji = stripVariationSelectors(emoji)
+	skinTone, modifier := 0, -1
+	for i, r := range emoji {
+		// the skin tone modifiers are U+1F3FB to U+1F3FF, for skin tones 1 to 5
+		if r >= 0x1F3FB && r <= 0x1F3FF {
+			if skinTone != 0 {
+				return "", false
+			}
+			skinTone, modifier = int(r-0x1F3FB)+1, i
+		}
+	}
+	if modifier >= 0 {
+		emoji = emoji[:modifier] + emoji[modifier+utf8.UTFMax:]
+	}
+	name, ok := emojiShortcodes[emoji]
+	if !ok {
+		return "", false
+	}
+	if skinTone != 0 {
+		return ":" + name + "::skin-tone-" + strconv.Itoa(skinTone) + ":", true
+	}
+	return ":" + name + ":", true
+}
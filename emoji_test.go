@@ -0,0 +1,48 @@
+package formatting
+
+import "testing"
+
+func TestEmojiShortcode(t *testing.T) {
+	for emoji, want := range map[string]string{
+		"🙂":              ":slight_smile:",
+		"👍":              ":thumbsup:",
+		"👍🏽":             ":thumbsup::skin-tone-3:",
+		"❤\ufe0f":        ":heart:",
+		"❤":              ":heart:",
+		"🏳\ufe0f\u200d🌈": ":rainbow_flag:",
+		"🇫🇷":             ":flag_fr:",
+		"a":              "",
+		"🙂🙂":             "",
+		"👍🏽🏽":            "",
+	} {
+		got, ok := EmojiShortcode(emoji)
+		if got != want || ok != (want != "") {
+			t.Errorf("error converting %q to shortcode: want %q, got %q, %v", emoji, want, got, ok)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, e := range emojiNames {
+		if seen[e.emoji] || seen[":"+e.name] {
+			t.Errorf("duplicate emoji %q or shortcode %q", e.emoji, e.name)
+		}
+		seen[e.emoji], seen[":"+e.name] = true, true
+	}
+}
+
+func TestEmojiURL(t *testing.T) {
+	for _, c := range []struct {
+		emoji  *EmojiNode
+		format string
+		size   int
+		want   string
+	}{
+		{NewEmoji("a", "1234", false), "", 0, "https://cdn.discordapp.com/emojis/1234.png"},
+		{NewEmoji("a", "1234", true), "", 0, "https://cdn.discordapp.com/emojis/1234.gif"},
+		{NewEmoji("a", "1234", true), "webp", 48, "https://cdn.discordapp.com/emojis/1234.webp?size=48"},
+		{NewEmoji("a", "1/2?", false), "png", 0, "https://cdn.discordapp.com/emojis/1%2F2%3F.png"},
+	} {
+		if got := c.emoji.URL(c.format, c.size); got != c.want {
+			t.Errorf("error building URL of %s with %q and %d: want %q, got %q", Debug(c.emoji), c.format, c.size, c.want, got)
+		}
+	}
+}
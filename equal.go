@@ -0,0 +1,262 @@
+package formatting
+
+import "fmt"
+
+/*
+Equal returns whether two trees are structurally equal: whether their nodes have the same types, the same fields,
+such as TextNode.Content, and equal children, in the same order.
+
+Spans, parents, siblings and attributes are not compared, so that trees parsed from different messages can be equal.
+Delimiters, such as ItalicsNode.Delimiter, HeaderNode.Marker and TextNode.Escaped are not compared either,
+as they do not change how the message is displayed.
+*/
+func Equal(a Node, b Node) bool {
+	// compare the trees iteratively with an explicit stack, so that deep trees cannot overflow the goroutine stack
+	type pair struct {
+		a Node
+		b Node
+	}
+	stack := []pair{{a: a, b: b}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !shallowEqual(p.a, p.b) {
+			return false
+		}
+		if p.a == nil {
+			continue
+		}
+		ca, cb := p.a.Children(), p.b.Children()
+		if len(ca) != len(cb) {
+			return false
+		}
+		for i := len(ca) - 1; i >= 0; i-- {
+			stack = append(stack, pair{a: ca[i], b: cb[i]})
+		}
+	}
+	return true
+}
+
+// shallowEqual returns whether two nodes have the same type and fields, without comparing their children.
+func shallowEqual(a Node, b Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a := a.(type) {
+	case *node, *BlockQuoteNode, *SpoilerNode, *BoldNode, *UnderlineNode, *ItalicsNode, *StrikethroughNode, *LineBreakNode, *ParagraphNode:
+		return true
+	case *TextNode:
+		b := b.(*TextNode)
+		return a.Content == b.Content
+	case *CodeNode:
+		b := b.(*CodeNode)
+		return a.Content == b.Content && a.Language == b.Language && a.RawLanguage == b.RawLanguage && a.Inline == b.Inline
+	case *URLNode:
+		b := b.(*URLNode)
+		return a.URL == b.URL && a.Mask == b.Mask && a.Title == b.Title && a.Invite == b.Invite && a.Suppressed == b.Suppressed
+	case *EmojiNode:
+		b := b.(*EmojiNode)
+		return a.Animated == b.Animated && a.Text == b.Text && a.ID == b.ID
+	case *NamedEmojiNode:
+		b := b.(*NamedEmojiNode)
+		return a.Name == b.Name && a.SkinTone == b.SkinTone
+	case *ChannelMentionNode:
+		b := b.(*ChannelMentionNode)
+		return a.ID == b.ID
+	case *RoleMentionNode:
+		b := b.(*RoleMentionNode)
+		return a.ID == b.ID
+	case *UserMentionNode:
+		b := b.(*UserMentionNode)
+		return a.ID == b.ID
+	case *SpecialMentionNode:
+		b := b.(*SpecialMentionNode)
+		return a.Mention == b.Mention
+	case *TimestampNode:
+		b := b.(*TimestampNode)
+		return a.Stamp == b.Stamp && a.Format == b.Format
+	case *UnknownTagNode:
+		b := b.(*UnknownTagNode)
+		return a.Raw == b.Raw && a.Name == b.Name && a.Content == b.Content
+	case *HeaderNode:
+		b := b.(*HeaderNode)
+		return a.Level == b.Level
+	case *BulletListNode:
+		b := b.(*BulletListNode)
+		return a.NestedLevel == b.NestedLevel && a.IncludesNewline == b.IncludesNewline
+	case *HighlightNode:
+		b := b.(*HighlightNode)
+		return a.Class == b.Class && a.Color == b.Color
+	default:
+		panic(fmt.Sprintf("invalid node type: %T", a))
+	}
+}
+
+/*
+ChangeType is the type of a Change between two trees.
+*/
+type ChangeType int
+
+const (
+	// ChangeAdded is a subtree that is only in the new tree.
+	ChangeAdded ChangeType = iota + 1
+	// ChangeRemoved is a subtree that is only in the old tree.
+	ChangeRemoved
+	// ChangeModified is a subtree of the old tree that was replaced by a different subtree in the new tree.
+	ChangeModified
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return "invalid"
+	}
+}
+
+/*
+Change is a structural difference between two trees, returned by Diff.
+*/
+type Change struct {
+	Type ChangeType
+	// Path is the list of child indexes from the root to the changed subtree: in the new tree for ChangeAdded,
+	// and in the old tree otherwise. An empty Path is the root.
+	Path []int
+	// Old is the subtree in the old tree, or nil for ChangeAdded.
+	Old Node
+	// New is the subtree in the new tree, or nil for ChangeRemoved.
+	New Node
+}
+
+/*
+Diff returns the structural differences between an old and a new tree, as a list of changed subtrees,
+in the order of the trees. It returns no changes if the trees are Equal.
+
+Children are matched with a longest common subsequence of equal subtrees, after their common prefix
+and suffix. Unmatched children at the same position with the same type and fields are diffed recursively,
+so that changes are reported on the smallest changed subtrees. When the unmatched children of a node
+are too many for a longest common subsequence, they are paired by position instead.
+*/
+func Diff(old Node, new Node) []Change {
+	var changes []Change
+	// diff the trees iteratively with an explicit stack of tasks, in the order of the trees,
+	// so that deep trees cannot overflow the goroutine stack
+	stack := []diffTask{{a: old, b: new}}
+	for len(stack) > 0 {
+		t := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if t.change != nil {
+			changes = append(changes, *t.change)
+			continue
+		}
+		tasks := diff(t.a, t.b, t.pathA, t.pathB)
+		for i := len(tasks) - 1; i >= 0; i-- {
+			stack = append(stack, tasks[i])
+		}
+	}
+	return changes
+}
+
+// diffTask is a step of Diff: either a change to report, or two subtrees to diff.
+type diffTask struct {
+	change *Change
+	a      Node
+	b      Node
+	pathA  []int
+	pathB  []int
+}
+
+// diff returns the tasks of diffing the subtrees a and b, in the order of the trees: the changes of their children,
+// and the children to diff in turn.
+// maxDiffCells bounds the size of the longest common subsequence table of the children of a node in Diff.
+const maxDiffCells = 1 << 16
+
+func diff(a Node, b Node, pathA []int, pathB []int) []diffTask {
+	if !shallowEqual(a, b) {
+		return []diffTask{{change: &Change{Type: ChangeModified, Path: pathA, Old: a, New: b}}}
+	}
+	var tasks []diffTask
+	ca, cb := a.Children(), b.Children()
+	// only the children between the common prefix and suffix need an LCS
+	lo := 0
+	for lo < len(ca) && lo < len(cb) && Equal(ca[lo], cb[lo]) {
+		lo++
+	}
+	hiA, hiB := len(ca), len(cb)
+	for hiA > lo && hiB > lo && Equal(ca[hiA-1], cb[hiB-1]) {
+		hiA--
+		hiB--
+	}
+	ma, mb := ca[lo:hiA], cb[lo:hiB]
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:];
+	// past maxDiffCells, every middle child is paired positionally instead
+	lcs := make([][]int, len(ma)+1)
+	if len(ma)*len(mb) <= maxDiffCells {
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if Equal(ma[i], mb[j]) {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+	}
+	child := func(path []int, i int) []int {
+		return append(append(make([]int, 0, len(path)+1), path...), i)
+	}
+	var removed, added []int
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			switch {
+			case k >= len(added):
+				tasks = append(tasks, diffTask{change: &Change{Type: ChangeRemoved, Path: child(pathA, removed[k]), Old: ca[removed[k]]}})
+			case k >= len(removed):
+				tasks = append(tasks, diffTask{change: &Change{Type: ChangeAdded, Path: child(pathB, added[k]), New: cb[added[k]]}})
+			default:
+				tasks = append(tasks, diffTask{a: ca[removed[k]], b: cb[added[k]], pathA: child(pathA, removed[k]), pathB: child(pathB, added[k])})
+			}
+		}
+		removed, added = removed[:0], added[:0]
+	}
+	if lcs[0] == nil {
+		for i := range ma {
+			removed = append(removed, lo+i)
+		}
+		for j := range mb {
+			added = append(added, lo+j)
+		}
+		flush()
+		return tasks
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && lcs[i][j] == lcs[i+1][j+1]+1 && Equal(ma[i], mb[j]):
+			flush()
+			i++
+			j++
+		case j >= len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, lo+i)
+			i++
+		default:
+			added = append(added, lo+j)
+			j++
+		}
+	}
+	flush()
+	return tasks
+}
@@ -0,0 +1,134 @@
+package formatting
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// escapedCharacters are the characters escaped by Escape, which Discord can interpret as formatting.
+const escapedCharacters = "\\*_`~|<>#-[]:@"
+
+/*
+Escape returns the passed text with a backslash before each character that Discord could interpret as formatting,
+so that it is displayed as is. For example, *hi* is escaped as \*hi\*.
+
+This is typically used to safely insert user-provided text, such as user names, into a formatted message.
+*/
+func Escape(text string) string {
+	var sb strings.Builder
+	sb.Grow(len(text))
+	for _, r := range text {
+		if strings.ContainsRune(escapedCharacters, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+/*
+EscapeMinimal returns the passed text with a backslash before the fewest characters needed so that it is displayed as is
+by a parser with the passed options, for example escaping *hi* as \*hi*, but not escaping a lone *.
+
+This produces more readable text than Escape, but is slower, as the text is parsed again for each escaped character.
+If more than 32 characters would be escaped, the text is escaped with Escape instead, so that this stays fast
+on long untrusted text.
+The options parameter can be nil, which is equivalent to passing DefaultParserOptions.
+*/
+func EscapeMinimal(text string, options *ParserOptions) string {
+	parser := NewParser(options)
+	// escaped is whether the character at each byte offset of text is escaped.
+	escaped := make([]bool, len(text))
+	for k := 0; ; k++ {
+		if k > maxMinimalEscapes {
+			// each escape parses the text again, fall back to escaping everything rather than parsing it too many times
+			return Escape(text)
+		}
+		s, offsets := escapeAt(text, escaped)
+		i := firstFormatted(parser.Parse(s), text, offsets)
+		if i < 0 {
+			return s
+		}
+		j := -1
+		for k, r := range text[i:] {
+			if !escaped[i+k] && escapable(r) {
+				j = i + k
+				break
+			}
+		}
+		if j < 0 {
+			// the formatting cannot be escaped, for example because escapes are disabled
+			return Escape(text)
+		}
+		escaped[j] = true
+	}
+}
+
+// maxMinimalEscapes is the maximum number of characters escaped by EscapeMinimal before it falls back to Escape.
+const maxMinimalEscapes = 32
+
+// escapable returns whether r can be escaped with a backslash, as defined by patternEscape.
+func escapable(r rune) bool {
+	switch {
+	case r >= '0' && r <= '9', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		return false
+	case r == ' ', r == '\t', r == '\n', r == '\f', r == '\r':
+		return false
+	default:
+		return true
+	}
+}
+
+// unescape returns text with its backslash escapes, as defined by patternEscape, replaced by the escaped characters.
+func unescape(text string) string {
+	if strings.IndexByte(text, '\\') < 0 {
+		return text
+	}
+	var sb strings.Builder
+	sb.Grow(len(text))
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			if r, _ := utf8.DecodeRuneInString(text[i+1:]); escapable(r) {
+				i++
+			}
+		}
+		sb.WriteByte(text[i])
+	}
+	return sb.String()
+}
+
+// escapeAt returns text with a backslash before the escaped characters, along with the offset in text of each byte
+// of the escaped text, plus a last offset for its end.
+func escapeAt(text string, escaped []bool) (string, []int) {
+	var sb strings.Builder
+	offsets := make([]int, 0, len(text)+1)
+	for i := 0; i < len(text); i++ {
+		if escaped[i] {
+			sb.WriteByte('\\')
+			offsets = append(offsets, i)
+		}
+		sb.WriteByte(text[i])
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+	return sb.String(), offsets
+}
+
+// firstFormatted returns the offset in text of the first node of root that is not displayed as the text it was parsed from,
+// or -1 if root is displayed as text.
+func firstFormatted(root Node, text string, offsets []int) int {
+	first := -1
+	WalkWithStatus(root, func(n Node, entering bool) WalkStatus {
+		if !entering || n.Kind() == KindRoot {
+			return WalkContinue
+		}
+		span := n.Span()
+		start, end := offsets[span.Start], offsets[span.End]
+		if n, ok := n.(*TextNode); ok && n.Content == text[start:end] {
+			return WalkContinue
+		}
+		first = start
+		return WalkStop
+	})
+	return first
+}
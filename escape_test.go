@@ -0,0 +1,123 @@
+package formatting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	if got, want := Escape("*hi* _there_"), "\\*hi\\* \\_there\\_"; got != want {
+		t.Errorf("error escaping: want %q, got %q", want, got)
+	}
+	parser := NewParser(&MessageParserOptions)
+	for _, text := range []string{
+		"**bold** __underline__ ~~strike~~ ||spoiler||",
+		"`code` ```block```",
+		"> quote\n# header\n- list",
+		"[mask](https://example.com) <@1234> <#1234> <:a:1234> <t:1234> @everyone",
+		"back\\slash \\*",
+		"¯\\_(ツ)_/¯",
+	} {
+		root := parser.Parse(Escape(text))
+		if HasFormatting(root) || Text(root) != text {
+			t.Errorf("error escaping %q: want plain text, got %s", text, Debug(root))
+		}
+	}
+}
+
+func TestEscapeMinimal(t *testing.T) {
+	for text, want := range map[string]string{
+		"hello world":    "hello world",
+		"2*3 = 6":        "2*3 = 6",
+		"*hi*":           "\\*hi*",
+		"**hi**":         "\\**hi**",
+		"snake_case_var": "snake_case_var",
+		"_hi_":           "\\_hi_",
+		"a\\*b":          "a\\\\*b",
+		"<@1234>":        "\\<@1234>",
+		"@everyone":      "\\@everyone",
+		"> quote":        "\\> quote",
+		"# header":       "\\# header",
+	} {
+		if got := EscapeMinimal(text, &MessageParserOptions); got != want {
+			t.Errorf("error escaping %q: want %q, got %q", text, want, got)
+		}
+	}
+	if text := strings.Repeat("*a* ", 1000); EscapeMinimal(text, &MessageParserOptions) != Escape(text) {
+		t.Errorf("error escaping long text: want Escape fallback")
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	for _, text := range []string{
+		"**bold** __underline__ _italics_ *italics* ~~strike~~ ||spoiler||",
+		"`code` ``co`de`` ```go\nfmt.Println()\n```",
+		"> quote\n>>> long\nquote",
+		"> a\n> **b**\n>  c\n\n> d",
+		"> # a\n> - b\n>   - c\n> d",
+		"# header\n- item\n  * nested",
+		"- item\n  continued\n  * nested\n    **continued**\n- next",
+		"[mask](https://example.com \"title\") <https://example.com> https://example.com user@example.com",
+		"[mask](<https://example.com>)",
+		"<@1234> <@&1234> <#1234> <:a:1234> <a:b:1234> <t:1234:R> @here",
+	} {
+		root := parser.Parse(text)
+		if got := RenderMarkdown(root, nil); !Equal(markdownRoot(root, true), markdownRoot(parser.Parse(got), true)) {
+			t.Errorf("error serializing %q: got %q", text, got)
+		}
+	}
+
+	link := NewMaskedLink(`x[y]z]w\`, "https://example.com")
+	link.Title = `a") 'b\`
+	root := NewRoot(link)
+	if got := RenderMarkdown(root, nil); !Equal(root, NewParser(&EmbedDescriptionParserOptions).Parse(got)) {
+		t.Errorf("error serializing masked link: got %q", got)
+	}
+	for _, c := range []struct {
+		node Node
+		want string
+	}{
+		{NewCodeBlock("md", "```go\n````"), "```md\n``\u200b`go\n``\u200b``\n```"},
+		{NewCode("a``b"), "`a`\\`\\``b`"},
+		{NewCode("`"), "\\`"},
+		{NewRoot(NewText("a"), NewBlockQuote(NewText("q"))), "a\n>>> q"},
+	} {
+		if got := RenderMarkdown(c.node, nil); got != c.want {
+			t.Errorf("error serializing %s: want %q, got %q", Debug(c.node), c.want, got)
+		}
+	}
+
+	root = parser.Parse("**2*3 = 6** \\*hi\\*")
+	if got, want := RenderMarkdown(root, nil), "**2\\*3 = 6** \\*hi\\*"; got != want {
+		t.Errorf("error serializing: want %q, got %q", want, got)
+	}
+	options := &MarkdownOptions{
+		MinimalEscaping: true,
+		Parser:          &MessageParserOptions,
+	}
+	if got, want := RenderMarkdown(root, options), "**2*3 = 6** \\*hi\\*"; got != want {
+		t.Errorf("error serializing with minimal escaping: want %q, got %q", want, got)
+	}
+
+	root = parser.Parse("\\é \\: a\\.")
+	if got, want := Debug(root), `[[text "é"] [text " "] [text ":"] [text " a"] [text "."]]`; got != want {
+		t.Errorf("error parsing escapes: want %q, got %q", want, got)
+	}
+	if t0, t1 := root.Children()[0].(*TextNode), root.Children()[1].(*TextNode); !t0.Escaped || t1.Escaped {
+		t.Errorf("error parsing escapes: want escaped %q only", t0.Content)
+	}
+	for _, options := range []*MarkdownOptions{nil, options} {
+		if got, want := RenderMarkdown(root, options), "\\é \\: a\\."; got != want {
+			t.Errorf("error serializing escapes: want %q, got %q", want, got)
+		}
+	}
+	root.Children()[0].(*TextNode).Content = "a"
+	if got, want := RenderMarkdown(root, options), "a \\: a\\."; got != want {
+		t.Errorf("error serializing escaped letter: want %q, got %q", want, got)
+	}
+	MergeText(root)
+	if t0 := root.Children()[0].(*TextNode); t0.Escaped {
+		t.Errorf("error merging escapes: want unescaped %q", t0.Content)
+	}
+}
@@ -0,0 +1,171 @@
+package formatting
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+FindAll returns all the nodes of the passed tree of type T, in document order, including the root.
+
+For example, FindAll[*SpoilerNode](root) returns all the spoilers of a message.
+*/
+func FindAll[T Node](root Node) []T {
+	var nodes []T
+	Walk(root, func(n Node, entering bool) {
+		if n, ok := n.(T); ok && entering {
+			nodes = append(nodes, n)
+		}
+	})
+	return nodes
+}
+
+/*
+Find returns the first node of the passed tree, in document order, for which match returns true, or nil if there is none.
+*/
+func Find(root Node, match func(n Node) bool) Node {
+	var found Node
+	WalkWithStatus(root, func(n Node, entering bool) WalkStatus {
+		if entering && match(n) {
+			found = n
+			return WalkStop
+		}
+		return WalkContinue
+	})
+	return found
+}
+
+/*
+ExtractURLs returns all the URLNode nodes of the passed tree, in document order.
+
+This includes bare URLs, masked links, with their Mask and Title, and email addresses.
+*/
+func ExtractURLs(n Node) []*URLNode {
+	return FindAll[*URLNode](n)
+}
+
+/*
+ExtractEmojis returns all the custom emoji EmojiNode nodes of the passed tree, in document order.
+
+If unique is set, only the first node of each emoji ID is returned.
+*/
+func ExtractEmojis(n Node, unique bool) []*EmojiNode {
+	emojis := FindAll[*EmojiNode](n)
+	if !unique {
+		return emojis
+	}
+	seen := make(map[string]bool)
+	uniqueEmojis := emojis[:0]
+	for _, e := range emojis {
+		if !seen[e.ID] {
+			seen[e.ID] = true
+			uniqueEmojis = append(uniqueEmojis, e)
+		}
+	}
+	return uniqueEmojis
+}
+
+/*
+Text returns the visible text of the passed tree, without formatting, for example for search indexing or content filters.
+
+The text of a tree is the concatenation of the text of its nodes, in document order:
+  - text nodes and code: their content
+  - links: their mask, or their URL if they have no mask
+  - custom emoji: their name, as in :name:
+  - mentions: their target, as in @1234, @&1234, #1234, or @everyone
+  - timestamps and unknown tags: their raw syntax, as in <t:1234567890:R>
+  - list items are followed by a newline if they were in the message
+*/
+func Text(n Node) string {
+	var sb strings.Builder
+	Walk(n, func(n Node, entering bool) {
+		if !entering {
+			if n, ok := n.(*BulletListNode); ok && n.IncludesNewline {
+				sb.WriteString("\n")
+			}
+			return
+		}
+		sb.WriteString(nodeText(n))
+	})
+	return sb.String()
+}
+
+// nodeText returns the text of a single node as defined by Text, without the text of its children.
+func nodeText(n Node) string {
+	switch n := n.(type) {
+	case *TextNode:
+		return n.Content
+	case *CodeNode:
+		return n.Content
+	case *URLNode:
+		if n.Mask != "" {
+			return n.Mask
+		}
+		return n.URL
+	case *EmojiNode:
+		return ":" + n.Text + ":"
+	case *NamedEmojiNode:
+		if n.SkinTone != 0 {
+			return ":" + n.Name + "::skin-tone-" + strconv.Itoa(n.SkinTone) + ":"
+		}
+		return ":" + n.Name + ":"
+	case *ChannelMentionNode:
+		return "#" + n.ID
+	case *RoleMentionNode:
+		return "@&" + n.ID
+	case *UserMentionNode:
+		return "@" + n.ID
+	case *SpecialMentionNode:
+		return "@" + n.Mention
+	case *TimestampNode:
+		text := "<t:" + n.Stamp
+		if n.Format != "" {
+			text += ":" + n.Format
+		}
+		return text + ">"
+	case *UnknownTagNode:
+		return n.Raw
+	case *LineBreakNode:
+		return "\n"
+	default:
+		return ""
+	}
+}
+
+/*
+ExtractCode returns all the CodeNode nodes of the passed tree, in document order, both inline code and code blocks.
+
+Their Content, Language and Inline fields can be used to process code snippets, for example to run or syntax-check them.
+*/
+func ExtractCode(n Node) []*CodeNode {
+	return FindAll[*CodeNode](n)
+}
+
+/*
+HasFormatting returns whether the passed tree contains anything other than plain text, that is any node other than
+the root and text nodes, such as bold text, links, mentions or emoji.
+
+A message without formatting can be displayed as is, for example without rendering it to HTML.
+*/
+func HasFormatting(n Node) bool {
+	return Find(n, func(n Node) bool {
+		kind := n.Kind()
+		return kind != KindRoot && kind != KindText
+	}) != nil
+}
+
+/*
+HasBlockContent returns whether the passed tree contains block content: code blocks, block quotes, headers or lists.
+*/
+func HasBlockContent(n Node) bool {
+	return Find(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *CodeNode:
+			return !n.Inline
+		case *BlockQuoteNode, *HeaderNode, *BulletListNode:
+			return true
+		default:
+			return false
+		}
+	}) != nil
+}
@@ -0,0 +1,108 @@
+package formatting
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExtractURLs(t *testing.T) {
+	root := NewParser(&MessageParserOptions).Parse("a https://a.example **[b](https://b.example)** <https://c.example> d@example.com")
+	var got []string
+	for _, u := range ExtractURLs(root) {
+		got = append(got, u.Mask+" "+u.URL)
+	}
+	want := []string{" https://a.example", "b https://b.example", " https://c.example", "d@example.com mailto:d@example.com"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error extracting urls: want %q, got %q", want, got)
+	}
+}
+
+func TestExtractEmojis(t *testing.T) {
+	root := NewParser(nil).Parse("<:a:1> **<a:b:2>** <:a:1>")
+	for unique, want := range map[bool]string{
+		false: "[a 1 false b 2 true a 1 false]",
+		true:  "[a 1 false b 2 true]",
+	} {
+		var got []string
+		for _, e := range ExtractEmojis(root, unique) {
+			got = append(got, fmt.Sprint(e.Text, " ", e.ID, " ", e.Animated))
+		}
+		if fmt.Sprint(got) != want {
+			t.Errorf("error extracting emojis (unique: %v): want %s, got %s", unique, want, got)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	text := "**a** [b](https://b.example) https://c.example `d` <:e:1> <@1> <#2> <@&3> @here <t:4:R>\n- f\n```go\ng```"
+	want := "a b https://c.example d :e: @1 #2 @&3 @here <t:4:R>\nf\ng"
+	if got := Text(NewParser(&MessageParserOptions).Parse(text)); got != want {
+		t.Errorf("error getting text: want %q, got %q", want, got)
+	}
+}
+
+func TestExtractCode(t *testing.T) {
+	root := NewParser(nil).Parse("`a` > ```go\nb```\n||``c``||")
+	var got []string
+	for _, c := range ExtractCode(root) {
+		got = append(got, fmt.Sprintf("%q %q %v", c.Language, c.Content, c.Inline))
+	}
+	want := []string{`"" "a" true`, `"go" "b" false`, `"" "c" true`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error extracting code: want %q, got %q", want, got)
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := NewParser(nil).Parse("||a|| **||b||** <@1>")
+	if spoilers := FindAll[*SpoilerNode](root); len(spoilers) != 2 || Debug(spoilers[1]) != `[spoiler [text "b"]]` {
+		t.Errorf("error finding all spoilers: got %d", len(spoilers))
+	}
+	if bold := Find(root, func(n Node) bool { return n.Kind() == KindBold }); bold == nil || bold != root.Children()[2] {
+		t.Errorf("error finding first bold: got %v", bold)
+	}
+	if Find(root, func(n Node) bool { return n.Kind() == KindCode }) != nil {
+		t.Errorf("want no code found")
+	}
+}
+
+func TestVisibleLength(t *testing.T) {
+	root := NewParser(nil).Parse("**héllo** <:a:1> <@1234> `x`")
+	if got := VisibleLength(root, nil); got != 15 {
+		t.Errorf("error computing visible length: want %d, got %d", 15, got)
+	}
+	policy := WidthFunc(func(n Node) int {
+		if _, ok := n.(*UserMentionNode); ok {
+			return len("@delthas")
+		}
+		return DefaultWidthPolicy.Width(n)
+	})
+	if got := VisibleLength(root, policy); got != 18 {
+		t.Errorf("error computing visible length with policy: want %d, got %d", 18, got)
+	}
+}
+
+func TestHasFormatting(t *testing.T) {
+	for _, tc := range []struct {
+		text       string
+		formatting bool
+		block      bool
+	}{
+		{"hello world", false, false},
+		{"hello *world*", true, false},
+		{"hello `world`", true, false},
+		{"hello <@1234>", true, false},
+		{"```\nhello\n```", true, true},
+		{"> hello", true, true},
+		{"# hello", true, true},
+		{"- hello", true, true},
+	} {
+		root := NewParser(&MessageParserOptions).Parse(tc.text)
+		if got := HasFormatting(root); got != tc.formatting {
+			t.Errorf("error checking formatting of %q: want %v, got %v", tc.text, tc.formatting, got)
+		}
+		if got := HasBlockContent(root); got != tc.block {
+			t.Errorf("error checking block content of %q: want %v, got %v", tc.text, tc.block, got)
+		}
+	}
+}
@@ -2,7 +2,7 @@
 Package formatting is a small Go library for parsing Discord markdown-like messages to an AST.
 The goal is to copy the Discord apps behavior as precisely as possible. This is not a general purpose Markdown parser.
 
-Usage
+# Usage
 
 The main entrypoint to the library is the Parser type, along with its NewParser function.
 A Parser is used to Parser.Parse a Discord message string into an AST represented by a Node.
@@ -16,50 +16,70 @@ It is recommended that unknown Node types be ignored by the consumer.
 For example, when writing a Discord to IRC bridge, the function passed to Walk would output an IRC bold formatting
 character to the output on entering and leaving a BoldNode.
 
-The library currently does not come with official formatters for the message AST.
+# Rendering
 
-Debugging
+The library comes with formatters for the message AST: RenderHTML renders a message to HTML, and RenderANSI
+renders a message to text with ANSI terminal escape sequences. Their behavior can be customized with RenderOptions,
+for example to syntax-highlight code blocks with a Highlighter.
+
+RenderMarkdown serializes a message AST back to a Discord message, and Escape escapes text so that it is displayed as is.
+Messages can be built programmatically with the node constructors, such as NewBold, or with a MessageBuilder.
+
+MarshalJSON and UnmarshalJSON serialize a message AST to and from JSON, and MarshalProto and UnmarshalProto
+to and from protocol buffers, as described by formatting.proto.
+
+# Debugging
 
 The Debug function can be used to print a node tree in a human-readable format.
 */
 package formatting
 
 import (
+	"context"
 	"fmt"
-	"regexp"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 const regexpFlagDotAll = "(?s)"
 
-var patternBlockQuote = regexp.MustCompile(regexpFlagDotAll + "^(?: *>>> +(.*)| *> +([^\\n]*\\n?))")
-var patternChannelMention = regexp.MustCompile("^<#(\\d+)>")
-var patternRoleMention = regexp.MustCompile("^<@&(\\d+)>")
-var patternUserMention = regexp.MustCompile("^<@!?(\\d+)>")
-var patternSpecialMention = regexp.MustCompile("^@(everyone|here)")
-
-var patternCustomEmoji = regexp.MustCompile("^<(a)?:([a-zA-Z_0-9]+):(\\d+)>")
-var patternNamedEmoji = regexp.MustCompile("^:([^\\s:]+?(?:::skin-tone-\\d)?):")
-var patternUnescapeEmoticon = regexp.MustCompile("^(¯\\\\_\\(ツ\\)_/¯)")
-var patternTimestamp = regexp.MustCompile("^<t:(-?\\d{1,17})(?::(t|T|d|D|f|F|R))?>")
-var patternURL = regexp.MustCompile("^(https?://[^\\s<]+[^<.,:;\"')\\]\\s])")
-var patternMaskedLink = regexp.MustCompile("^(\\[(?:\\[[^]]*]|[^]])*](?:[^\\[]*])?)\\(\\s*<?((?:[^\\s\\\\]|\\\\.)*?)>?(?:\\s+['\"]([\\s\\S]*?)['\"])?\\s*\\)")
-var patternURLNoEmbed = regexp.MustCompile("^<(https?://[^\\s<]+[^<.,:;\"')\\]\\s])>")
-var patternSoftHyphen = regexp.MustCompile("^\\x{00AD}")
-var patternSpoiler = regexp.MustCompile("^\\|\\|([\\s\\S]+?)\\|\\|")
-var patternListItem = regexp.MustCompile("^([^\\S\\r\\n]*)[*-][ \\s]+(.*)([\\n|$])?") // replaced '?' with '+'
-var patternHeaderItem = regexp.MustCompile("^(\\s*(#+)[ \\t](.*) *)(?:\\n|$)")
-
-var patternBold = regexp.MustCompile("^(\\*\\*([\\s\\S]+?)\\*\\*)(?:[^*]|$)")
-var patternUnderline = regexp.MustCompile("^(__([\\s\\S]+?)__)(?:[^_]|$)")
-var patternStrikethrough = regexp.MustCompile("^~~(\\S|\\S[\\s\\S]*?\\S)~~")
-var patternNewline = regexp.MustCompile("^(?:\\n *)*\\n")
-var patternText = regexp.MustCompile("^([\\s\\S]+?)(?:[^0-9A-Za-z\\s\\x{00c0}-\\x{ffff}]|\\n| {2,}\\n|\\w+:\\S|$)")
-var patternEscape = regexp.MustCompile("^\\\\([^0-9A-Za-z\\s])")
-var patternItalics = regexp.MustCompile("^(\\b_((?:__|\\\\[\\s\\S]|[^\\\\_])+?)_\\b)|^(\\*((?:\\*\\*|[^\\s*])(?:\\*\\*|\\s+(?:[^*\\s]|\\*\\*)|[^\\s*])*?)\\*)(?:[^*]|$)")
-
-var patternCodeBlock = regexp.MustCompile(regexpFlagDotAll + "^```(?:([\\w+\\-.]+?)?(\\s*\\n))?([^\\n].*?)\\n*```")
-var patternCodeInline = regexp.MustCompile(regexpFlagDotAll + "^``([^`]*)``|^`([^`]*)`")
+var patternBlockQuote = lazyCompile(regexpFlagDotAll + "^(?: *>>> (.*)|( *> +[^\\n]*(?:\\n *> +[^\\n]*)*\\n?))")
+var patternChannelMention = lazyCompile("^<#(\\d+)>")
+var patternRoleMention = lazyCompile("^<@&(\\d+)>")
+var patternUserMention = lazyCompile("^<@!?(\\d+)>")
+var patternSpecialMention = lazyCompile("^@(everyone|here)")
+
+var patternCustomEmoji = lazyCompile("^<(a)?:([a-zA-Z_0-9]+):(\\d+)>")
+var patternNamedEmoji = lazyCompile("^:([^\\s:]+?(?:::skin-tone-\\d)?):")
+var patternUnescapeEmoticon = lazyCompile("^(¯\\\\_\\(ツ\\)_/¯)")
+var patternTimestamp = lazyCompile("^<t:(-?\\d{1,17})(?::(t|T|d|D|f|F|R))?>")
+var patternURL = lazyCompile("^(https?://[^\\s<]+[^<.,:;\"')\\]\\s])")
+var patternMaskedLink = lazyCompile("^(\\[(?:\\[[^]]*]|[^]])*](?:[^\\[]*])?)\\(\\s*<?((?:[^\\s\\\\]|\\\\.)*?)>?(?:\\s+['\"]([\\s\\S]*?)['\"])?\\s*\\)")
+var patternEmail = lazyCompile("^([a-zA-Z0-9.!#$%&'+/=?^_{}-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+)")
+var patternUnknownTag = lazyCompile("^<(\\w+):((?:[^/<>\\s][^<>\\n]*)?)>")
+var patternURLNoEmbed = lazyCompile("^<(https?://[^\\s<]+[^<.,:;\"')\\]\\s])>")
+var patternInvite = lazyCompile("^https?://(?:www\\.)?(?:discord\\.gg|discord(?:app)?\\.com/invite)/([a-zA-Z0-9-]+)/?(?:[?#].*)?$")
+var patternSoftHyphen = lazyCompile("^\\x{00AD}")
+var patternInvisible = lazyCompile("^[\\x{061C}\\x{200B}\\x{200C}\\x{200E}\\x{200F}\\x{202A}-\\x{202E}\\x{2060}-\\x{2064}\\x{2066}-\\x{2069}\\x{FEFF}]+")
+var patternSpoiler = lazyCompile("^\\|\\|([\\s\\S]+?)\\|\\|")
+var patternListItem = lazyCompile("^([^\\S\\r\\n]*)[*-][ \\s]+(.*)((?:\\n[^\\S\\r\\n]+(?:[^\\s*-]|[*-]\\S)[^\\n]*)*)([\\n|$])?") // replaced '?' with '+'
+var patternHeaderItem = lazyCompile("^(\\s*(#{1,3}) (.*) *)(?:\\n|$)")
+
+var patternBold = lazyCompile("^(\\*\\*([\\s\\S]+?)\\*\\*)(?:[^*]|$)")
+var patternUnderline = lazyCompile("^(__([\\s\\S]+?)__)(?:[^_]|$)")
+var patternStrikethrough = lazyCompile("^~~(\\S|\\S[\\s\\S]*?\\S)~~")
+var patternNewline = lazyCompile("^(?:\\n *)*\\n")
+var patternLineBreak = lazyCompile("^(?: {2,}|\\\\)\\n")
+var patternText = lazyCompile("^([\\s\\S]+?)(?:[^0-9A-Za-z\\s\\x{00c0}-\\x{ffff}]|\\n| {2,}\\n|\\w+:\\S|[\\w.+-]+@[a-zA-Z0-9][a-zA-Z0-9-]*\\.[a-zA-Z0-9]|$)")
+var patternEscape = lazyCompile("^\\\\([^0-9A-Za-z\\s])")
+var patternItalics = lazyCompile("^(\\b_((?:__|\\\\[\\s\\S]|[^\\\\_])+?)_\\b)|^(\\*((?:\\*\\*|[^\\s*])(?:\\*\\*|\\s+(?:[^*\\s]|\\*\\*)|[^\\s*])*?)\\*)(?:[^*]|$)")
+
+var patternCodeBlock = lazyCompile(regexpFlagDotAll + "^```(?:([\\w+\\-.]+?)?(\\s*\\n))?([^\\n].*?)\\n*```")
+var patternCodeInline = lazyCompile(regexpFlagDotAll + "^``([^`]*)``|^`([^`]*)`")
 
 // var patternHookedLink = regexp.MustCompile("^\\$\\[((?:\\[[^]]*]|[^]]|](?=[^\\[]*]))*)?]\\(\\s*<?((?:[^\\s\\\\]|\\\\.)*?)>?(?:\\s+['\"]([\\s\\S]*?)['\"])?\\s*\\)")
 
@@ -69,14 +89,16 @@ Parser is an immutable object that can parse Discord messages into an AST.
 A Parser should never be created manually, and should be created with the NewParser function instead.
 */
 type Parser struct {
-	rules []rule
+	options ParserOptions
+	rules   *ruleSet
 }
 
 /*
 Node is a node in the Discord message tree. A Node has an ordered list of Children.
 
 The Node type is implemented by many types, such as TextNode. It is recommended to type switch
-over the Node to run specific processing depending on the node type.
+over the Node to run specific processing depending on the node type. Kind returns a comparable
+value identifying the node type, which can be used for map-based dispatch instead.
 
 Some Node types will never have children, and are called leaf nodes in the documentation.
 
@@ -84,52 +106,289 @@ An AST can be visited with Walk, or be printed as a debug human-readable message
 */
 type Node interface {
 	Children() []Node
+	Kind() NodeKind
+	Span() Span
+	Parent() Node
+	PrevSibling() Node
+	NextSibling() Node
+	AppendChild(child Node)
+	InsertChildAt(i int, child Node)
+	RemoveChild(child Node) bool
+	ReplaceChild(old Node, new Node) bool
+	SetChildren(children []Node)
+	Attr(key string) (any, bool)
+	SetAttr(key string, value any)
 	addChild(node Node)
+	base() *node
+	setSpan(span Span)
+	link(self Node, parent Node, index int)
+}
+
+/*
+Span is a range of byte offsets in the source message that was parsed, from Start included to End excluded.
+*/
+type Span struct {
+	Start int
+	End   int
+}
+
+/*
+Len returns the length of the span in bytes.
+*/
+func (s Span) Len() int {
+	return s.End - s.Start
 }
 
 type node struct {
 	children []Node
+	span     Span
+	// self is the Node embedding this node, used as the parent of its children.
+	self   Node
+	parent Node
+	// index is the index of the node in the children of its parent.
+	index int
+	attrs map[string]any
 }
 
 /*
-Children returns the list of Children of a Node. This list should not be modified.
+Children returns the list of Children of a Node. This list should not be modified directly:
+use the mutation methods, such as AppendChild or RemoveChild, instead.
 */
 func (n *node) Children() []Node {
 	return n.children
 }
+
+/*
+Span returns the range of the source message the Node was parsed from, including its delimiters,
+such as ** for a BoldNode. The root Node spans the whole message.
+
+Nodes that were not created by the parser have an empty Span.
+*/
+func (n *node) Span() Span {
+	return n.span
+}
+
+/*
+Parent returns the parent of a Node, or nil if it is the root Node.
+
+Parents and siblings are set by the parser and kept up to date by the mutation methods, such as AppendChild.
+For trees built manually, call Index first.
+*/
+func (n *node) Parent() Node {
+	return n.parent
+}
+
+/*
+PrevSibling returns the previous child of the parent of a Node, or nil if it is the first child or the root Node.
+*/
+func (n *node) PrevSibling() Node {
+	if n.parent == nil || n.index == 0 {
+		return nil
+	}
+	return n.parent.Children()[n.index-1]
+}
+
+/*
+NextSibling returns the next child of the parent of a Node, or nil if it is the last child or the root Node.
+*/
+func (n *node) NextSibling() Node {
+	if n.parent == nil {
+		return nil
+	}
+	children := n.parent.Children()
+	if n.index+1 >= len(children) {
+		return nil
+	}
+	return children[n.index+1]
+}
+
+/*
+Attr returns the value of the attribute key of a Node, and whether it is set.
+
+Attributes are arbitrary metadata that can be attached to nodes with SetAttr, for example by a pass
+resolving mentions, to be used by the next passes or renderers. The parser does not set any attribute.
+*/
+func (n *node) Attr(key string) (any, bool) {
+	value, ok := n.attrs[key]
+	return value, ok
+}
+
+/*
+SetAttr sets the attribute key of a Node to value. Setting an attribute to nil removes it.
+
+To avoid conflicts between packages, keys should be prefixed with a package name, as in "mypackage.severity".
+*/
+func (n *node) SetAttr(key string, value any) {
+	if value == nil {
+		delete(n.attrs, key)
+		return
+	}
+	if n.attrs == nil {
+		n.attrs = make(map[string]any)
+	}
+	n.attrs[key] = value
+}
+func (n *node) base() *node {
+	return n
+}
 func (n *node) addChild(node Node) {
 	n.children = append(n.children, node)
 }
+func (n *node) setSpan(span Span) {
+	n.span = span
+}
+func (n *node) link(self Node, parent Node, index int) {
+	first := n.self == nil
+	n.self = self
+	n.parent = parent
+	n.index = index
+	if first {
+		// children added before the node was linked have no parent yet
+		n.relink(0)
+	}
+}
+
+// relink sets the parent and index of the children of n, starting from index i.
+func (n *node) relink(i int) {
+	for ; i < len(n.children); i++ {
+		n.children[i].link(n.children[i], n.self, i)
+	}
+}
+
+/*
+AppendChild adds child as the last child of a Node.
+*/
+func (n *node) AppendChild(child Node) {
+	n.children = append(n.children, child)
+	n.relink(len(n.children) - 1)
+}
+
+/*
+InsertChildAt inserts child at index i of the children of a Node, shifting the next children.
+It panics if i is out of the range [0, len(Children())].
+*/
+func (n *node) InsertChildAt(i int, child Node) {
+	if i < 0 || i > len(n.children) {
+		panic(fmt.Sprintf("child index out of range: %d", i))
+	}
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+	n.relink(i)
+}
+
+/*
+RemoveChild removes child from the children of a Node. It returns false if child is not a child of the Node.
+*/
+func (n *node) RemoveChild(child Node) bool {
+	for i, c := range n.children {
+		if c != child {
+			continue
+		}
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		child.link(child, nil, 0)
+		n.relink(i)
+		return true
+	}
+	return false
+}
+
+/*
+ReplaceChild replaces old with new in the children of a Node. It returns false if old is not a child of the Node.
+*/
+func (n *node) ReplaceChild(old Node, new Node) bool {
+	for i, c := range n.children {
+		if c != old {
+			continue
+		}
+		n.children[i] = new
+		old.link(old, nil, 0)
+		n.relink(i)
+		return true
+	}
+	return false
+}
+
+/*
+SetChildren replaces all the children of a Node with children. The Node takes ownership of the slice.
+*/
+func (n *node) SetChildren(children []Node) {
+	for _, c := range n.children {
+		c.link(c, nil, 0)
+	}
+	n.children = children
+	n.relink(0)
+}
+
+/*
+Index sets the parent and siblings of all the nodes of the passed tree, returned by Parent, PrevSibling and NextSibling.
+
+Trees returned by the parser are already indexed. Index should be called on trees built manually,
+before navigating or modifying them.
+*/
+func Index(root Node) {
+	root.link(root, nil, 0)
+	walk(root, func(n Node, entering bool) WalkStatus {
+		if entering {
+			for i, c := range n.Children() {
+				c.link(c, n, i)
+			}
+		}
+		return WalkContinue
+	})
+}
 
 /*
 TextNode is the most basic leaf Node, containing text.
 
 A TextNode does not mean unformatted text per se. For example, a TextNode that is a child of a BoldNode should be
 displayed in bold, whereas a standalone TextNode could be unformatted text.
+
+Escaped is whether the TextNode was parsed from a backslash escape, such as \*, whose Content is the escaped character.
+RenderMarkdown serializes escaped text nodes back with their backslash.
 */
 type TextNode struct {
 	node
 	Content string
+	Escaped bool
 }
 
 /*
 BlockQuoteNode is a Node that introduces a block quote (possibly with a multi-line content).
 It is usually input in Discord with >>>.
+
+As in Discord, > and >>> only start a block quote at the start of a line, after optional spaces, such as
+at the start of the message or after a line break, and never in the content of another node:
+in "a > b" and "**> b**", the > is text. Consecutive lines starting with > are a single block quote, and block quotes are
+never nested: quote syntax inside a block quote, including > lines following >>>, is part of its content.
+Other block constructs, such as headers and lists, are parsed in the content of block quotes.
+As in Discord, the lines of a > block quote are parsed together, without their >, so that formatting can span
+several of its lines.
 */
 type BlockQuoteNode struct {
 	node
+	// Delimiter is the delimiter the block quote was input with: ">" for consecutive lines, or ">>>" for the rest of the message.
+	Delimiter string
 }
 
 /*
 CodeNode is a Node that introduces a code excerpt (either inline or in a code block).
 It is usually input in Discord with ` or ```.
 
+Inline is true for inline code, and false for code blocks.
 Non-inline code nodes can have an optional Language.
 */
 type CodeNode struct {
 	node
-	Content  string
+	Content string
+	// Language is the language of the code block. It is normalized with NormalizeLanguage if
+	// ParserOptions.NormalizeCodeLanguages is set, and is the same as RawLanguage otherwise.
 	Language string
+	// RawLanguage is the language of the code block, as input in the message.
+	RawLanguage string
+	Inline      bool
+	// Delimiter is the backtick delimiter the code was input with: "`" or "``" for inline code, or "```" for code blocks.
+	Delimiter string
 }
 
 /*
@@ -142,12 +401,22 @@ type SpoilerNode struct {
 
 /*
 URLNode is a leaf Node that contains a URL.
+
+Plain email addresses are autolinked to a URLNode with a mailto: URL, and the address as its Mask.
 */
 type URLNode struct {
 	node
 	URL string
-	// Mask is an optional description of the link, found in masked links only.
+	// Mask is an optional description of the link, found in masked links only, with its backslash escapes unescaped.
 	Mask string
+	// Title is an optional tooltip of the link, found in masked links only, as in [mask](url "title"),
+	// with its backslash escapes unescaped. It is only set when ParserOptions.EnableMaskedLinkTitles is set.
+	Title string
+	// Invite is the invite code of the link, if the URL is a Discord invite link (such as discord.gg/code).
+	Invite string
+	// Suppressed is whether the author suppressed the embed of the link by wrapping it in angle brackets,
+	// as in <https://example.com> or [mask](<https://example.com>).
+	Suppressed bool
 }
 
 /*
@@ -161,6 +430,21 @@ type EmojiNode struct {
 	ID       string
 }
 
+/*
+NamedEmojiNode is a leaf Node that represents an emoji by its shortcode, such as :thumbsup:.
+It is usually input in Discord with :name: or :name::skin-tone-n:.
+
+Name is the shortcode without its colons, and SkinTone is the skin tone of the emoji, from 1 to 5, or 0 if none is set.
+
+This node is not parsed by default, and is parsed when ParserOptions.EnableNamedEmoji is set.
+Otherwise, shortcodes are kept as text.
+*/
+type NamedEmojiNode struct {
+	node
+	Name     string
+	SkinTone int
+}
+
 /*
 ChannelMentionNode is a leaf Node that represents a mention of a channel.
 It is usually represented in Discord with <#id>.
@@ -201,6 +485,9 @@ type SpecialMentionNode struct {
 /*
 TimestampNode is a leaf Node that represents a timestamp, displayed in the local client time.
 It is usually represented in Discord with <t:stamp:format>.
+
+Stamp is a number of seconds since the Unix epoch, and Format is an optional TimestampStyle.
+Use Time and Style to get their typed values.
 */
 type TimestampNode struct {
 	node
@@ -208,28 +495,57 @@ type TimestampNode struct {
 	Format string
 }
 
+/*
+UnknownTagNode is a leaf Node that represents a tag not known by the parser, such as <x:y:z>.
+Discord regularly introduces new tags, which can be passed through verbatim by keeping their Raw text.
+
+Raw is the full text of the tag, Name is the word before the first colon, and Content is the text after it.
+
+This node is not parsed by default, and is parsed when ParserOptions.EnableUnknownTags is set.
+*/
+type UnknownTagNode struct {
+	node
+	Raw     string
+	Name    string
+	Content string
+}
+
 /*
 HeaderNode is a Node that represents a Markdown header.
 It is usually represented in Discord with: # header.
 
-This node is not parsed by default and is currently used in Discord only for the first post of forums.
+As in Discord, a header only starts at the start of a line, at the top level or in a block quote,
+including the line following a list item or a block quote, and never in the content of another node. Its hashes must be followed by a space, and more than 3 hashes
+are kept as text.
+
+This node is not parsed by default, and is parsed when ParserOptions.EnableHeaders is set,
+or when ParserOptions.BehaviorVersion is at least Behavior2023.
 */
 type HeaderNode struct {
 	node
-	// Level is the number of hashes (#) of that header
+	// Level is the number of hashes (#) of that header, from 1 to 3
 	Level int
+	// Marker is the text the header was input with before its content: its indentation if any, its hashes,
+	// and the following space, such as "## ".
+	Marker string
 }
 
 /*
 BulletListNode is a Node that represents a Markdown list.
 It is usually represented in Discord with: * my list.
 
-This node is not parsed by default and is currently used in Discord only for the first post in forums.
+The following indented lines that are not list items themselves continue the item: their content is part of
+the content of the node, after a line break, without their indentation.
+
+This node is not parsed by default, and is parsed when ParserOptions.EnableLists is set,
+or when ParserOptions.BehaviorVersion is at least Behavior2023.
 */
 type BulletListNode struct {
 	node
 	NestedLevel     int
 	IncludesNewline bool
+	// Delimiter is the bullet the list item was input with: "-" or "*".
+	Delimiter string
 }
 
 /*
@@ -250,10 +566,12 @@ type UnderlineNode struct {
 
 /*
 ItalicsNode is a Node that contains content that should be displayed in italics.
-It is usually represented in Discord with *italics*.
+It is usually represented in Discord with *italics* or _italics_.
 */
 type ItalicsNode struct {
 	node
+	// Delimiter is the delimiter the italics were input with: "*" or "_".
+	Delimiter string
 }
 
 /*
@@ -264,22 +582,95 @@ type StrikethroughNode struct {
 	node
 }
 
+/*
+HighlightNode is a Node that contains a span of syntax-highlighted code.
+It is never produced by the Parser, but is returned by a Highlighter.
+*/
+type HighlightNode struct {
+	node
+	// Class is the token class of the span, such as keyword or string.
+	Class string
+	// Color is an optional color of the span, in the #rrggbb form.
+	Color string
+}
+
+/*
+LineBreakNode is a Node that represents a hard line break, distinct from the line breaks of a TextNode.
+It is usually input with two spaces or more before a line break, or with a backslash before a line break,
+and includes that line break.
+
+This node is not parsed by default, and is parsed when ParserOptions.EnableLineBreaks is set.
+*/
+type LineBreakNode struct {
+	node
+}
+
+/*
+ParagraphNode is a Node that groups the inline content of a paragraph, delimited by blank lines and block nodes.
+It is never produced by the Parser, but is returned by ParagraphPass.
+*/
+type ParagraphNode struct {
+	node
+}
+
 type parseSpec struct {
 	node     Node
 	matchEnd int
 	start    int
 	end      int
+	// more are the next ranges of the content of node, relative to the match like start and end,
+	// for nodes whose content is not contiguous, such as block quotes of several lines.
+	more []Span
+	// depth is the depth of node in the tree, the root being at depth 0.
+	depth int
+	// leave marks the end of the content of node, when parsing events.
+	leave bool
+	// capture, if set, is the capture preceding start, which is the capture of the previous sibling of the nodes
+	// of this range rather than the last capture of the content of that sibling.
+	capture string
 }
 type rule struct {
-	pattern    *regexp.Regexp
-	block      bool
+	// name is the stable name of the rule, used in ParserOptions.DisabledRules and ParserOptions.RuleOrder.
+	name string
+	// enabled returns whether the rule is enabled by the parser options. A nil enabled means the rule is always enabled.
+	enabled func(options *ParserOptions) bool
+	pattern *lazyRegexp
+	// first, if set, are the bytes a match of the rule can start with, so that other bytes are skipped
+	// without running the rule.
+	first string
+	// scan, if set, is a hand-written equivalent of pattern.FindStringSubmatchIndex, used instead of the pattern.
+	// It appends the match to dst, to avoid allocating it, and can use memo to skip the parts of the source
+	// it already scanned at previous offsets.
+	scan  func(dst []int, s string, memo *scanMemo) []int
+	block bool
+	// parser returns the parsed node of the match. It can return a nil node to decline the match,
+	// in which case the next rules are tried instead.
 	parser     func(match match) parseSpec
 	blockQuote bool
 }
 type match struct {
-	parser *Parser
-	match  string
-	groups []int
+	options *ParserOptions
+	match   string
+	groups  []int
+	// before is the text of the source before the match.
+	before string
+}
+
+// prev returns the character right before the match, or utf8.RuneError if the match is at the start of the source.
+func (m *match) prev() rune {
+	r, _ := utf8.DecodeLastRuneInString(m.before)
+	return r
+}
+
+// next returns the character right after the match, or utf8.RuneError if the match is at the end of the source.
+func (m *match) next() rune {
+	r, _ := utf8.DecodeRuneInString(m.match[m.groups[1]:])
+	return r
+}
+
+// isWordRune returns whether r is a letter, a mark or a digit, in any script.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)
 }
 
 func (m *match) group(i int) string {
@@ -299,25 +690,219 @@ func (m *match) end(i int) int {
 	return m.groups[i*2+1]
 }
 
+// unsafeMask returns whether the mask of a masked link hides or disguises its target, by being blank or containing a URL.
+func unsafeMask(mask string) bool {
+	if strings.TrimSpace(mask) == "" {
+		return true
+	}
+	for i := 0; ; i += 4 {
+		j := strings.Index(mask[i:], "http")
+		if j < 0 {
+			return false
+		}
+		i += j
+		if scanURLEnd(mask[i:], func(end int) bool { return true }) >= 0 {
+			return true
+		}
+	}
+}
+
+// allowedURL returns whether the URL is valid and has one of the schemes, and the URL with its scheme
+// and host lowercased.
+func allowedURL(rawURL string, schemes []string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return "", false
+	}
+	allowed := false
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed || (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return "", false
+	}
+	// lowercase the scheme and host in place rather than formatting u, which could escape the rest of the URL
+	i := len(u.Scheme)
+	normalized := u.Scheme + rawURL[i:]
+	if strings.HasPrefix(rawURL[i:], "://") {
+		hostStart := i + 3
+		hostEnd := len(rawURL)
+		if j := strings.IndexAny(rawURL[hostStart:], "/?#"); j >= 0 {
+			hostEnd = hostStart + j
+		}
+		if j := strings.LastIndexByte(rawURL[hostStart:hostEnd], '@'); j >= 0 {
+			hostStart += j + 1
+		}
+		normalized = u.Scheme + rawURL[i:hostStart] + strings.ToLower(rawURL[hostStart:hostEnd]) + rawURL[hostEnd:]
+	}
+	return normalized, true
+}
+
+// inviteHosts are the hosts and paths of the invite URLs of patternInvite, followed by the invite code.
+var inviteHosts = []string{"discord.gg/", "discord.com/invite/", "discordapp.com/invite/"}
+
+// inviteCode returns the invite code of the URL if it matches patternInvite, or an empty string.
+func inviteCode(url string) string {
+	if strings.HasPrefix(url, "http://") {
+		url = url[7:]
+	} else if strings.HasPrefix(url, "https://") {
+		url = url[8:]
+	} else {
+		return ""
+	}
+	url = strings.TrimPrefix(url, "www.")
+	found := false
+	for _, host := range inviteHosts {
+		if strings.HasPrefix(url, host) {
+			url, found = url[len(host):], true
+			break
+		}
+	}
+	if !found {
+		return ""
+	}
+	i := 0
+	for i < len(url) && (isAlphanumericByte(url[i]) || url[i] == '-') {
+		i++
+	}
+	rest := strings.TrimPrefix(url[i:], "/")
+	if i == 0 || rest != "" && (rest[0] != '?' && rest[0] != '#' || strings.IndexByte(rest, '\n') >= 0) {
+		return ""
+	}
+	return url[:i]
+}
+
 /*
 ParserOptions is a configuration object used for creating a Parser with NewParser.
 
 DefaultParserOptions contains the default options that should be used for parsing. An empty ParserOptions is not the same as DefaultParserOptions!
+
+Presets matching how the Discord apps render each kind of content are also provided, such as MessageParserOptions
+for normal messages, EmbedDescriptionParserOptions for embed descriptions, or EmbedTitleParserOptions for embed titles.
 */
 type ParserOptions struct {
-	EnableBlockQuote    bool
-	EnableMaskedLinks   bool
-	EnableMentions      bool
+	EnableBlockQuote  bool
+	EnableMaskedLinks bool
+	EnableMentions    bool
+	// EnableForumMarkdown enables both EnableHeaders and EnableLists.
+	//
+	// Deprecated: Use EnableHeaders and EnableLists instead.
 	EnableForumMarkdown bool
+	// EnableHeaders enables parsing headers into HeaderNode.
+	EnableHeaders bool
+	// EnableLists enables parsing lists into BulletListNode.
+	EnableLists bool
+	// DisableURLs disables autolinking bare URLs, such as https://example.com, URLs with suppressed embeds,
+	// such as <https://example.com>, and email addresses into URLNode, keeping them as text.
+	// If set, no URLNode is ever produced, and masked links are not parsed regardless of EnableMaskedLinks.
+	// URLs are parsed by default, as they always were, so that existing ParserOptions literals keep parsing them.
+	DisableURLs bool
+	// DisableEscapes disables backslash escapes, such as \*, which are otherwise parsed into a TextNode of the escaped
+	// character: backslashes are kept as text and the following characters are parsed as usual,
+	// and the masks and titles of masked links are kept with their backslashes.
+	DisableEscapes bool
+	// DisableCustomEmoji keeps custom emoji, such as <:name:1234>, as typed, instead of parsing them into EmojiNode.
+	DisableCustomEmoji bool
+	// EnableNamedEmoji enables parsing emoji shortcodes, such as :thumbsup: or :thumbsup::skin-tone-2:,
+	// into NamedEmojiNode, rather than keeping them as text.
+	EnableNamedEmoji bool
+	// DisableTimestamps keeps timestamps, such as <t:1234567890:R>, as text, rather than parsing them into TimestampNode
+	// as by default.
+	DisableTimestamps bool
+	// LiteralIntrawordUnderscores keeps underscores inside words as text, like Discord does, so that
+	// identifiers such as snake_case_name or file_name_ are never parsed as italics.
+	// Underscore italics are only parsed when not directly preceded nor followed by a letter or digit, in any script.
+	LiteralIntrawordUnderscores bool
+	// SafeMaskedLinks keeps as text the masked links whose mask is blank or contains a URL, like Discord does,
+	// so that a masked link cannot hide its target, or disguise it as another URL, as in [https://a.com](https://b.com).
+	SafeMaskedLinks bool
+	// EnableMaskedLinkTitles sets the Title of masked links with a title, as in [mask](url "title"), which Discord
+	// only displays as a tooltip in embeds. Otherwise, the title of masked links is parsed but dropped, like in messages.
+	EnableMaskedLinkTitles bool
+	// MaskedLinkSchemes, if set, are the URL schemes allowed in masked links, compared case-insensitively,
+	// such as DiscordMaskedLinkSchemes. Masked links whose URL cannot be parsed, has no scheme, has another scheme,
+	// or is an http or https URL without host, are kept as text, so that renderers never produce links to URLs
+	// such as javascript:alert(1). The scheme and host of the URLs of the allowed masked links are lowercased.
+	MaskedLinkSchemes []string
+	// EnableLineBreaks enables parsing hard line breaks, two spaces or more or a backslash before a line break,
+	// into LineBreakNode, rather than keeping them as text.
+	EnableLineBreaks bool
+	// PreserveSoftHyphens keeps soft hyphens (U+00AD) in the Content of the TextNode they are parsed into,
+	// so that the message can be reproduced byte for byte, instead of parsing them into an empty TextNode as Discord does.
+	PreserveSoftHyphens bool
+	// StripInvisibleCharacters parses the invisible characters that can hide or disguise text, such as zero-width
+	// spaces and non-joiners, word joiners, and directional marks, embeddings, overrides and isolates, into a TextNode
+	// with an empty Content, like soft hyphens, so that they do not split or reorder the text seen by content filters
+	// and renderers. Zero-width joiners are kept, as they are part of emoji sequences.
+	StripInvisibleCharacters bool
+	// EnableUnknownTags enables parsing tags not known by the parser, such as <x:y:z>, into UnknownTagNode.
+	// Known tags, such as mentions or timestamps, are parsed into their nodes when enabled.
+	EnableUnknownTags bool
+	// InlineOnly disables all block rules, regardless of the other options: block quotes, headers and lists
+	// are not parsed, and code blocks are parsed as inline code. This is useful for single-line contexts,
+	// such as channel names, nicknames or message previews.
+	InlineOnly bool
+	// DisabledRules are the names of rules that are not parsed, such as RuleSpoiler, regardless of the other options.
+	// Unknown names are ignored. RuleText cannot be disabled.
+	DisabledRules []string
+	// RuleOrder changes the priority of rules: the named rules are tried in the listed order,
+	// in place of the priority slots they occupy by default, and the other rules are unchanged.
+	// For example, []string{RuleURL, RuleMaskedLink} tries bare URLs before masked links.
+	// Unknown and duplicate names are ignored.
+	RuleOrder []string
+	// Matchers replace the matching of the named rules, such as RuleBold, for example to use another
+	// regular expression engine. Each Matcher must return the same groups as the pattern of its rule,
+	// returned by RulePattern. Unknown names are ignored.
+	Matchers map[string]Matcher
+	// MergeText merges adjacent TextNode siblings of parsed messages, as done by MergeText.
+	MergeText bool
+	// MaxLength is the maximum length in bytes of parsed messages. Longer messages are kept as a single TextNode.
+	// Zero means no limit.
+	MaxLength int
+	// MaxNodes is the maximum number of nodes of parsed messages. Messages with more nodes are kept as a single TextNode.
+	// Zero means no limit.
+	MaxNodes int
+	// MaxDepth is the maximum depth of nested nodes, not counting the root node. Nodes that would contain deeper nodes
+	// are kept as a TextNode of their source instead. Zero means no limit.
+	MaxDepth int
+	// BehaviorVersion pins the Discord markdown behavior to parse, which may enable rules regardless of the other options.
+	// The zero value is BehaviorLegacy.
+	BehaviorVersion BehaviorVersion
+	// NormalizeCodeLanguages normalizes the language of code blocks with NormalizeLanguage.
+	NormalizeCodeLanguages bool
+	// NormalizeNewlines parses the CRLF and CR line breaks of messages, as sent by some gateways, as LF line breaks.
+	// The content of the parsed nodes has LF line breaks, and their spans are still offsets in the passed message.
+	NormalizeNewlines bool
+	// PoolNodes allocates the nodes of parsed messages from pools of nodes released with Release,
+	// to reduce allocations when parsing many short-lived messages.
+	PoolNodes bool
+	// Arena, if set, allocates the nodes of parsed messages from the Arena, instead of allocating them separately.
+	// Parsers with an Arena must not parse messages concurrently.
+	Arena *Arena
 }
 
+/*
+DiscordMaskedLinkSchemes are the URL schemes allowed by Discord in masked links, for ParserOptions.MaskedLinkSchemes.
+*/
+var DiscordMaskedLinkSchemes = []string{"http", "https", "discord"}
+
 /*
 DefaultParserOptions is the default parser configurations for usual message parsing.
 It should be used for most use cases.
+
+Masked links, when enabled, are restricted to the http, https and discord schemes by MaskedLinkSchemes.
+Previous versions accepted masked links with any URL: setting MaskedLinkSchemes to nil on a copy restores that.
 */
 var DefaultParserOptions = ParserOptions{
-	EnableBlockQuote: true,
-	EnableMentions:   true,
+	EnableBlockQuote:            true,
+	EnableMentions:              true,
+	LiteralIntrawordUnderscores: true,
+	SafeMaskedLinks:             true,
+	MaskedLinkSchemes:           []string{"http", "https", "discord"},
+	NormalizeNewlines:           true,
 }
 
 /*
@@ -329,328 +914,652 @@ As a special case, passing nil is equivalent to passing DefaultParserOptions.
 Passing an empty ParserOptions struct is not the same as passing DefaultParserOptions / nil.
 This should be avoided unless you do want a ParserOptions with all fields set to false.
 
-The Parser returned by NewParser can be reused for parsing multiple concurrent messages. There is no internal state.
+The Parser returned by NewParser can be reused for parsing multiple concurrent messages. It has no internal state,
+except for the Arena of the options, if any: parsers with an Arena must not parse messages concurrently.
+Creating a Parser is cheap: the rules of the parser are shared with the parsers previously created
+with the same enabled rules.
 */
 func NewParser(options *ParserOptions) *Parser {
 	if options == nil {
 		options = &DefaultParserOptions
 	}
 
-	rules := make([]rule, 0, 16)
-	rules = append(rules, rule{
+	return &Parser{
+		options: *options,
+		rules:   rulesFor(options),
+	}
+}
+
+var (
+	defaultParser     *Parser
+	defaultParserOnce sync.Once
+)
+
+/*
+Default returns a shared Parser created with DefaultParserOptions on the first call to Default.
+
+It avoids creating a Parser for each message in programs that only use the default options.
+*/
+func Default() *Parser {
+	defaultParserOnce.Do(func() {
+		defaultParser = NewParser(nil)
+	})
+	return defaultParser
+}
+
+// enabledRules returns the rules enabled by the parser options, by order of priority.
+func enabledRules(options *ParserOptions) []rule {
+	disabled := make(map[string]bool, len(options.DisabledRules))
+	for _, name := range options.DisabledRules {
+		if name != RuleText {
+			disabled[name] = true
+		}
+	}
+	rules := make([]rule, 0, len(parserRules))
+	for _, r := range orderedRules(options.RuleOrder) {
+		if disabled[r.name] {
+			continue
+		}
+		if r.enabled != nil && !r.enabled(options) {
+			continue
+		}
+		if m, ok := options.Matchers[r.name]; ok {
+			r.first = ""
+			r.scan = func(dst []int, s string, memo *scanMemo) []int {
+				return m.FindStringSubmatchIndex(s)
+			}
+		}
+		if r.name == RuleText && options.StripInvisibleCharacters {
+			r.scan = scanTextVisible(r.scan)
+		}
+		if !hasRegexp && r.scan == nil {
+			// the rule cannot be matched with the noregexp build tag
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// parserRules are all the rules of the parser, by order of priority.
+var parserRules = []rule{
+	{
+		name:    RuleSoftHyphen,
 		pattern: patternSoftHyphen,
+		first:   "\xc2",
+		scan:    scanSoftHyphen,
 		parser: func(match match) parseSpec {
+			content := ""
+			if match.options.PreserveSoftHyphens {
+				content = match.group(0)
+			}
 			return parseSpec{
-				node: &TextNode{
+				node: allocNode(match.options, TextNode{
+					Content: content,
+				}),
+			}
+		},
+	},
+	{
+		name: RuleInvisible,
+		enabled: func(options *ParserOptions) bool {
+			return options.StripInvisibleCharacters
+		},
+		pattern: patternInvisible,
+		first:   "\xd8\xe2\xef",
+		scan:    scanInvisible,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, TextNode{
 					Content: "",
-				},
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name: RuleEscape,
+		enabled: func(options *ParserOptions) bool {
+			return !options.DisableEscapes
+		},
 		pattern: patternEscape,
+		first:   "\\",
+		scan:    scanEscape,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &TextNode{
+				node: allocNode(match.options, TextNode{
 					Content: match.group(1),
-				},
+					Escaped: true,
+				}),
 			}
 		},
-	})
-	if options.EnableBlockQuote {
-		rules = append(rules, rule{
-			pattern: patternBlockQuote,
-			block:   true,
-			parser: func(match match) parseSpec {
-				var i int
-				if len(match.group(1)) > 0 {
-					i = 1
-				} else {
-					i = 2
-				}
+	},
+	{
+		name: RuleBlockQuote,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableBlockQuote && !options.InlineOnly
+		},
+		pattern: patternBlockQuote,
+		first:   " >",
+		scan:    scanBlockQuote,
+		block:   true,
+		parser: func(match match) parseSpec {
+			if match.start(1) >= 0 {
 				return parseSpec{
-					node:  &BlockQuoteNode{},
-					start: match.start(i),
-					end:   match.end(i),
+					node: allocNode(match.options, BlockQuoteNode{
+						Delimiter: ">>>",
+					}),
+					start: match.start(1),
+					end:   match.end(1),
 				}
-			},
-			blockQuote: true,
-		})
-	}
-	rules = append(rules, rule{
+			}
+			// consecutive quoted lines are a single block quote, whose content is the lines without their >
+			// and the following space
+			spec := parseSpec{
+				node: allocNode(match.options, BlockQuoteNode{
+					Delimiter: ">",
+				}),
+			}
+			for start, end := match.start(2), match.end(2); start < end; {
+				lineEnd := end
+				if i := strings.IndexByte(match.match[start:end], '\n'); i >= 0 {
+					lineEnd = start + i + 1
+				}
+				contentStart := start + strings.IndexByte(match.match[start:], '>') + 2
+				if start == match.start(2) {
+					spec.start, spec.end = contentStart, lineEnd
+				} else {
+					spec.more = append(spec.more, Span{Start: contentStart, End: lineEnd})
+				}
+				start = lineEnd
+			}
+			return spec
+		},
+		blockQuote: true,
+	},
+	{
+		name:    RuleCodeBlock,
 		pattern: patternCodeBlock,
+		first:   "`",
+		scan:    scanCodeBlock,
 		parser: func(match match) parseSpec {
+			language := match.group(1)
+			if match.options.NormalizeCodeLanguages {
+				language = NormalizeLanguage(language)
+			}
 			return parseSpec{
-				node: &CodeNode{
-					Content:  match.group(3),
-					Language: match.group(1),
-				},
+				node: allocNode(match.options, CodeNode{
+					Content:     match.group(3),
+					Language:    language,
+					RawLanguage: match.group(1),
+					Inline:      match.options.InlineOnly,
+					Delimiter:   "```",
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleCodeInline,
 		pattern: patternCodeInline,
+		first:   "`",
+		scan:    scanCodeInline,
 		parser: func(match match) parseSpec {
-			i := 1
-			if len(match.group(2)) > 0 {
-				i = 2
+			i, delimiter := 1, "``"
+			if match.start(1) == -1 {
+				i, delimiter = 2, "`"
 			}
 			return parseSpec{
-				node: &CodeNode{
-					Content: match.group(i),
-				},
+				node: allocNode(match.options, CodeNode{
+					Content:   match.group(i),
+					Inline:    true,
+					Delimiter: delimiter,
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleSpoiler,
 		pattern: patternSpoiler,
+		first:   "|",
+		scan:    scanSpoiler,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node:  &SpoilerNode{},
+				node:  allocNode(match.options, SpoilerNode{}),
 				start: match.start(1),
 				end:   match.end(1),
 			}
 		},
-	})
-	if options.EnableMaskedLinks {
-		rules = append(rules, rule{
-			pattern: patternMaskedLink,
-			parser: func(match match) parseSpec {
-				// intentionally not implementing the pathological masked link attack workaround here.
-				mask := match.group(1)
-				mask = mask[1 : len(mask)-1]
-				return parseSpec{
-					node: &URLNode{
-						URL:  match.group(2),
-						Mask: mask,
-					},
+	},
+	{
+		name: RuleMaskedLink,
+		enabled: func(options *ParserOptions) bool {
+			return options.maskedLinks() && !options.DisableURLs
+		},
+		pattern: patternMaskedLink,
+		first:   "[",
+		scan:    scanMaskedLink,
+		parser: func(match match) parseSpec {
+			mask := match.group(1)
+			mask = mask[1 : len(mask)-1]
+			title := ""
+			if match.options.EnableMaskedLinkTitles {
+				title = match.group(3)
+			}
+			if !match.options.DisableEscapes {
+				mask, title = unescape(mask), unescape(title)
+			}
+			if match.options.SafeMaskedLinks && unsafeMask(mask) {
+				return parseSpec{}
+			}
+			target := match.group(2)
+			if match.options.MaskedLinkSchemes != nil {
+				var ok bool
+				if target, ok = allowedURL(target, match.options.MaskedLinkSchemes); !ok {
+					return parseSpec{}
 				}
-			},
-		})
-	}
-	rules = append(rules, rule{
+			}
+			// the angle brackets around the URL are optional in the pattern
+			start, end := match.start(2), match.end(2)
+			suppressed := start > 0 && match.match[start-1] == '<' && end < len(match.match) && match.match[end] == '>'
+			return parseSpec{
+				node: allocNode(match.options, URLNode{
+					URL:        target,
+					Mask:       mask,
+					Title:      title,
+					Invite:     inviteCode(target),
+					Suppressed: suppressed,
+				}),
+			}
+		},
+	},
+	{
+		name: RuleURLNoEmbed,
+		enabled: func(options *ParserOptions) bool {
+			return !options.DisableURLs
+		},
 		pattern: patternURLNoEmbed,
+		first:   "<",
+		scan:    scanURLNoEmbed,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &URLNode{
-					URL: match.group(1),
-				},
+				node: allocNode(match.options, URLNode{
+					URL:        match.group(1),
+					Invite:     inviteCode(match.group(1)),
+					Suppressed: true,
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name: RuleURL,
+		enabled: func(options *ParserOptions) bool {
+			return !options.DisableURLs
+		},
 		pattern: patternURL,
+		first:   "h",
+		scan:    scanURL,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &URLNode{
-					URL: match.group(1),
-				},
+				node: allocNode(match.options, URLNode{
+					URL:    match.group(1),
+					Invite: inviteCode(match.group(1)),
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name: RuleEmail,
+		enabled: func(options *ParserOptions) bool {
+			return !options.DisableURLs
+		},
+		pattern: patternEmail,
+		first:   alphanumeric + ".!#$%&'+/=?^_{}-",
+		scan:    scanEmail,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, URLNode{
+					URL:  "mailto:" + match.group(1),
+					Mask: match.group(1),
+				}),
+			}
+		},
+	},
+	{
+		name: RuleCustomEmoji,
+		enabled: func(options *ParserOptions) bool {
+			return !options.DisableCustomEmoji
+		},
 		pattern: patternCustomEmoji,
+		first:   "<",
+		scan:    scanCustomEmoji,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &EmojiNode{
+				node: allocNode(match.options, EmojiNode{
 					Animated: len(match.group(1)) > 0,
 					Text:     match.group(2),
 					ID:       match.group(3),
-				},
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleNamedEmoji,
 		pattern: patternNamedEmoji,
+		first:   ":",
+		scan:    scanNamedEmoji,
 		parser: func(match match) parseSpec {
-			emojiName := match.group(0)
-			// TODO: parse the emoji data into the actual unicode emoji
+			if !match.options.EnableNamedEmoji {
+				return parseSpec{
+					node: allocNode(match.options, TextNode{
+						Content: match.group(0),
+					}),
+				}
+			}
+			name, skinTone := match.group(1), 0
+			if i := strings.LastIndex(name, "::skin-tone-"); i >= 0 {
+				if tone := name[len(name)-1] - '0'; tone >= 1 && tone <= 5 {
+					name, skinTone = name[:i], int(tone)
+				}
+			}
 			return parseSpec{
-				node: &TextNode{
-					Content: emojiName,
-				},
+				node: allocNode(match.options, NamedEmojiNode{
+					Name:     name,
+					SkinTone: skinTone,
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleEmoticon,
 		pattern: patternUnescapeEmoticon,
+		first:   "\xc2",
+		scan:    scanEmoticon,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &TextNode{
+				node: allocNode(match.options, TextNode{
 					Content: match.group(1),
-				},
+				}),
 			}
 		},
-	})
-	if options.EnableMentions {
-		rules = append(rules, rule{
-			pattern: patternChannelMention,
-			parser: func(match match) parseSpec {
-				return parseSpec{
-					node: &ChannelMentionNode{
-						ID: match.group(1),
-					},
-				}
-			},
-		})
-		rules = append(rules, rule{
-			pattern: patternRoleMention,
-			parser: func(match match) parseSpec {
-				return parseSpec{
-					node: &RoleMentionNode{
-						ID: match.group(1),
-					},
-				}
-			},
-		})
-		rules = append(rules, rule{
-			pattern: patternUserMention,
-			parser: func(match match) parseSpec {
-				return parseSpec{
-					node: &UserMentionNode{
-						ID: match.group(1),
-					},
-				}
-			},
-		})
-		rules = append(rules, rule{
-			pattern: patternSpecialMention,
-			parser: func(match match) parseSpec {
-				return parseSpec{
-					node: &SpecialMentionNode{
-						Mention: match.group(1),
-					},
-				}
-			},
-		})
-	}
+	},
+	{
+		name: RuleChannelMention,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableMentions
+		},
+		pattern: patternChannelMention,
+		first:   "<",
+		scan:    scanChannelMention,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, ChannelMentionNode{
+					ID: match.group(1),
+				}),
+			}
+		},
+	},
+	{
+		name: RuleRoleMention,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableMentions
+		},
+		pattern: patternRoleMention,
+		first:   "<",
+		scan:    scanRoleMention,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, RoleMentionNode{
+					ID: match.group(1),
+				}),
+			}
+		},
+	},
+	{
+		name: RuleUserMention,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableMentions
+		},
+		pattern: patternUserMention,
+		first:   "<",
+		scan:    scanUserMention,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, UserMentionNode{
+					ID: match.group(1),
+				}),
+			}
+		},
+	},
+	{
+		name: RuleSpecialMention,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableMentions
+		},
+		pattern: patternSpecialMention,
+		first:   "@",
+		scan:    scanSpecialMention,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, SpecialMentionNode{
+					Mention: match.group(1),
+				}),
+			}
+		},
+	},
 	// TODO: dynamic unicodeEmoji pattern
-	rules = append(rules, rule{
+	{
+		name: RuleTimestamp,
+		enabled: func(options *ParserOptions) bool {
+			return !options.DisableTimestamps
+		},
 		pattern: patternTimestamp,
+		first:   "<",
+		scan:    scanTimestamp,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &TimestampNode{
+				node: allocNode(match.options, TimestampNode{
 					Stamp:  match.group(1),
 					Format: match.group(2),
-				},
+				}),
 			}
 		},
-	})
-	if options.EnableForumMarkdown {
-		rules = append(rules, rule{
-			pattern: patternHeaderItem,
-			block:   true,
-			parser: func(match match) parseSpec {
-				n := 1
-				if len(match.group(2)) > 0 {
-					n = len(match.group(2))
-				}
-				return parseSpec{
-					node: &HeaderNode{
-						Level: n,
-					},
-					start:    match.start(3),
-					end:      match.end(3),
-					matchEnd: match.end(1),
-				}
-			},
-		})
-		rules = append(rules, rule{
-			pattern: patternListItem,
-			parser: func(match match) parseSpec {
-				level := 1
-				if len(match.group(1)) > 0 {
-					level = 2
+	},
+	{
+		name: RuleUnknownTag,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableUnknownTags
+		},
+		pattern: patternUnknownTag,
+		first:   "<",
+		scan:    scanUnknownTag,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, UnknownTagNode{
+					Raw:     match.group(0),
+					Name:    match.group(1),
+					Content: match.group(2),
+				}),
+			}
+		},
+	},
+	{
+		name: RuleHeader,
+		enabled: func(options *ParserOptions) bool {
+			return options.headers() && !options.InlineOnly
+		},
+		pattern: patternHeaderItem,
+		scan:    scanHeader,
+		first:   "\t\n\f\r #",
+		block:   true,
+		parser: func(match match) parseSpec {
+			n := 1
+			if len(match.group(2)) > 0 {
+				n = len(match.group(2))
+			}
+			return parseSpec{
+				node: allocNode(match.options, HeaderNode{
+					Level:  n,
+					Marker: match.match[:match.start(3)],
+				}),
+				start:    match.start(3),
+				end:      match.end(3),
+				matchEnd: match.end(1),
+			}
+		},
+	},
+	{
+		name: RuleList,
+		enabled: func(options *ParserOptions) bool {
+			return options.lists() && !options.InlineOnly
+		},
+		pattern: patternListItem,
+		scan:    scanList,
+		first:   "\t\f *-",
+		parser: func(match match) parseSpec {
+			level := 1
+			if len(match.group(1)) > 0 {
+				level = 2
+			}
+			spec := parseSpec{
+				node: allocNode(match.options, BulletListNode{
+					NestedLevel:     level,
+					IncludesNewline: len(match.group(4)) > 0,
+					Delimiter:       match.match[match.end(1) : match.end(1)+1],
+				}),
+				start: match.start(2),
+				end:   match.end(2),
+			}
+			// the following indented lines continue the item: their content is the line without its indentation,
+			// after the line break ending the previous line
+			for start, end := match.start(3), match.end(3); start < end; {
+				lineEnd := end
+				if i := strings.IndexByte(match.match[start+1:end], '\n'); i >= 0 {
+					lineEnd = start + 1 + i
 				}
-				return parseSpec{
-					node: &BulletListNode{
-						NestedLevel:     level,
-						IncludesNewline: len(match.group(3)) > 0,
-					},
-					start: match.start(2),
-					end:   match.end(2),
+				if len(spec.more) == 0 {
+					spec.end = start + 1
+				} else {
+					spec.more[len(spec.more)-1].End = start + 1
 				}
-			},
-		})
-	}
-	rules = append(rules, rule{
+				contentStart := lineEnd - len(strings.TrimLeft(match.match[start+1:lineEnd], " \t\v\f"))
+				spec.more = append(spec.more, Span{Start: contentStart, End: lineEnd})
+				start = lineEnd
+			}
+			return spec
+		},
+	},
+	{
+		name: RuleLineBreak,
+		enabled: func(options *ParserOptions) bool {
+			return options.EnableLineBreaks
+		},
+		pattern: patternLineBreak,
+		first:   " \\",
+		scan:    scanLineBreak,
+		parser: func(match match) parseSpec {
+			return parseSpec{
+				node: allocNode(match.options, LineBreakNode{}),
+			}
+		},
+	},
+	{
+		name:    RuleNewline,
 		pattern: patternNewline,
+		first:   "\n",
+		scan:    scanNewline,
 		block:   true,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node: &TextNode{
+				node: allocNode(match.options, TextNode{
 					Content: "\n",
-				},
+				}),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleBold,
 		pattern: patternBold,
+		first:   "*",
+		scan:    scanBold,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node:     &BoldNode{},
+				node:     allocNode(match.options, BoldNode{}),
 				start:    match.start(2),
 				end:      match.end(2),
 				matchEnd: match.end(1),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleUnderline,
 		pattern: patternUnderline,
+		first:   "_",
+		scan:    scanUnderline,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node:     &UnderlineNode{},
+				node:     allocNode(match.options, UnderlineNode{}),
 				start:    match.start(2),
 				end:      match.end(2),
 				matchEnd: match.end(1),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleItalics,
 		pattern: patternItalics,
+		first:   "_*",
+		scan:    scanItalics,
 		parser: func(match match) parseSpec {
-			content := 2
+			if len(match.group(1)) > 0 && match.options.LiteralIntrawordUnderscores {
+				if isWordRune(match.prev()) || isWordRune(match.next()) {
+					return parseSpec{}
+				}
+			}
+			content, delimiter := 2, "_"
 			if len(match.group(4)) > 0 {
-				content = 4
+				content, delimiter = 4, "*"
 			}
 			total := 1
 			if len(match.group(3)) > 0 {
 				total = 3
 			}
 			return parseSpec{
-				node:     &ItalicsNode{},
+				node: allocNode(match.options, ItalicsNode{
+					Delimiter: delimiter,
+				}),
 				start:    match.start(content),
 				end:      match.end(content),
 				matchEnd: match.end(total),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleStrikethrough,
 		pattern: patternStrikethrough,
+		first:   "~",
+		scan:    scanStrikethrough,
 		parser: func(match match) parseSpec {
 			return parseSpec{
-				node:  &StrikethroughNode{},
+				node:  allocNode(match.options, StrikethroughNode{}),
 				start: match.start(1),
 				end:   match.end(1),
 			}
 		},
-	})
-	rules = append(rules, rule{
+	},
+	{
+		name:    RuleText,
 		pattern: patternText,
+		scan:    scanText,
 		parser: func(match match) parseSpec {
 			// TODO: replace the passed string with replaceEmojiSurrogates,
 			// then parse it with rules={namedEmojiRule, patternTextRule}
 			return parseSpec{
-				node: &TextNode{
+				node: allocNode(match.options, TextNode{
 					Content: match.group(1),
-				},
+				}),
 				matchEnd: match.end(1),
 			}
 		},
-	})
-	return &Parser{
-		rules: rules,
-	}
+	},
 }
 
 /*
@@ -661,9 +1570,178 @@ The root Node is always a private node structure that contains a list of Node ch
 Walk can be used to process the AST returned by this tree.
 */
 func (p *Parser) Parse(source string) Node {
+	n, _, _ := parse(context.Background(), source, &p.options, p.rules, parseMode{})
+	return n
+}
+
+/*
+ParseStrict parses the passed Discord message into an AST, like Parse, but returns an error
+instead of falling back to text when a part of the message cannot be parsed.
+
+Parse never panics nor fails: parts of the message that cannot be parsed are kept as TextNode,
+and the whole message is returned as a single TextNode if parsing fails unexpectedly.
+ParseStrict can be used to detect these cases, for example in tests.
+
+ParseStrict also returns an error when a limit of the options, such as MaxLength, is exceeded.
+*/
+func (p *Parser) ParseStrict(source string) (Node, error) {
+	n, _, err := parse(context.Background(), source, &p.options, p.rules, parseMode{strict: true})
+	return n, err
+}
+
+/*
+ParseContext parses the passed Discord message into an AST, like Parse, but aborts parsing when
+the passed context is done, for example on cancellation or deadline, in which case the context error is returned.
+
+The context is checked between each rule match, which bounds the parsing time of pathological messages.
+*/
+func (p *Parser) ParseContext(ctx context.Context, source string) (Node, error) {
+	n, _, err := parse(ctx, source, &p.options, p.rules, parseMode{})
+	return n, err
+}
+
+/*
+ParseEvents parses the passed Discord message like Parse, but instead of building an AST, calls the passed Walker
+on entering and leaving each node as it is parsed, in the same order as Walk on the tree returned by Parse.
+
+This avoids building and retaining a tree, for example for indexing many messages. The nodes passed to the Walker
+have no children, parent nor siblings, and should not be retained. ParserOptions.MergeText and ParserOptions.MaxNodes
+are not applied.
+*/
+func (p *Parser) ParseEvents(source string, w Walker) {
+	parse(context.Background(), source, &p.options, p.rules, parseMode{events: w})
+}
+
+/*
+Token is a rule match of the parser, returned by Tokens.
+*/
+type Token struct {
+	// Rule is the name of the matched rule, such as RuleBold.
+	Rule string
+	// Span is the range of the source consumed by the match.
+	Span Span
+	// Depth is the depth of the node produced by the match in the tree, the children of the root being at depth 0.
+	Depth int
+	// Groups are the ranges of the source captured by the groups of the rule pattern, the first being the whole pattern
+	// match, which can be longer than Span. Groups that did not match have a Start and End of -1.
+	Groups []Span
+}
+
+/*
+Tokens parses the passed Discord message like Parse, but returns the list of rule matches of the parser
+instead of building an AST, in the order they are found, which is the order of the nodes in the tree.
+
+Tokens of container nodes, such as RuleBold, are followed by the tokens of their content.
+*/
+func (p *Parser) Tokens(source string) []Token {
+	var tokens []Token
+	parse(context.Background(), source, &p.options, p.rules, parseMode{
+		events: func(n Node, entering bool) {},
+		tokens: func(t Token) {
+			tokens = append(tokens, t)
+		},
+	})
+	return tokens
+}
+
+/*
+Diagnostic is a problem found while parsing a message, returned by ParseDiagnostics.
+*/
+type Diagnostic struct {
+	// Offset is the byte offset in the message where the problem was found.
+	Offset int
+	// Reason is a human-readable description of the problem.
+	Reason string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("offset %d: %s", d.Offset, d.Reason)
+}
+
+/*
+ParseDiagnostics parses the passed Discord message into an AST, like Parse, and also returns
+the list of problems found while parsing, by order of offset.
+
+Like Parse, it never fails: parts of the message that cannot be parsed are kept as TextNode,
+and a Diagnostic is returned for each of them.
+*/
+func (p *Parser) ParseDiagnostics(source string) (Node, []Diagnostic) {
+	n, diagnostics, _ := parse(context.Background(), source, &p.options, p.rules, parseMode{})
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Offset < diagnostics[j].Offset
+	})
+	return n, diagnostics
+}
+
+/*
+ParseWith parses the passed Discord message into an AST, like Parse, but with the passed options instead of
+the options the Parser was created with.
+
+This can be used to parse messages with different options, such as embeds and normal messages, with a single Parser.
+As a special case, passing nil is equivalent to calling Parse.
+*/
+func (p *Parser) ParseWith(source string, options *ParserOptions) Node {
+	if options == nil {
+		return p.Parse(source)
+	}
+	n, _, _ := parse(context.Background(), source, options, rulesFor(options), parseMode{})
+	return n
+}
+
+// parseMode is the mode of a parse call.
+type parseMode struct {
+	// strict makes parse fail rather than keep parts that cannot be parsed as text.
+	strict bool
+	// events, if set, is called on entering and leaving each parsed node, in the order of Walk, instead of building a tree.
+	events Walker
+	// tokens, if set, is called on each rule match.
+	tokens func(t Token)
+	// quote parses the content of a block quote, in which block quotes are not parsed.
+	quote bool
+}
+
+// parse parses source with rules. If mode.strict is false, it never fails: parts that cannot be parsed are kept as text,
+// and reported in the returned diagnostics.
+func parse(ctx context.Context, source string, options *ParserOptions, rules *ruleSet, mode parseMode) (root Node, diagnostics []Diagnostic, err error) {
+	if options.NormalizeNewlines && strings.IndexByte(source, '\r') >= 0 {
+		return parseNormalized(ctx, source, options, rules, mode)
+	}
+	strict, events, tokens := mode.strict, mode.events, mode.tokens
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to parse source: %v", r)
+			if strict {
+				root = nil
+				return
+			}
+			root = textRoot(source)
+			diagnostics = []Diagnostic{{
+				Offset: 0,
+				Reason: err.Error(),
+			}}
+		}
+	}()
+
+	if options.MaxLength > 0 && len(source) > options.MaxLength {
+		err := fmt.Errorf("source length %d exceeds maximum length %d", len(source), options.MaxLength)
+		if strict {
+			return nil, nil, err
+		}
+		root := textRoot(source)
+		if events != nil {
+			Walk(root, events)
+		}
+		return root, []Diagnostic{{Offset: options.MaxLength, Reason: err.Error()}}, nil
+	}
+
 	remainingParses := make([]parseSpec, 0, 16)
-	topLevelRootNode := &node{}
+	topLevelRootNode := allocNode(options, node{span: Span{Start: 0, End: len(source)}})
+	topLevelRootNode.self = topLevelRootNode
 	lastCapture := ""
+	nodes := 0
+	// groupsBuffer is reused for the matches of the rules, which are only used until the next match
+	groupsBuffer := make([]int, 0, 16)
+	memo := newScanMemo(len(rules.rules))
 
 	if len(source) > 0 {
 		remainingParses = append(remainingParses, parseSpec{
@@ -672,30 +1750,76 @@ func (p *Parser) Parse(source string) Node {
 			end:   len(source),
 		})
 	}
+	if events != nil {
+		events(topLevelRootNode, true)
+	}
 
-	// TODO: do not nest multiple block quotes
+	// block quotes are not nested, as in Discord: quote syntax in a block quote is kept as text
 	blockQuoteEnd := 0
 
 	for len(remainingParses) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		builder := remainingParses[len(remainingParses)-1]
 		remainingParses = remainingParses[:len(remainingParses)-1]
+		if builder.leave {
+			events(builder.node, false)
+			continue
+		}
+		if builder.capture != "" {
+			lastCapture = builder.capture
+		}
 		if builder.start >= builder.end {
-			break
+			continue
 		}
 		inspectionSource := source[builder.start:builder.end]
 		offset := builder.start
+		memo.setEnd(builder.end)
 
 		var rule rule
 		var groups []int
-		for _, r := range p.rules {
-			if r.block && lastCapture != "" && !strings.HasSuffix(lastCapture, "\n") {
+		var newBuilder parseSpec
+		lineStart := 0
+		// as in Discord, block rules only match at the top level or in a block quote, never in the content
+		// of other nodes; the previous capture can end with spaces after its line break, and the content
+		// of a block quote follows the capture preceding the block quote
+		if _, quote := builder.node.(*BlockQuoteNode); builder.node == Node(topLevelRootNode) || quote {
+			if lastCapture == "" || strings.HasSuffix(strings.TrimRight(lastCapture, " "), "\n") {
+				lineStart = 1
+			}
+		}
+		for _, i := range rules.candidates[lineStart][inspectionSource[0]] {
+			r := rules.rules[i]
+			if r.blockQuote && (mode.quote || builder.start < blockQuoteEnd) {
+				continue
+			}
+			if memo.skipped(int(i), offset, builder.end) {
 				continue
 			}
-			if r.blockQuote && builder.start < blockQuoteEnd {
+			var g []int
+			if r.scan != nil {
+				memo.skip = 0
+				g = r.scan(groupsBuffer[:0], inspectionSource, memo)
+				if cap(g) > cap(groupsBuffer) {
+					groupsBuffer = g
+				}
+				if g == nil && memo.skip > 0 {
+					memo.misses[i] = scanMiss{span: Span{Start: offset, End: offset + memo.skip}, end: builder.end}
+				}
+			} else {
+				g = r.pattern.FindStringSubmatchIndex(inspectionSource)
+			}
+			if g == nil || g[1] == 0 {
 				continue
 			}
-			g := r.pattern.FindStringSubmatchIndex(inspectionSource)
-			if g == nil {
+			newBuilder = r.parser(match{
+				options: options,
+				match:   inspectionSource,
+				groups:  g,
+				before:  source[:offset],
+			})
+			if newBuilder.node == nil {
 				continue
 			}
 			rule = r
@@ -703,42 +1827,255 @@ func (p *Parser) Parse(source string) Node {
 			break
 		}
 		if len(groups) == 0 {
-			panic(fmt.Sprintf("failed to find rule to match source: %s", source))
+			if strict {
+				return nil, nil, fmt.Errorf("failed to find rule to match source at offset %d", offset)
+			}
+			// keep the remaining source as text rather than failing
+			diagnostics = append(diagnostics, Diagnostic{
+				Offset: offset,
+				Reason: "failed to find rule to match source",
+			})
+			text := &TextNode{
+				node:    node{span: Span{Start: offset, End: builder.end}},
+				Content: inspectionSource,
+			}
+			if events != nil {
+				events(text, true)
+				events(text, false)
+			} else {
+				builder.node.AppendChild(text)
+			}
+			lastCapture = inspectionSource
+			continue
 		}
 
-		newBuilder := rule.parser(match{
-			parser: p,
-			match:  inspectionSource,
-			groups: groups,
-		})
 		if newBuilder.matchEnd == 0 {
 			newBuilder.matchEnd = groups[1]
 		}
+		hasContent := newBuilder.start != 0 || newBuilder.end != 0
+		if hasContent && options.MaxDepth > 0 && builder.depth+2 > options.MaxDepth {
+			err := fmt.Errorf("maximum depth %d exceeded", options.MaxDepth)
+			if strict {
+				return nil, nil, fmt.Errorf("%v at offset %d", err, offset)
+			}
+			// keep the node as text rather than nesting deeper
+			diagnostics = append(diagnostics, Diagnostic{
+				Offset: offset,
+				Reason: err.Error(),
+			})
+			newBuilder = parseSpec{
+				node: &TextNode{
+					Content: inspectionSource[:newBuilder.matchEnd],
+				},
+				matchEnd: newBuilder.matchEnd,
+			}
+			hasContent = false
+		}
+		newBuilder.node.setSpan(Span{Start: offset, End: offset + newBuilder.matchEnd})
+		if tokens != nil {
+			token := Token{
+				Rule:   rule.name,
+				Span:   newBuilder.node.Span(),
+				Depth:  builder.depth,
+				Groups: make([]Span, len(groups)/2),
+			}
+			for i := range token.Groups {
+				if groups[i*2] == -1 {
+					token.Groups[i] = Span{Start: -1, End: -1}
+				} else {
+					token.Groups[i] = Span{Start: offset + groups[i*2], End: offset + groups[i*2+1]}
+				}
+			}
+			tokens(token)
+		}
 		parent := builder.node
-		parent.addChild(newBuilder.node)
+		if events == nil {
+			parent.AppendChild(newBuilder.node)
+		}
+		nodes++
+		if options.MaxNodes > 0 && nodes > options.MaxNodes && events == nil {
+			err := fmt.Errorf("maximum node count %d exceeded", options.MaxNodes)
+			if strict {
+				return nil, nil, fmt.Errorf("%v at offset %d", err, offset)
+			}
+			return textRoot(source), []Diagnostic{{Offset: offset, Reason: err.Error()}}, nil
+		}
 
+		capture := inspectionSource[:newBuilder.matchEnd]
 		matcherSourceEnd := newBuilder.matchEnd + offset
 		if matcherSourceEnd != builder.end {
 			remainingParses = append(remainingParses, parseSpec{
-				node:  parent,
-				start: matcherSourceEnd,
-				end:   builder.end,
+				node:    parent,
+				start:   matcherSourceEnd,
+				end:     builder.end,
+				depth:   builder.depth,
+				capture: capture,
 			})
 		}
 
-		if newBuilder.start != 0 || newBuilder.end != 0 {
+		if hasContent && rule.blockQuote && len(newBuilder.more) > 0 {
+			// the lines of a > block quote are parsed together, without their >, as in Discord
+			if events != nil {
+				events(newBuilder.node, true)
+			}
+			quoteNodes, quoteDiagnostics, err := parseQuote(ctx, source, offset, newBuilder, options, rules, mode, builder.depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			diagnostics = append(diagnostics, quoteDiagnostics...)
+			if events != nil {
+				events(newBuilder.node, false)
+			}
+			nodes += quoteNodes
+			if options.MaxNodes > 0 && nodes > options.MaxNodes && events == nil {
+				err := fmt.Errorf("maximum node count %d exceeded", options.MaxNodes)
+				if strict {
+					return nil, nil, fmt.Errorf("%v at offset %d", err, offset)
+				}
+				return textRoot(source), []Diagnostic{{Offset: offset, Reason: err.Error()}}, nil
+			}
+			lastCapture = capture
+			continue
+		}
+
+		if events != nil {
+			events(newBuilder.node, true)
+			if hasContent {
+				remainingParses = append(remainingParses, parseSpec{
+					node:  newBuilder.node,
+					leave: true,
+				})
+			} else {
+				events(newBuilder.node, false)
+			}
+		}
+		if hasContent {
+			// push the ranges of the content in reverse order, so that they are parsed in order;
+			// each range follows the line break ending the previous one
+			for i := len(newBuilder.more) - 1; i >= 0; i-- {
+				remainingParses = append(remainingParses, parseSpec{
+					node:    newBuilder.node,
+					start:   offset + newBuilder.more[i].Start,
+					end:     offset + newBuilder.more[i].End,
+					depth:   builder.depth + 1,
+					capture: "\n",
+				})
+			}
 			newBuilder.start += offset
 			newBuilder.end += offset
+			newBuilder.depth = builder.depth + 1
+			newBuilder.more = nil
 			remainingParses = append(remainingParses, newBuilder)
 		}
-		if rule.blockQuote {
-			blockQuoteEnd = newBuilder.end
+		if rule.blockQuote && hasContent {
+			blockQuoteEnd = offset + newBuilder.matchEnd
+		}
+
+		if !hasContent {
+			lastCapture = capture
+		}
+	}
+
+	if events != nil {
+		events(topLevelRootNode, false)
+	} else if options.MergeText {
+		MergeText(topLevelRootNode)
+	}
+	return topLevelRootNode, diagnostics, nil
+}
+
+// parseQuote parses the content of a > block quote of several lines, matched at offset, as a single text made of
+// its lines without their >, and adds the parsed nodes to the block quote, with their spans in source.
+// It returns the number of parsed nodes.
+func parseQuote(ctx context.Context, source string, offset int, spec parseSpec, options *ParserOptions, rules *ruleSet, mode parseMode, depth int) (int, []Diagnostic, error) {
+	ranges := append([]Span{{Start: spec.start, End: spec.end}}, spec.more...)
+	var sb strings.Builder
+	// starts are the offsets of the ranges in the content
+	starts := make([]int, len(ranges))
+	for i, r := range ranges {
+		starts[i] = sb.Len()
+		sb.WriteString(source[offset+r.Start : offset+r.End])
+	}
+	// position returns the offset in source of an offset in the content; an end offset at the start of a range
+	// is the end of the previous range
+	position := func(o int, end bool) int {
+		i := sort.Search(len(starts), func(i int) bool {
+			return starts[i] > o || end && starts[i] == o
+		}) - 1
+		if i < 0 {
+			i = 0
+		}
+		return offset + ranges[i].Start + o - starts[i]
+	}
+	span := func(s Span) Span {
+		if s.Start < 0 {
+			return s
 		}
+		return Span{Start: position(s.Start, false), End: position(s.End, true)}
+	}
 
-		lastCapture = inspectionSource[:newBuilder.matchEnd]
+	quoteOptions := *options
+	quoteOptions.MergeText = false
+	quoteOptions.MaxNodes = 0
+	if quoteOptions.MaxDepth > 0 {
+		quoteOptions.MaxDepth -= depth
 	}
+	quoteMode := parseMode{strict: mode.strict, quote: true}
+	if mode.events != nil {
+		// skip the root of the content, the block quote being its parent
+		level := 0
+		quoteMode.events = func(n Node, entering bool) {
+			if entering {
+				level++
+			} else {
+				level--
+			}
+			if entering && level == 1 || !entering && level == 0 {
+				return
+			}
+			if entering {
+				n.setSpan(span(n.Span()))
+			}
+			mode.events(n, entering)
+		}
+	}
+	if mode.tokens != nil {
+		quoteMode.tokens = func(t Token) {
+			t.Span = span(t.Span)
+			for i := range t.Groups {
+				t.Groups[i] = span(t.Groups[i])
+			}
+			t.Depth += depth
+			mode.tokens(t)
+		}
+	}
+	root, diagnostics, err := parse(ctx, sb.String(), &quoteOptions, rules, quoteMode)
+	if err != nil && (mode.strict || root == nil) {
+		return 0, nil, err
+	}
+	for i := range diagnostics {
+		diagnostics[i].Offset = position(diagnostics[i].Offset, false)
+	}
+	nodes := 0
+	if mode.events == nil {
+		Walk(root, func(n Node, entering bool) {
+			if entering && n != root {
+				n.setSpan(span(n.Span()))
+				nodes++
+			}
+		})
+		spec.node.SetChildren(root.Children())
+	}
+	return nodes, diagnostics, nil
+}
 
-	return topLevelRootNode
+// textRoot returns a root node containing the whole source as a single TextNode.
+func textRoot(source string) Node {
+	span := Span{Start: 0, End: len(source)}
+	root := &node{span: span}
+	root.self = root
+	root.AppendChild(&TextNode{node: node{span: span}, Content: source})
+	return root
 }
 
 /*
@@ -748,16 +2085,144 @@ type Walker func(n Node, entering bool)
 
 /*
 Walk walks the passed AST represented by its root Node, with a Walker function.
-The walk algorithm parses the tree in a depth-first manner.
+The walk algorithm parses the tree in a depth-first manner. It is not recursive, so that deeply nested trees
+can be walked safely.
 
 The Walker function is called on entering and leaving each node.
 */
 func Walk(n Node, w Walker) {
-	w(n, true)
-	for _, child := range n.Children() {
-		Walk(child, w)
+	walk(n, func(n Node, entering bool) WalkStatus {
+		w(n, entering)
+		return WalkContinue
+	})
+}
+
+// walk walks the tree iteratively with an explicit stack rather than recursively,
+// so that deeply nested trees cannot overflow the goroutine stack.
+func walk(n Node, w StatusWalker) WalkStatus {
+	type frame struct {
+		node Node
+		// next is the index of the next child to walk, or -1 if the node was not entered yet.
+		next int
 	}
-	w(n, false)
+	stack := []frame{{node: n, next: -1}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		children := f.node.Children()
+		if f.next == -1 {
+			switch w(f.node, true) {
+			case WalkStop:
+				return WalkStop
+			case WalkSkipChildren:
+				f.next = len(children)
+			default:
+				f.next = 0
+			}
+		}
+		if f.next < len(children) {
+			f.next++
+			stack = append(stack, frame{node: children[f.next-1], next: -1})
+			continue
+		}
+		node := f.node
+		stack = stack[:len(stack)-1]
+		if w(node, false) == WalkStop {
+			return WalkStop
+		}
+	}
+	return WalkContinue
+}
+
+/*
+Event is a step of a walk of an AST: entering or leaving a Node.
+*/
+type Event struct {
+	Node     Node
+	Entering bool
+}
+
+/*
+Flatten returns the list of events of a walk of the passed AST, in the order of Walk.
+
+This can be used to process trees with simple loops, rather than with a Walker.
+*/
+func Flatten(n Node) []Event {
+	var events []Event
+	Walk(n, func(n Node, entering bool) {
+		events = append(events, Event{Node: n, Entering: entering})
+	})
+	return events
+}
+
+/*
+Fold walks the passed AST like Walk, computing an accumulated value: fn is called on entering and leaving each node
+with the current value, and returns the next value. The final value is returned.
+
+For example, the number of nodes of a tree can be computed with:
+
+	count := Fold(root, func(count int, n Node, entering bool) int {
+		if entering {
+			count++
+		}
+		return count
+	}, 0)
+*/
+func Fold[T any](n Node, fn func(acc T, n Node, entering bool) T, init T) T {
+	acc := init
+	Walk(n, func(n Node, entering bool) {
+		acc = fn(acc, n, entering)
+	})
+	return acc
+}
+
+/*
+ErrWalker is the visiting callback used by WalkErr.
+*/
+type ErrWalker func(n Node, entering bool) error
+
+/*
+WalkErr walks the passed AST like Walk, with an ErrWalker function. If the ErrWalker returns an error,
+the walk is stopped immediately and the error is returned.
+*/
+func WalkErr(n Node, w ErrWalker) error {
+	var err error
+	walk(n, func(n Node, entering bool) WalkStatus {
+		if err = w(n, entering); err != nil {
+			return WalkStop
+		}
+		return WalkContinue
+	})
+	return err
+}
+
+/*
+WalkStatus is the status returned by a StatusWalker, controlling how WalkWithStatus continues.
+*/
+type WalkStatus int
+
+const (
+	// WalkContinue continues the walk normally.
+	WalkContinue WalkStatus = iota
+	// WalkSkipChildren skips the children of the node, when returned on entering it.
+	// The StatusWalker is still called on leaving the node.
+	WalkSkipChildren
+	// WalkStop stops the walk immediately: the StatusWalker is not called anymore, including on leaving the current nodes.
+	WalkStop
+)
+
+/*
+StatusWalker is the visiting callback used by WalkWithStatus.
+*/
+type StatusWalker func(n Node, entering bool) WalkStatus
+
+/*
+WalkWithStatus walks the passed AST like Walk, with a StatusWalker function that controls the walk with its returned WalkStatus:
+it can skip the children of a node, or stop the walk early.
+
+WalkStop is returned if the walk was stopped, and WalkContinue otherwise.
+*/
+func WalkWithStatus(n Node, w StatusWalker) WalkStatus {
+	return walk(n, w)
 }
 
 /*
@@ -788,8 +2253,19 @@ func Debug(n Node) string {
 				sb.WriteString(fmt.Sprintf("spoiler"))
 			case *URLNode:
 				sb.WriteString(fmt.Sprintf("url %q %q", n.Mask, n.URL))
+				if n.Title != "" {
+					sb.WriteString(fmt.Sprintf(" title %q", n.Title))
+				}
+				if n.Invite != "" {
+					sb.WriteString(fmt.Sprintf(" invite %q", n.Invite))
+				}
+				if n.Suppressed {
+					sb.WriteString(" suppressed")
+				}
 			case *EmojiNode:
 				sb.WriteString(fmt.Sprintf("emoji %v %q %q", n.Animated, n.Text, n.ID))
+			case *NamedEmojiNode:
+				sb.WriteString(fmt.Sprintf("namedemoji %q %d", n.Name, n.SkinTone))
 			case *ChannelMentionNode:
 				sb.WriteString(fmt.Sprintf("channelmention %q", n.ID))
 			case *RoleMentionNode:
@@ -800,6 +2276,8 @@ func Debug(n Node) string {
 				sb.WriteString(fmt.Sprintf("specialmention %q", n.Mention))
 			case *TimestampNode:
 				sb.WriteString(fmt.Sprintf("timestamp %q %q", n.Stamp, n.Format))
+			case *UnknownTagNode:
+				sb.WriteString(fmt.Sprintf("unknowntag %q %q", n.Name, n.Content))
 			case *HeaderNode:
 				sb.WriteString(fmt.Sprintf("header %d", n.Level))
 			case *BulletListNode:
@@ -812,6 +2290,12 @@ func Debug(n Node) string {
 				sb.WriteString(fmt.Sprintf("italics"))
 			case *StrikethroughNode:
 				sb.WriteString(fmt.Sprintf("strikethrough"))
+			case *HighlightNode:
+				sb.WriteString(fmt.Sprintf("highlight %q %q", n.Class, n.Color))
+			case *LineBreakNode:
+				sb.WriteString("linebreak")
+			case *ParagraphNode:
+				sb.WriteString("paragraph")
 			case *node:
 				noSpace = true
 			default:
@@ -1,16 +1,23 @@
 package formatting
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"testing"
 )
 
 func test(t *testing.T, text string, want string) {
 	got := Debug(NewParser(&ParserOptions{
-		EnableBlockQuote:    true,
-		EnableMaskedLinks:   true,
-		EnableMentions:      true,
-		EnableForumMarkdown: true,
+		EnableBlockQuote:            true,
+		EnableMaskedLinks:           true,
+		EnableMentions:              true,
+		EnableForumMarkdown:         true,
+		LiteralIntrawordUnderscores: true,
+		SafeMaskedLinks:             true,
+		MaskedLinkSchemes:           DiscordMaskedLinkSchemes,
+		NormalizeNewlines:           true,
 	}).Parse(text))
 	if got != want {
 		t.Errorf("error parsing %q: want %q, got %q", text, want, got)
@@ -19,6 +26,31 @@ func test(t *testing.T, text string, want string) {
 
 func TestFormatting(t *testing.T) {
 	test(t, ">>> hi", `[[blockquote [text "hi"]]]`)
+	test(t, ">>>  hi", `[[blockquote [text " hi"]]]`)
+	test(t, "> a\n > *b*\n>  c\nd", `[[blockquote [text "a"] [text "\n"] [italics [text "b"]] [text "\n c"] [text "\n"]] [text "d"]]`)
+	test(t, "> **a\n> b**", `[[blockquote [bold [text "a"] [text "\nb"]]]]`)
+	test(t, "> a\n\n> b", `[[blockquote [text "a"] [text "\n"]] [text "\n"] [blockquote [text "b"]]]`)
+	test(t, "> > a\n> >>> b", `[[blockquote [text "> a"] [text "\n"] [text ">"] [text ">"] [text "> b"]]]`)
+	test(t, ">>> a\n> b\n>>> c", `[[blockquote [text "a"] [text "\n"] [text "> b"] [text "\n"] [text ">"] [text ">"] [text "> c"]]]`)
+	test(t, "a > b", `[[text "a "] [text "> b"]]`)
+	test(t, "a >>> b", `[[text "a "] [text ">"] [text ">"] [text "> b"]]`)
+	test(t, "a  \n  > b", `[[text "a"] [text "  "] [text "\n  "] [blockquote [text "b"]]]`)
+	test(t, "**> a** > b", `[[bold [text "> a"]] [text " "] [text "> b"]]`)
+	test(t, "# # # a", `[[header 1 [text "# "] [text "# a"]]]`)
+	test(t, "a **> b**", `[[text "a "] [bold [text "> b"]]]`)
+	test(t, "> a\n>>> b\n> c", `[[blockquote [text "a"] [text "\n"]] [blockquote [text "b"] [text "\n"] [text "> c"]]]`)
+	test(t, "[a](https://b.c)", `[[url "a" "https://b.c"]]`)
+	test(t, "[https://a.b](https://c.d)", `[[text "["] [url "" "https://a.b](https://c.d"] [text ")"]]`)
+	test(t, "[see http://a.b](https://c.d)", `[[text "[see "] [url "" "http://a.b](https://c.d"] [text ")"]]`)
+	test(t, "[ ](https://c.d)", `[[text "[ "] [text "]"] [text "("] [url "" "https://c.d"] [text ")"]]`)
+	test(t, "[http](https://c.d)", `[[url "http" "https://c.d"]]`)
+	test(t, "[a](<https://b.c>)", `[[url "a" "https://b.c" suppressed]]`)
+	test(t, "[a](<https://b.c)", `[[url "a" "https://b.c"]]`)
+	test(t, "[a](HTTPS://User@B.C/D?E)", `[[url "a" "https://User@b.c/D?E"]]`)
+	test(t, "[a](discord://-/channels/1/2)", `[[url "a" "discord://-/channels/1/2"]]`)
+	test(t, "[a](javascript:alert(1))", `[[text "[a"] [text "]"] [text "("] [text "j"] [text "a"] [text "v"] [text "a"] [text "s"] [text "c"] [text "r"] [text "i"] [text "p"] [text "t"] [text ":alert"] [text "(1"] [text ")"] [text ")"]]`)
+	test(t, "[a](b.c)", `[[text "[a"] [text "]"] [text "(b"] [text ".c"] [text ")"]]`)
+	test(t, "[a](https:b)", `[[text "[a"] [text "]"] [text "("] [text "h"] [text "t"] [text "t"] [text "p"] [text "s"] [text ":b"] [text ")"]]`)
 	test(t, "<#1234>", `[[channelmention "1234"]]`)
 	test(t, "<@&1234>", `[[rolemention "1234"]]`)
 	test(t, "<@!1234>", `[[usermention "1234"]]`)
@@ -31,15 +63,44 @@ func TestFormatting(t *testing.T) {
 	test(t, `<t:1234567890:t>`, `[[timestamp "1234567890" "t"]]`)
 	test(t, `https://example.com`, `[[url "" "https://example.com"]]`)
 	test(t, `[example](https://example.com)`, `[[url "example" "https://example.com"]]`)
-	test(t, `<https://example.com>`, `[[url "" "https://example.com"]]`)
+	test(t, `<https://example.com>`, `[[url "" "https://example.com" suppressed]]`)
+	test(t, `https://discord.gg/abc-DEF`, `[[url "" "https://discord.gg/abc-DEF" invite "abc-DEF"]]`)
+	test(t, `<https://discord.com/invite/abc?event=1>`, `[[url "" "https://discord.com/invite/abc?event=1" invite "abc" suppressed]]`)
+	test(t, `[join](https://discordapp.com/invite/abc/)`, `[[url "join" "https://discordapp.com/invite/abc/" invite "abc"]]`)
+	test(t, `https://discord.com/channels/1/2`, `[[url "" "https://discord.com/channels/1/2"]]`)
+	test(t, "mail me@example.com!", `[[text "mail "] [url "me@example.com" "mailto:me@example.com"] [text "!"]]`)
+	test(t, "me@example", `[[text "me"] [text "@example"]]`)
+	test(t, "hi@everyone", `[[text "hi"] [specialmention "everyone"]]`)
 	test(t, "\u00AD", `[[text ""]]`)
 	test(t, "||flushed||", `[[spoiler [text "flushed"]]]`)
 	test(t, "- list", `[[list 1 false [text "list"]]]`)
+	test(t, "- a\n  b\n\t**c**\nd", `[[list 1 true [text "a"] [text "\n"] [text "b"] [text "\n"] [bold [text "c"]]] [text "d"]]`)
+	test(t, "- a\n  - b\n    c\n- d", `[[list 1 true [text "a"]] [list 2 true [text "b"] [text "\n"] [text "c"]] [list 1 false [text "d"]]]`)
+	test(t, "- a\n  # b", `[[list 1 false [text "a"] [text "\n"] [text "# b"]]]`)
+	test(t, "- a\n\n  b", `[[list 1 true [text "a"]] [text "\n"] [text "  b"]]`)
 	test(t, "### header", `[[header 3 [text "header"]]]`)
+	test(t, "#### header", `[[text "#"] [text "#"] [text "#"] [text "# header"]]`)
+	test(t, "#\theader", `[[text "#\theader"]]`)
+	test(t, "#header", `[[text "#header"]]`)
+	test(t, "> - item", `[[blockquote [list 1 false [text "item"]]]]`)
+	test(t, "> a\n> - b\n>   * c\n> d", `[[blockquote [text "a"] [text "\n"] [list 1 true [text "b"]] [list 2 true [text "c"]] [text "d"]]]`)
+	test(t, ">>> # a\n- b", `[[blockquote [header 1 [text "a"]] [text "\n"] [list 1 false [text "b"]]]]`)
+	test(t, "> # title", `[[blockquote [header 1 [text "title"]]]]`)
+	test(t, "> - a\n> # b", `[[blockquote [list 1 true [text "a"]] [header 1 [text "b"]]]]`)
+	test(t, "- a\n# b", `[[list 1 true [text "a"]] [header 1 [text "b"]]]`)
+	test(t, "> a\n## b", `[[blockquote [text "a"] [text "\n"]] [header 2 [text "b"]]]`)
+	test(t, "```a```\n# b", `[[code "" "a"] [text "\n"] [header 1 [text "b"]]]`)
+	test(t, "```a```# b", `[[code "" "a"] [text "# b"]]`)
+	test(t, "# \nfoo", `[[header 1] [text "\nfoo"]]`)
 	test(t, "**bold**", `[[bold [text "bold"]]]`)
 	test(t, "*hi*", `[[italics [text "hi"]]]`)
 	test(t, "_hi_", `[[italics [text "hi"]]]`)
 	test(t, "__hi__", `[[underline [text "hi"]]]`)
+	test(t, "snake_case_name", `[[text "snake"] [text "_case"] [text "_name"]]`)
+	test(t, "file_name_", `[[text "file"] [text "_name"] [text "_"]]`)
+	test(t, "é_hi_é", `[[text "é"] [text "_hi"] [text "_é"]]`)
+	test(t, "(_hi_)", `[[text "("] [italics [text "hi"]] [text ")"]]`)
+	test(t, "__init__", `[[underline [text "init"]]]`)
 	test(t, "~~hi~~", `[[strikethrough [text "hi"]]]`)
 	test(t, "\n \n", `[[text "\n"]]`)
 	test(t, "hi", `[[text "hi"]]`)
@@ -48,6 +109,977 @@ func TestFormatting(t *testing.T) {
 	test(t, "```sx\nhello\n```", `[[code "sx" "hello"]]`)
 }
 
+func TestCodeInline(t *testing.T) {
+	p := NewParser(nil)
+	for text, inline := range map[string]bool{
+		"`hello`":           true,
+		"``hello``":         true,
+		"```hello```":       false,
+		"```go\nhello\n```": false,
+	} {
+		code := p.Parse(text).Children()[0].(*CodeNode)
+		if code.Inline != inline {
+			t.Errorf("error parsing %q: want inline %v, got %v", text, inline, code.Inline)
+		}
+	}
+}
+
+func TestNormalizeCodeLanguages(t *testing.T) {
+	p := NewParser(&ParserOptions{
+		NormalizeCodeLanguages: true,
+	})
+	code := p.Parse("```JS\nlet a;\n```").Children()[0].(*CodeNode)
+	if code.Language != "javascript" || code.RawLanguage != "JS" {
+		t.Errorf("want language %q and raw language %q, got %q and %q", "javascript", "JS", code.Language, code.RawLanguage)
+	}
+	code = NewParser(nil).Parse("```JS\nlet a;\n```").Children()[0].(*CodeNode)
+	if code.Language != "JS" || code.RawLanguage != "JS" {
+		t.Errorf("want language %q and raw language %q, got %q and %q", "JS", "JS", code.Language, code.RawLanguage)
+	}
+	for language, want := range map[string]string{
+		"py":     "python",
+		"sh":     "bash",
+		"golang": "go",
+		"rust":   "rust",
+		"":       "",
+	} {
+		if got := NormalizeLanguage(language); got != want {
+			t.Errorf("error normalizing %q: want %q, got %q", language, want, got)
+		}
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	p := NewParser(nil)
+	if got, want := Debug(p.Parse("[a](https://example.com)")), `[[text "[a"] [text "]"] [text "("] [url "" "https://example.com"] [text ")"]]`; got != want {
+		t.Errorf("error parsing with parser options: want %q, got %q", want, got)
+	}
+	if got, want := Debug(p.ParseWith("[a](https://example.com)", &ParserOptions{EnableMaskedLinks: true})), `[[url "a" "https://example.com"]]`; got != want {
+		t.Errorf("error parsing with call options: want %q, got %q", want, got)
+	}
+	if got, want := Debug(p.ParseWith("<@1234>", nil)), `[[usermention "1234"]]`; got != want {
+		t.Errorf("error parsing with nil options: want %q, got %q", want, got)
+	}
+}
+
+func TestHeaderMarker(t *testing.T) {
+	root := NewParser(&MessageParserOptions).Parse("  ## a")
+	header, ok := root.Children()[0].(*HeaderNode)
+	if !ok {
+		t.Fatalf("error parsing header: got %s", Debug(root))
+	}
+	if want := "  ## "; header.Marker != want {
+		t.Errorf("error parsing header marker: want %q, got %q", want, header.Marker)
+	}
+	if got, want := RenderMarkdown(root, nil), "  ## a"; got != want {
+		t.Errorf("error serializing header: want %q, got %q", want, got)
+	}
+}
+
+func TestForumMarkdown(t *testing.T) {
+	p := NewParser(&ParserOptions{EnableHeaders: true})
+	if got, want := Debug(p.Parse("# a\n- b")), `[[header 1 [text "a"]] [text "\n"] [text "- b"]]`; got != want {
+		t.Errorf("error parsing with headers: want %q, got %q", want, got)
+	}
+	p = NewParser(&ParserOptions{EnableLists: true})
+	if got, want := Debug(p.Parse("# a\n- b")), `[[text "# a"] [text "\n"] [list 1 false [text "b"]]]`; got != want {
+		t.Errorf("error parsing with lists: want %q, got %q", want, got)
+	}
+	p = NewParser(&ParserOptions{EnableForumMarkdown: true})
+	if got, want := Debug(p.Parse("# a\n- b")), `[[header 1 [text "a"]] [text "\n"] [list 1 false [text "b"]]]`; got != want {
+		t.Errorf("error parsing with forum markdown: want %q, got %q", want, got)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	legacy := func(options ParserOptions) *ParserOptions {
+		options.BehaviorVersion = BehaviorLegacy
+		return &options
+	}
+	for _, c := range []struct {
+		options *ParserOptions
+		want    string
+		text    string
+	}{
+		{&MessageParserOptions, `[[blockquote [url "a" "https://example.com"] [text " "] [usermention "1234"] [text "\n"]] [header 1 [text "b"]]]`, ""},
+		{&EmbedDescriptionParserOptions, `[[blockquote [url "a" "https://example.com"] [text " "] [usermention "1234"] [text "\n"]] [header 1 [text "b"]]]`, ""},
+		{&ForumPostParserOptions, `[[blockquote [url "a" "https://example.com"] [text " "] [usermention "1234"] [text "\n"]] [header 1 [text "b"]]]`, ""},
+		{legacy(MessageParserOptions), `[[blockquote [text "[a"] [text "]"] [text "("] [url "" "https://example.com"] [text ") "] [usermention "1234"] [text "\n"]] [text "# b"]]`, ""},
+		{legacy(ForumPostParserOptions), `[[blockquote [text "[a"] [text "]"] [text "("] [url "" "https://example.com"] [text ") "] [usermention "1234"] [text "\n"]] [header 1 [text "b"]]]`, ""},
+		{legacy(EmbedDescriptionParserOptions), `[[blockquote [url "a" "https://example.com"] [text " "] [usermention "1234"] [text "\n"]] [text "# b"]]`, ""},
+		{&EmbedDescriptionParserOptions, `[[url "a" "https://example.com" title "tip"]]`, `[a](https://example.com "tip")`},
+		{&MessageParserOptions, `[[url "a" "https://example.com"]]`, `[a](https://example.com "tip")`},
+		{&ForumPostParserOptions, `[[url "a" "https://example.com"]]`, `[a](https://example.com "tip")`},
+		{&EmbedTitleParserOptions, `[[text "> "] [text "[a"] [text "]"] [text "("] [text "h"] [text "t"] [text "t"] [text "p"] [text "s"] [text ":"] [text "/"] [text "/example"] [text ".com"] [text ") "] [text "<"] [text "@1234"] [text ">"] [text "\n"] [text "# b"]]`, ""},
+		{&BioParserOptions, `[[blockquote [text "[a"] [text "]"] [text "("] [url "" "https://example.com"] [text ") "] [text "<"] [text "@1234"] [text ">"] [text "\n"]] [text "# b"]]`, ""},
+	} {
+		text := c.text
+		if text == "" {
+			text = "> [a](https://example.com) <@1234>\n# b"
+		}
+		if got := Debug(NewParser(c.options).Parse(text)); got != c.want {
+			t.Errorf("error parsing %q: want %q, got %q", text, c.want, got)
+		}
+	}
+}
+
+func TestInlineOnly(t *testing.T) {
+	p := NewParser(&ParserOptions{
+		EnableBlockQuote: true,
+		EnableMentions:   true,
+		EnableHeaders:    true,
+		EnableLists:      true,
+		InlineOnly:       true,
+	})
+	for text, want := range map[string]string{
+		"> a":            `[[text "> a"]]`,
+		"# a":            `[[text "# a"]]`,
+		"- a":            `[[text "- a"]]`,
+		"```go\ncode```": `[[code "go" "code"]]`,
+		"**a** <@1234>":  `[[bold [text "a"]] [text " "] [usermention "1234"]]`,
+	} {
+		if got := Debug(p.Parse(text)); got != want {
+			t.Errorf("error parsing %q: want %q, got %q", text, want, got)
+		}
+	}
+	if code := p.Parse("```go\ncode```").Children()[0].(*CodeNode); !code.Inline {
+		t.Errorf("want code block parsed as inline code")
+	}
+}
+
+func TestDisableTimestamps(t *testing.T) {
+	options := DefaultParserOptions
+	options.DisableTimestamps = true
+	if got, want := Debug(NewParser(&options).Parse("<t:1234567890:R>")), `[[text "<"] [text "t"] [text ":1234567890:"] [text "R"] [text ">"]]`; got != want {
+		t.Errorf("error parsing without timestamps: want %q, got %q", want, got)
+	}
+}
+
+func TestDisableCustomEmoji(t *testing.T) {
+	options := DefaultParserOptions
+	options.DisableCustomEmoji = true
+	if got, want := Debug(NewParser(&options).Parse("<a:wave:1234>")), `[[text "<"] [text "a"] [text ":wave:"] [text "1234"] [text ">"]]`; got != want {
+		t.Errorf("error parsing without custom emoji: want %q, got %q", want, got)
+	}
+}
+
+func TestDisableEscapes(t *testing.T) {
+	options := DefaultParserOptions
+	options.DisableEscapes = true
+	if got, want := Debug(NewParser(&options).Parse(`\**a**`)), `[[text "\\"] [bold [text "a"]]]`; got != want {
+		t.Errorf("error parsing without escapes: want %q, got %q", want, got)
+	}
+}
+
+func TestDisableURLs(t *testing.T) {
+	options := MessageParserOptions
+	options.DisableURLs = true
+	ast := NewParser(&options).Parse("[a](https://example.com) <https://example.com> a@example.com")
+	Walk(ast, func(n Node, entering bool) {
+		if _, ok := n.(*URLNode); ok {
+			t.Errorf("want no URLNode without URLs, got one in %s", Debug(ast))
+		}
+	})
+}
+
+func TestIntrawordUnderscores(t *testing.T) {
+	options := DefaultParserOptions
+	options.LiteralIntrawordUnderscores = false
+	if got, want := Debug(NewParser(&options).Parse("file_name_")), `[[text "file"] [italics [text "name"]]]`; got != want {
+		t.Errorf("error parsing without literal intraword underscores: want %q, got %q", want, got)
+	}
+}
+
+func TestBehaviorVersion(t *testing.T) {
+	text := "# a\n- b\n[c](https://example.com)"
+	options := DefaultParserOptions
+	if got, want := Debug(NewParser(&options).Parse(text)), `[[text "# a"] [text "\n"] [text "- b"] [text "\n"] [text "[c"] [text "]"] [text "("] [url "" "https://example.com"] [text ")"]]`; got != want {
+		t.Errorf("error parsing with legacy behavior: want %q, got %q", want, got)
+	}
+	options.BehaviorVersion = Behavior2023
+	if got, want := Debug(NewParser(&options).Parse(text)), `[[header 1 [text "a"]] [text "\n"] [list 1 true [text "b"]] [url "c" "https://example.com"]]`; got != want {
+		t.Errorf("error parsing with 2023 behavior: want %q, got %q", want, got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	names := RuleNames()
+	if len(names) != len(parserRules) || names[0] != RuleSoftHyphen || names[len(names)-1] != RuleText {
+		t.Errorf("unexpected rule names: %v", names)
+	}
+
+	options := DefaultParserOptions
+	options.DisabledRules = []string{RuleSpoiler, RuleText, "unknown"}
+	if got, want := Debug(NewParser(&options).Parse("||a||")), `[[text "|"] [text "|a"] [text "|"] [text "|"]]`; got != want {
+		t.Errorf("error parsing with disabled rules: want %q, got %q", want, got)
+	}
+
+	options = DefaultParserOptions
+	options.RuleOrder = []string{RuleText, "unknown", RuleEmoticon, RuleText}
+	if got, want := Debug(NewParser(&options).Parse(`¯\_(ツ)_/¯`)), `[[text "¯"] [text "_"] [text "(ツ"] [text ")"] [text "_"] [text "/"] [text "¯"]]`; got != want {
+		t.Errorf("error parsing with rule order: want %q, got %q", want, got)
+	}
+
+	if RulePattern(RuleBold) != patternBold.String() || RulePattern("unknown") != "" {
+		t.Errorf("unexpected rule patterns")
+	}
+	options = DefaultParserOptions
+	options.Matchers = map[string]Matcher{
+		// bold with ++ instead of **
+		RuleBold: regexp.MustCompile(`^(\+\+([\s\S]+?)\+\+)(?:[^+]|$)`),
+	}
+	if got, want := Debug(NewParser(&options).Parse("++a++ **b**")), `[[bold [text "a"]] [text " "] [text "*"] [text "*b"] [text "*"] [text "*"]]`; got != want {
+		t.Errorf("error parsing with matchers: want %q, got %q", want, got)
+	}
+}
+
+func TestUnknownTags(t *testing.T) {
+	options := DefaultParserOptions
+	options.EnableUnknownTags = true
+	p := NewParser(&options)
+	for text, want := range map[string]string{
+		"<id:customize>":        `[[unknowntag "id" "customize"]]`,
+		"a <x:y:z> b":           `[[text "a "] [unknowntag "x" "y:z"] [text " b"]]`,
+		"<t:1234567890:R>":      `[[timestamp "1234567890" "R"]]`,
+		"<https://example.com>": `[[url "" "https://example.com" suppressed]]`,
+		"<:emoji:1234>":         `[[emoji false "emoji" "1234"]]`,
+		"<a:b\nc>":              `[[text "<"] [text "a"] [text ":b"] [text "\nc"] [text ">"]]`,
+	} {
+		if got := Debug(p.Parse(text)); got != want {
+			t.Errorf("error parsing %q with unknown tags: want %q, got %q", text, want, got)
+		}
+	}
+	if n := p.Parse("<x:y:z>").Children()[0].(*UnknownTagNode); n.Raw != "<x:y:z>" {
+		t.Errorf("want raw %q, got %q", "<x:y:z>", n.Raw)
+	}
+}
+
+func TestLineBreaks(t *testing.T) {
+	options := DefaultParserOptions
+	options.EnableLineBreaks = true
+	p := NewParser(&options)
+	for text, want := range map[string]string{
+		"a  \nb":      `[[text "a"] [linebreak] [text "b"]]`,
+		"a\\\nb":      `[[text "a"] [linebreak] [text "b"]]`,
+		"a \nb":       `[[text "a "] [text "\nb"]]`,
+		"a  b":        `[[text "a  b"]]`,
+		"**a**   \nb": `[[bold [text "a"]] [linebreak] [text "b"]]`,
+		"> a  \n> b":  `[[blockquote [text "a"] [linebreak] [text "b"]]]`,
+	} {
+		if got := Debug(p.Parse(text)); got != want {
+			t.Errorf("error parsing %q with line breaks: want %q, got %q", text, want, got)
+		}
+	}
+	if got, want := Debug(NewParser(nil).Parse("a  \nb")), `[[text "a"] [text "  "] [text "\nb"]]`; got != want {
+		t.Errorf("error parsing without line breaks: want %q, got %q", want, got)
+	}
+
+	root := p.Parse("a  \nb\nc")
+	if got, want := RenderHTML(root, nil), "a<br>b<br>c"; got != want {
+		t.Errorf("error rendering line breaks to HTML: want %q, got %q", want, got)
+	}
+	if got := RenderMarkdown(root, nil); !Equal(root, p.Parse(got)) {
+		t.Errorf("error serializing line breaks: got %q", got)
+	}
+}
+
+func TestNamedEmoji(t *testing.T) {
+	options := DefaultParserOptions
+	options.EnableNamedEmoji = true
+	p := NewParser(&options)
+	for text, want := range map[string]string{
+		":smile:":                    `[[namedemoji "smile" 0]]`,
+		"a :+1::skin-tone-3: b":      `[[text "a "] [namedemoji "+1" 3] [text " b"]]`,
+		":a::skin-tone-7:":           `[[namedemoji "a::skin-tone-7" 0]]`,
+		"**:x:** :y: z:":             `[[bold [namedemoji "x" 0]] [text " "] [namedemoji "y" 0] [text " z"] [text ":"]]`,
+		": a :":                      `[[text ": a "] [text ":"]]`,
+		"`:x:`":                      `[[code "" ":x:"]]`,
+		"<:x:1234> :x:":              `[[emoji false "x" "1234"] [text " "] [namedemoji "x" 0]]`,
+		":thumbsup::skin-tone-5::x:": `[[namedemoji "thumbsup" 5] [namedemoji "x" 0]]`,
+	} {
+		root := p.Parse(text)
+		if got := Debug(root); got != want {
+			t.Errorf("error parsing %q with named emoji: want %q, got %q", text, want, got)
+		}
+		if got := RenderMarkdown(root, nil); !Equal(markdownRoot(root, true), markdownRoot(p.Parse(got), true)) {
+			t.Errorf("error serializing %q with named emoji: got %q", text, got)
+		}
+	}
+	if got, want := Debug(NewParser(nil).Parse(":smile:")), `[[text ":smile:"]]`; got != want {
+		t.Errorf("error parsing without named emoji: want %q, got %q", want, got)
+	}
+	if got, want := Text(p.Parse("a :+1::skin-tone-3:")), "a :+1::skin-tone-3:"; got != want {
+		t.Errorf("error extracting named emoji text: want %q, got %q", want, got)
+	}
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	options := DefaultParserOptions
+	options.EnableHeaders = true
+	options.EnableLists = true
+	p := NewParser(&options)
+	for text, want := range map[string]string{
+		"a\r\n> b":        `[[text "a"] [text "\n"] [blockquote [text "b"]]]`,
+		"a\r> b":          `[[text "a"] [text "\n"] [blockquote [text "b"]]]`,
+		"> a\r\n> b\r\nc": `[[blockquote [text "a"] [text "\nb"] [text "\n"]] [text "c"]]`,
+		"- a\r\n- b":      `[[list 1 true [text "a"]] [list 1 false [text "b"]]]`,
+		"# a\r\nb":        `[[header 1 [text "a"]] [text "\nb"]]`,
+		"```\r\na\r\n```": `[[code "" "a"]]`,
+	} {
+		if got := Debug(p.Parse(text)); got != want {
+			t.Errorf("error parsing %q with normalized newlines: want %q, got %q", text, want, got)
+		}
+	}
+
+	text := "a\r\n**b**\r\rc"
+	var got []string
+	Walk(p.Parse(text), func(n Node, entering bool) {
+		if entering {
+			got = append(got, text[n.Span().Start:n.Span().End])
+		}
+	})
+	want := []string{text, "a", "\r\n", "**b**", "b", "\r", "\r", "c"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error parsing spans with normalized newlines: want %q, got %q", want, got)
+	}
+	got = nil
+	for _, token := range p.Tokens(text) {
+		got = append(got, text[token.Span.Start:token.Span.End])
+	}
+	want = want[1:]
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error parsing tokens with normalized newlines: want %q, got %q", want, got)
+	}
+
+	options.NormalizeNewlines = false
+	if got, want := Debug(NewParser(&options).Parse("a\r\n> b")), `[[text "a\r"] [text "\n"] [blockquote [text "b"]]]`; got != want {
+		t.Errorf("error parsing without normalized newlines: want %q, got %q", want, got)
+	}
+}
+
+func TestPreserveSoftHyphens(t *testing.T) {
+	options := DefaultParserOptions
+	options.PreserveSoftHyphens = true
+	p := NewParser(&options)
+	text := "a\u00ADb **c\u00AD**"
+	root := p.Parse(text)
+	if got, want := Debug(root), `[[text "a"] [text "\u00ad"] [text "b "] [bold [text "c"] [text "\u00ad"]]]`; got != want {
+		t.Errorf("error parsing soft hyphens: want %q, got %q", want, got)
+	}
+	if got := RenderMarkdown(root, &MarkdownOptions{MinimalEscaping: true, Parser: &options}); got != text {
+		t.Errorf("error serializing soft hyphens: want %q, got %q", text, got)
+	}
+}
+
+func TestStripInvisibleCharacters(t *testing.T) {
+	options := DefaultParserOptions
+	options.StripInvisibleCharacters = true
+	p := NewParser(&options)
+	for text, want := range map[string]string{
+		"a\u200bb":              `[[text "a"] [text ""] [text "b"]]`,
+		"\u200b\u200ca":         `[[text ""] [text "a"]]`,
+		"@every\u2060one":       `[[text "@every"] [text ""] [text "one"]]`,
+		"\u202eab\u202c":        `[[text ""] [text "ab"] [text ""]]`,
+		"**a\u200e**":           `[[bold [text "a"] [text ""]]]`,
+		"a\u200db":              `[[text "a\u200db"]]`,
+		"<@\u200b1234>":         `[[text "<"] [text "@"] [text ""] [text "1234"] [text ">"]]`,
+		"\u2066a\u2069 \ufeffb": `[[text ""] [text "a"] [text ""] [text " "] [text ""] [text "b"]]`,
+	} {
+		if got := Debug(p.Parse(text)); got != want {
+			t.Errorf("error parsing %q with stripped invisible characters: want %q, got %q", text, want, got)
+		}
+	}
+	if got, want := Debug(NewParser(nil).Parse("a\u200bb")), `[[text "a\u200bb"]]`; got != want {
+		t.Errorf("error parsing without stripped invisible characters: want %q, got %q", want, got)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	if n, err := NewParser(nil).ParseStrict("**a**"); err != nil || Debug(n) != `[[bold [text "a"]]]` {
+		t.Errorf("error parsing strictly: got %s (%v)", Debug(n), err)
+	}
+
+	var bold []rule
+	for _, r := range parserRules {
+		if r.name == RuleBold {
+			bold = append(bold, r)
+		}
+	}
+	if _, _, err := parse(context.Background(), "**a**", &DefaultParserOptions, newRuleSet(bold), parseMode{strict: true}); err == nil {
+		t.Errorf("want error for unmatched source, got none")
+	}
+	if n, _, err := parse(context.Background(), "**a**", &DefaultParserOptions, newRuleSet(bold), parseMode{}); err != nil || Debug(n) != `[[bold [text "a"]]]` {
+		t.Errorf("error parsing unmatched source: got %s (%v)", Debug(n), err)
+	}
+
+	panicking := []rule{{
+		pattern: patternText,
+		parser: func(match match) parseSpec {
+			panic("boom")
+		},
+	}}
+	if _, _, err := parse(context.Background(), "a", &DefaultParserOptions, newRuleSet(panicking), parseMode{strict: true}); err == nil {
+		t.Errorf("want error for panicking rule, got none")
+	}
+	if n, _, err := parse(context.Background(), "a", &DefaultParserOptions, newRuleSet(panicking), parseMode{}); Debug(n) != `[[text "a"]]` {
+		t.Errorf("error parsing with panicking rule: got %s (%v)", Debug(n), err)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	if n, diagnostics := NewParser(nil).ParseDiagnostics("**a**"); len(diagnostics) != 0 || Debug(n) != `[[bold [text "a"]]]` {
+		t.Errorf("error parsing with diagnostics: got %s %v", Debug(n), diagnostics)
+	}
+
+	var bold []rule
+	for _, r := range parserRules {
+		if r.name == RuleBold {
+			bold = append(bold, r)
+		}
+	}
+	p := &Parser{options: DefaultParserOptions, rules: newRuleSet(bold)}
+	n, diagnostics := p.ParseDiagnostics("a **b** c")
+	if got, want := Debug(n), `[[text "a **b** c"]]`; got != want {
+		t.Errorf("error parsing with diagnostics: want %q, got %q", want, got)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Offset != 0 {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+	n, diagnostics = p.ParseDiagnostics("**a** b **c**")
+	if got, want := Debug(n), `[[bold [text "a"]] [text " b **c**"]]`; got != want {
+		t.Errorf("error parsing with diagnostics: want %q, got %q", want, got)
+	}
+	if len(diagnostics) != 2 || diagnostics[0].Offset != 2 || diagnostics[1].Offset != 5 {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, c := range []struct {
+		limits ParserOptions
+		text   string
+		want   string
+	}{
+		{ParserOptions{MaxLength: 4}, "**a**", `[[text "**a**"]]`},
+		{ParserOptions{MaxLength: 5}, "**a**", `[[bold [text "a"]]]`},
+		{ParserOptions{MaxNodes: 3}, "a **b** c", `[[text "a **b** c"]]`},
+		{ParserOptions{MaxNodes: 4}, "a **b** c", `[[text "a "] [bold [text "b"]] [text " c"]]`},
+		{ParserOptions{MaxDepth: 2}, "**_a_** b", `[[bold [text "_a_"]] [text " b"]]`},
+		{ParserOptions{MaxDepth: 1}, "**_a_** b", `[[text "**_a_**"] [text " b"]]`},
+	} {
+		options := DefaultParserOptions
+		options.MaxLength = c.limits.MaxLength
+		options.MaxNodes = c.limits.MaxNodes
+		options.MaxDepth = c.limits.MaxDepth
+		p := NewParser(&options)
+		n, diagnostics := p.ParseDiagnostics(c.text)
+		if got := Debug(n); got != c.want {
+			t.Errorf("error parsing %q with limits: want %q, got %q", c.text, c.want, got)
+		}
+		if _, err := p.ParseStrict(c.text); (err != nil) != (len(diagnostics) > 0) {
+			t.Errorf("error parsing %q strictly with limits: got error %v, diagnostics %v", c.text, err, diagnostics)
+		}
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	p := NewParser(nil)
+	if n, err := p.ParseContext(context.Background(), "**a**"); err != nil || Debug(n) != `[[bold [text "a"]]]` {
+		t.Errorf("error parsing with context: got %s (%v)", Debug(n), err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.ParseContext(ctx, "**a**"); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context canceled error, got %v", err)
+	}
+}
+
+func TestSpan(t *testing.T) {
+	text := "a **b** <@1234>\n> c"
+	n := NewParser(nil).Parse(text)
+	var got []string
+	Walk(n, func(n Node, entering bool) {
+		if entering {
+			got = append(got, text[n.Span().Start:n.Span().End])
+		}
+	})
+	want := []string{text, "a ", "**b**", "b", " ", "<@1234>", "\n", "> c", "c"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error parsing spans: want %q, got %q", want, got)
+	}
+	if span := (&TextNode{}).Span(); span.Len() != 0 {
+		t.Errorf("want empty span for created node, got %v", span)
+	}
+}
+
+func TestDelimiters(t *testing.T) {
+	p := NewParser(&MessageParserOptions)
+	for text, want := range map[string]string{
+		"*a*":        "*",
+		"_a_":        "_",
+		"`a`":        "`",
+		"``a``":      "``",
+		"```a```":    "```",
+		"> a":        ">",
+		">>> a\nb":   ">>>",
+		"- a":        "-",
+		"* a":        "*",
+		"  * a\n- b": "*",
+	} {
+		var got string
+		switch n := p.Parse(text).Children()[0].(type) {
+		case *ItalicsNode:
+			got = n.Delimiter
+		case *CodeNode:
+			got = n.Delimiter
+		case *BlockQuoteNode:
+			got = n.Delimiter
+		case *BulletListNode:
+			got = n.Delimiter
+		}
+		if got != want {
+			t.Errorf("error parsing delimiter of %q: want %q, got %q", text, want, got)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	n := NewParser(&MessageParserOptions).Parse("**a** <@1234>")
+	var got []NodeKind
+	Walk(n, func(n Node, entering bool) {
+		if entering {
+			got = append(got, n.Kind())
+		}
+	})
+	want := []NodeKind{KindRoot, KindBold, KindText, KindText, KindUserMention}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error getting kinds: want %v, got %v", want, got)
+	}
+	for k := KindRoot; k <= KindHighlight; k++ {
+		if kk, ok := ParseNodeKind(k.String()); !ok || kk != k {
+			t.Errorf("error parsing kind %v: got %v", k, kk)
+		}
+	}
+	if _, ok := ParseNodeKind("invalid"); ok {
+		t.Errorf("want no kind for invalid name")
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	root := NewParser(nil).Parse("a **b** ||`c`||")
+	bold := root.Children()[1].(*BoldNode)
+	if bold.Parent() != root || root.Parent() != nil {
+		t.Errorf("unexpected parents: %v, %v", bold.Parent(), root.Parent())
+	}
+	if bold.PrevSibling() != root.Children()[0] || bold.NextSibling() != root.Children()[2] {
+		t.Errorf("unexpected siblings of %s", Debug(bold))
+	}
+	if root.Children()[0].PrevSibling() != nil || root.Children()[3].NextSibling() != nil {
+		t.Errorf("want no siblings at the ends of %s", Debug(root))
+	}
+	code := root.Children()[3].Children()[0]
+	if _, ok := code.Parent().(*SpoilerNode); !ok {
+		t.Errorf("want code inside spoiler, got %v", code.Parent())
+	}
+
+	built := &BoldNode{node: node{children: []Node{&TextNode{Content: "a"}, &TextNode{Content: "b"}}}}
+	Index(built)
+	if built.Children()[1].Parent() != built || built.Children()[0].NextSibling() != built.Children()[1] {
+		t.Errorf("unexpected navigation after indexing %s", Debug(built))
+	}
+}
+
+func TestMutation(t *testing.T) {
+	root := NewParser(nil).Parse("a **b** c")
+	bold := root.Children()[1]
+	spoiler := &SpoilerNode{}
+	spoiler.SetChildren(bold.Children())
+	if !root.ReplaceChild(bold, spoiler) || bold.Parent() != nil {
+		t.Errorf("error replacing child in %s", Debug(root))
+	}
+	if root.ReplaceChild(bold, spoiler) {
+		t.Errorf("want no replacement of a removed child")
+	}
+	root.InsertChildAt(0, &TextNode{Content: "0"})
+	root.AppendChild(&UserMentionNode{ID: "1234"})
+	if !root.RemoveChild(root.Children()[1]) || root.RemoveChild(bold) {
+		t.Errorf("error removing child in %s", Debug(root))
+	}
+	if got, want := Debug(root), `[[text "0"] [spoiler [text "b"]] [text " c"] [usermention "1234"]]`; got != want {
+		t.Errorf("error mutating tree: want %q, got %q", want, got)
+	}
+	for i, c := range root.Children() {
+		if c.Parent() != root || (i > 0 && c.PrevSibling() != root.Children()[i-1]) {
+			t.Errorf("unexpected navigation of child %d of %s", i, Debug(root))
+		}
+	}
+	if spoiler.Children()[0].Parent() != spoiler {
+		t.Errorf("unexpected parent of %s", Debug(spoiler))
+	}
+}
+
+func TestClone(t *testing.T) {
+	root := NewParser(&MessageParserOptions).Parse("a **b** [c](https://example.com) ```go\nd```")
+	c := Clone(root)
+	if Debug(c) != Debug(root) {
+		t.Errorf("error cloning: want %s, got %s", Debug(root), Debug(c))
+	}
+	if c.Children()[1].Parent() != c || c.Children()[1].Span() != root.Children()[1].Span() {
+		t.Errorf("unexpected links of clone %s", Debug(c))
+	}
+	c.Children()[1].Children()[0].(*TextNode).Content = "e"
+	c.RemoveChild(c.Children()[0])
+	if got, want := Debug(root), `[[text "a "] [bold [text "b"]] [text " "] [url "c" "https://example.com"] [text " "] [code "go" "d"]]`; got != want {
+		t.Errorf("error cloning: original modified: want %q, got %q", want, got)
+	}
+	if Clone(nil) != nil {
+		t.Errorf("want nil clone of nil")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	p := NewParser(nil)
+	if !Equal(p.Parse("*a* **b**"), p.Parse("_a_ **b**")) {
+		t.Errorf("want equal trees with different delimiters")
+	}
+	if Equal(p.Parse("**a**"), p.Parse("**b**")) || Equal(p.Parse("**a**"), p.Parse("__a__")) || Equal(p.Parse("a"), p.Parse("a a")) {
+		t.Errorf("want different trees")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	p := NewParser(nil)
+	format := func(changes []Change) string {
+		var s []string
+		for _, c := range changes {
+			old, new := "", ""
+			if c.Old != nil {
+				old = Debug(c.Old)
+			}
+			if c.New != nil {
+				new = Debug(c.New)
+			}
+			s = append(s, fmt.Sprintf("%v %v %s %s", c.Type, c.Path, old, new))
+		}
+		return fmt.Sprint(s)
+	}
+	for _, c := range []struct {
+		old  string
+		new  string
+		want string
+	}{
+		{"a **b**", "a **b**", `[]`},
+		{"a **b**", "a **c**", `[modified [1 0] [text "b"] [text "c"]]`},
+		{"a **b**", "a **b** <@1234>", `[added [2]  [text " "] added [3]  [usermention "1234"]]`},
+		{"<@1234> a", "a", `[modified [0] [usermention "1234"] [text "a"] removed [1] [text " a"] ]`},
+		{"**a** b", "__a__ b", `[modified [0] [bold [text "a"]] [underline [text "a"]]]`},
+	} {
+		if got := format(Diff(p.Parse(c.old), p.Parse(c.new))); got != c.want {
+			t.Errorf("error diffing %q and %q: want %q, got %q", c.old, c.new, c.want, got)
+		}
+	}
+
+	// past the table size bound, the unmatched children are paired by position
+	old, new := NewRoot(), NewRoot()
+	for i := 0; i < 1000; i++ {
+		old.AppendChild(NewText(fmt.Sprint(i)))
+		new.AppendChild(NewText(fmt.Sprint(i)))
+	}
+	for i := 10; i < 990; i++ {
+		new.Children()[i].(*TextNode).Content = "x"
+	}
+	new.AppendChild(NewText("end"))
+	changes := Diff(old, new)
+	if len(changes) != 981 || changes[0].Type != ChangeModified || changes[0].Path[0] != 10 || changes[980].Type != ChangeAdded || changes[980].Path[0] != 1000 {
+		t.Errorf("error diffing large lists: got %d changes, first %v, last %v", len(changes), changes[0], changes[len(changes)-1])
+	}
+}
+
+func TestAttr(t *testing.T) {
+	n := NewParser(nil).Parse("<@1234>").Children()[0]
+	if _, ok := n.Attr("test.name"); ok {
+		t.Errorf("want no attribute on parsed node")
+	}
+	n.SetAttr("test.name", "alice")
+	c := Clone(n)
+	n.SetAttr("test.name", nil)
+	if _, ok := n.Attr("test.name"); ok {
+		t.Errorf("want attribute removed")
+	}
+	if v, ok := c.Attr("test.name"); !ok || v != "alice" {
+		t.Errorf("want cloned attribute %q, got %v", "alice", v)
+	}
+}
+
+func TestMergeText(t *testing.T) {
+	text := `\*hi\* **a. b, c** d`
+	options := DefaultParserOptions
+	options.MergeText = true
+	root := NewParser(&options).Parse(text)
+	if got, want := Debug(root), `[[text "*hi* "] [bold [text "a. b, c"]] [text " d"]]`; got != want {
+		t.Errorf("error merging text: want %q, got %q", want, got)
+	}
+	if span := root.Children()[0].Span(); text[span.Start:span.End] != `\*hi\* ` {
+		t.Errorf("unexpected span of merged text: %v", span)
+	}
+	if root.Children()[1].PrevSibling() != root.Children()[0] {
+		t.Errorf("unexpected siblings after merging text")
+	}
+
+	root = NewParser(nil).Parse("a, b")
+	root.Children()[1].SetAttr("test.keep", true)
+	MergeText(root)
+	if got, want := Debug(root), `[[text "a"] [text ", b"]]`; got != want {
+		t.Errorf("error merging text with attributes: want %q, got %q", want, got)
+	}
+}
+
+func TestWalkWithStatus(t *testing.T) {
+	root := NewParser(nil).Parse("a ||b|| <@1234> <@5678>")
+	var got []string
+	status := WalkWithStatus(root, func(n Node, entering bool) WalkStatus {
+		got = append(got, fmt.Sprint(n.Kind(), entering))
+		switch n.(type) {
+		case *SpoilerNode:
+			return WalkSkipChildren
+		case *UserMentionNode:
+			return WalkStop
+		}
+		return WalkContinue
+	})
+	want := []string{"root true", "text true", "text false", "spoiler true", "spoiler false", "text true", "text false", "usermention true"}
+	if status != WalkStop || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error walking with status: want %q, got %q (%v)", want, got, status)
+	}
+}
+
+func TestWalkDeep(t *testing.T) {
+	const depth = 1000000
+	var root Node = &TextNode{Content: "a"}
+	for i := 0; i < depth; i++ {
+		root = &BoldNode{node: node{children: []Node{root}}}
+	}
+	entered, left := 0, 0
+	Walk(root, func(n Node, entering bool) {
+		if entering {
+			entered++
+		} else {
+			left++
+		}
+	})
+	if entered != depth+1 || left != depth+1 {
+		t.Errorf("error walking deep tree: entered %d, left %d nodes", entered, left)
+	}
+	if got := Debug(root); len(got) != depth*len("[bold ]")+len(`[text "a"]`) {
+		t.Errorf("error printing deep tree: got length %d", len(got))
+	}
+
+	Index(root)
+	clone := Clone(root)
+	if !Equal(root, clone) {
+		t.Errorf("error cloning deep tree: trees differ")
+	}
+	clone = Pipeline{MergeTextPass, RemoveEmptyTextPass, UnwrapPass(KindSpoiler), ReplacePass(func(n Node) Node {
+		return nil
+	})}.Apply(clone)
+	if changes := Diff(root, clone); len(changes) != 0 {
+		t.Errorf("error diffing deep tree: want no changes, got %d", len(changes))
+	}
+	clone, err := UnmarshalProto(MarshalProto(root))
+	if err != nil || !Equal(root, clone) {
+		t.Errorf("error unmarshaling deep tree: %v", err)
+	}
+	if got := Debug(ParagraphPass(clone)); len(got) != depth*len("[bold ]")+len(`[paragraph [text "a"]]`) {
+		t.Errorf("error grouping paragraphs of deep tree: got length %d", len(got))
+	}
+	if got := Debug(StripFormattingPass(clone)); got != `[bold [text "a"]]` {
+		t.Errorf("error stripping deep tree: got %s", got)
+	}
+}
+
+// mentionVisitor collects user mentions, and stops at the first code node.
+type mentionVisitor struct {
+	BaseVisitor
+	ids   []string
+	other int
+}
+
+func (v *mentionVisitor) VisitUserMention(n *UserMentionNode, entering bool) WalkStatus {
+	if entering {
+		v.ids = append(v.ids, n.ID)
+	}
+	return WalkContinue
+}
+
+func (v *mentionVisitor) VisitCode(n *CodeNode, entering bool) WalkStatus {
+	return WalkStop
+}
+
+func (v *mentionVisitor) VisitOther(n Node, entering bool) WalkStatus {
+	v.other++
+	return WalkContinue
+}
+
+func TestVisit(t *testing.T) {
+	v := &mentionVisitor{}
+	status := Visit(NewParser(nil).Parse("<@1> **<@2>** `<@3>` <@4>"), v)
+	if status != WalkStop || fmt.Sprint(v.ids) != "[1 2]" || v.other != 1 {
+		t.Errorf("error visiting: got %v, %d other (%v)", v.ids, v.other, status)
+	}
+}
+
+func TestFold(t *testing.T) {
+	root := NewParser(nil).Parse("a **b** ||c||")
+	count := Fold(root, func(count int, n Node, entering bool) int {
+		if entering {
+			count++
+		}
+		return count
+	}, 0)
+	text := Fold(root, func(text string, n Node, entering bool) string {
+		if n, ok := n.(*TextNode); ok && entering {
+			text += n.Content
+		}
+		return text
+	}, "")
+	if count != 7 || text != "a b c" {
+		t.Errorf("error folding: got count %d, text %q", count, text)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	root := NewParser(nil).Parse("a\u00AD ||b **c** <@1>|| d")
+	root = Pipeline{
+		RemoveEmptyTextPass,
+		UnwrapPass(KindSpoiler, KindBold),
+		FilterPass(func(n Node) bool {
+			return n.Kind() != KindUserMention
+		}),
+		MergeTextPass,
+	}.Apply(root)
+	if got, want := Debug(root), `[[text "a b c  d"]]`; got != want {
+		t.Errorf("error applying pipeline: want %q, got %q", want, got)
+	}
+	if root.Children()[0].Parent() != root {
+		t.Errorf("unexpected parent after applying pipeline")
+	}
+}
+
+func TestRedactSpoilersPass(t *testing.T) {
+	root := RedactSpoilersPass("[spoiler]")(NewParser(nil).Parse("a ||b **c**|| d"))
+	if got, want := Debug(root), `[[text "a "] [spoiler [text "[spoiler]"]] [text " d"]]`; got != want {
+		t.Errorf("error redacting spoilers: want %q, got %q", want, got)
+	}
+	root = RedactSpoilersPass("")(NewParser(nil).Parse("||b **c** <@1>||"))
+	if got, want := Debug(root), `[[spoiler [text "██████"]]]`; got != want {
+		t.Errorf("error redacting spoilers: want %q, got %q", want, got)
+	}
+}
+
+func TestStripFormattingPass(t *testing.T) {
+	root := StripFormattingPass(NewParser(&MessageParserOptions).Parse("# a\n> **b `c`** <@1>\n- d\n- ||e||"))
+	if got, want := Debug(root), `[[text "a"] [text "\n"] [text "b "] [code "" "c"] [text " "] [usermention "1"] [text "\n"] [text "d"] [text "\n"] [text "e"]]`; got != want {
+		t.Errorf("error stripping formatting: want %q, got %q", want, got)
+	}
+}
+
+func TestParagraphPass(t *testing.T) {
+	p := NewParser(&MessageParserOptions)
+	for text, want := range map[string]string{
+		"a\n\nb":                `[[paragraph [text "a"]] [text "\n\n"] [paragraph [text "b"]]]`,
+		"a **b**\nc\n \nd":      `[[paragraph [text "a "] [bold [text "b"]] [text "\nc"]] [text "\n \n"] [paragraph [text "d"]]]`,
+		"# a\nb\n- c\nd":        `[[header 1 [text "a"]] [text "\n"] [paragraph [text "b"]] [text "\n"] [list 1 true [text "c"]] [paragraph [text "d"]]]`,
+		">>> a\n\nb":            `[[blockquote [paragraph [text "a"]] [text "\n\n"] [paragraph [text "b"]]]]`,
+		"a\n```\nb\n```\n\n`c`": `[[paragraph [text "a"]] [text "\n"] [code "" "b"] [text "\n\n"] [paragraph [code "" "c"]]]`,
+	} {
+		root := p.Parse(text)
+		markdown := RenderMarkdown(root, nil)
+		root = ParagraphPass(root)
+		if got := Debug(root); got != want {
+			t.Errorf("error grouping paragraphs of %q: want %q, got %q", text, want, got)
+		}
+		if got := RenderMarkdown(root, nil); got != markdown {
+			t.Errorf("error serializing paragraphs of %q: want %q, got %q", text, markdown, got)
+		}
+	}
+
+	root := ParagraphPass(p.Parse("# a\nb **c**\n\nd"))
+	if got, want := RenderHTML(root, nil), "<h1>a</h1><p>b <strong>c</strong></p><p>d</p>"; got != want {
+		t.Errorf("error rendering paragraphs to HTML: want %q, got %q", want, got)
+	}
+	if span := root.Children()[2].Span(); span != (Span{Start: 4, End: 11}) {
+		t.Errorf("error grouping paragraphs: want span 4-11, got %v", span)
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	p := NewParser(&MessageParserOptions)
+	format := func(n Node, entering bool) string {
+		if !entering {
+			return "leave " + n.Kind().String()
+		}
+		if n, ok := n.(*TextNode); ok {
+			return fmt.Sprintf("text %q", n.Content)
+		}
+		return "enter " + n.Kind().String()
+	}
+	for _, text := range []string{
+		"",
+		"a **b *c*** d",
+		"> a ||b||\n# c\n- d `e`",
+		"# \nfoo",
+		"```go\na\n``` <@1234> [a](https://example.com)",
+	} {
+		var want, got []string
+		Walk(p.Parse(text), func(n Node, entering bool) {
+			want = append(want, format(n, entering))
+		})
+		p.ParseEvents(text, func(n Node, entering bool) {
+			got = append(got, format(n, entering))
+		})
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("error parsing events of %q: want %q, got %q", text, want, got)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	text := "a **b** <@1234>"
+	var got []string
+	for _, token := range NewParser(nil).Tokens(text) {
+		var groups []string
+		for _, g := range token.Groups {
+			if g.Start >= 0 {
+				groups = append(groups, text[g.Start:g.End])
+			} else {
+				groups = append(groups, "-")
+			}
+		}
+		got = append(got, fmt.Sprintf("%s %d %q %q", token.Rule, token.Depth, text[token.Span.Start:token.Span.End], groups))
+	}
+	want := []string{
+		`text 0 "a " ["a *" "a "]`,
+		`bold 0 "**b**" ["**b** " "**b**" "b"]`,
+		`text 1 "b" ["b" "b"]`,
+		`text 0 " " [" <" " "]`,
+		`usermention 0 "<@1234>" ["<@1234>" "1234"]`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error parsing tokens: want %q, got %q", want, got)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	var got []string
+	for _, e := range Flatten(NewParser(nil).Parse("a **b**")) {
+		got = append(got, fmt.Sprint(e.Node.Kind(), e.Entering))
+	}
+	want := []string{"root true", "text true", "text false", "bold true", "text true", "text false", "bold false", "root false"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error flattening: want %q, got %q", want, got)
+	}
+}
+
 func TestSimple(t *testing.T) {
 	p := NewParser(nil)
 	ast := p.Parse("*hi\u00ADmom__underline__* ~~strike~~ \\~~strike~~! `my code` \n```shell\nmy epic code\nyes\n```")
@@ -0,0 +1,258 @@
+package goldmarkfmt
+
+import (
+	"strconv"
+	"strings"
+
+	formatting "github.com/delthas/discord-formatting"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+/*
+ParseMarkdown parses a CommonMark document, with the strikethrough extension, and converts it with FromGoldmark.
+*/
+func ParseMarkdown(source []byte) formatting.Node {
+	md := goldmark.New(goldmark.WithExtensions(extension.Strikethrough))
+	return FromGoldmark(md.Parser().Parse(text.NewReader(source)), source)
+}
+
+/*
+FromGoldmark converts a goldmark document, parsed from source, to a message AST, for example to send content
+authored in standard Markdown to Discord by serializing the AST with formatting.RenderMarkdown.
+
+Constructs that Discord does not support are degraded:
+  - paragraphs are separated by an empty line, and soft line breaks are converted to spaces
+  - headers deeper than 3 levels are converted to bold text
+  - block quotes are converted to one single-line block quote per line, and nested block quotes are flattened
+  - ordered list items are converted to bullet list items starting with their number
+  - images are converted to links, with their alternative text as their Mask
+  - thematic breaks are converted to a line of dashes, and raw HTML is removed
+
+The custom nodes of this package, as returned by ToGoldmark, are converted back to their formatting node.
+*/
+func FromGoldmark(n ast.Node, source []byte) formatting.Node {
+	c := &fromConverter{source: source}
+	return formatting.NewRoot(c.blocks(n, 0)...)
+}
+
+type fromConverter struct {
+	source []byte
+}
+
+// blocks converts the block children of n; level is the nesting level of lists.
+func (c *fromConverter) blocks(n ast.Node, level int) []formatting.Node {
+	var nodes []formatting.Node
+	var prev ast.Node
+	for b := n.FirstChild(); b != nil; b = b.NextSibling() {
+		block := c.block(b, level)
+		if len(block) == 0 {
+			continue
+		}
+		if prev != nil && !endsWithNewline(nodes[len(nodes)-1]) {
+			nodes = append(nodes, formatting.NewText("\n"))
+		}
+		if prev != nil && prev.Kind() == ast.KindParagraph && b.Kind() == ast.KindParagraph {
+			nodes = append(nodes, formatting.NewText("\n"))
+		}
+		nodes = append(nodes, block...)
+		prev = b
+	}
+	return nodes
+}
+
+func endsWithNewline(n formatting.Node) bool {
+	switch n := n.(type) {
+	case *formatting.TextNode:
+		return strings.HasSuffix(n.Content, "\n")
+	case *formatting.BulletListNode:
+		return n.IncludesNewline
+	case *formatting.BlockQuoteNode:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *fromConverter) block(n ast.Node, level int) []formatting.Node {
+	switch n := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return c.inlines(n)
+	case *ast.Heading:
+		if n.Level > 3 {
+			return []formatting.Node{formatting.NewBold(c.inlines(n)...)}
+		}
+		return []formatting.Node{formatting.NewHeader(n.Level, c.inlines(n)...)}
+	case *ast.ThematicBreak:
+		return []formatting.Node{formatting.NewText("───")}
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		var language string
+		if f, ok := n.(*ast.FencedCodeBlock); ok {
+			language = string(f.Language(c.source))
+		}
+		content := strings.TrimSuffix(string(n.Lines().Value(c.source)), "\n")
+		return []formatting.Node{formatting.NewCodeBlock(language, content)}
+	case *ast.Blockquote:
+		return quoteLines(c.blocks(n, level))
+	case *ast.List:
+		var nodes []formatting.Node
+		number := n.Start
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			var content, nested []formatting.Node
+			if n.IsOrdered() {
+				content = append(content, formatting.NewText(strconv.Itoa(number)+". "))
+				number++
+			}
+			for b := item.FirstChild(); b != nil; b = b.NextSibling() {
+				if _, ok := b.(*ast.List); ok {
+					nested = append(nested, c.block(b, level+1)...)
+					continue
+				}
+				if len(content) > 0 && b.PreviousSibling() != nil {
+					content = append(content, formatting.NewText(" "))
+				}
+				content = append(content, c.block(b, level+1)...)
+			}
+			li := formatting.NewListItem(content...)
+			li.NestedLevel = level + 1
+			if n.Marker == '*' {
+				li.Delimiter = "*"
+			}
+			nodes = append(nodes, li)
+			nodes = append(nodes, nested...)
+		}
+		return nodes
+	default:
+		// raw HTML blocks, and unknown blocks
+		return nil
+	}
+}
+
+// quoteLines splits nodes into lines, each wrapped in a single-line block quote.
+func quoteLines(nodes []formatting.Node) []formatting.Node {
+	var quotes []formatting.Node
+	var line []formatting.Node
+	end := func() {
+		quote := formatting.NewBlockQuote(line...)
+		quote.Delimiter = ">"
+		quotes = append(quotes, quote)
+		line = nil
+	}
+	var split func(nodes []formatting.Node)
+	split = func(nodes []formatting.Node) {
+		for _, n := range nodes {
+			switch n := n.(type) {
+			case *formatting.BlockQuoteNode:
+				split(n.Children())
+			case *formatting.TextNode:
+				lines := strings.Split(n.Content, "\n")
+				for i, l := range lines {
+					if l != "" {
+						line = append(line, formatting.NewText(l))
+					}
+					if i < len(lines)-1 {
+						line = append(line, formatting.NewText("\n"))
+						end()
+					}
+				}
+			case *formatting.BulletListNode:
+				line = append(line, n)
+				end()
+			default:
+				line = append(line, n)
+			}
+		}
+	}
+	split(nodes)
+	if len(line) > 0 {
+		line = append(line, formatting.NewText("\n"))
+		end()
+	}
+	return quotes
+}
+
+func (c *fromConverter) inlines(n ast.Node) []formatting.Node {
+	var nodes []formatting.Node
+	for i := n.FirstChild(); i != nil; i = i.NextSibling() {
+		nodes = append(nodes, c.inline(i)...)
+	}
+	return nodes
+}
+
+// plain returns the text content of the inline children of n.
+func (c *fromConverter) plain(n ast.Node) string {
+	var sb strings.Builder
+	for i := n.FirstChild(); i != nil; i = i.NextSibling() {
+		switch i := i.(type) {
+		case *ast.Text:
+			sb.Write(i.Segment.Value(c.source))
+			if i.SoftLineBreak() || i.HardLineBreak() {
+				sb.WriteString(" ")
+			}
+		case *ast.String:
+			sb.Write(i.Value)
+		default:
+			sb.WriteString(c.plain(i))
+		}
+	}
+	return sb.String()
+}
+
+func (c *fromConverter) inline(n ast.Node) []formatting.Node {
+	var node formatting.Node
+	switch n := n.(type) {
+	case *ast.Text:
+		content := string(n.Segment.Value(c.source))
+		if n.HardLineBreak() {
+			content += "\n"
+		} else if n.SoftLineBreak() {
+			content += " "
+		}
+		return []formatting.Node{formatting.NewText(content)}
+	case *ast.String:
+		return []formatting.Node{formatting.NewText(string(n.Value))}
+	case *ast.CodeSpan:
+		return []formatting.Node{formatting.NewCode(c.plain(n))}
+	case *ast.Link:
+		link := formatting.NewMaskedLink(c.plain(n), string(n.Destination))
+		link.Title = string(n.Title)
+		return []formatting.Node{link}
+	case *ast.Image:
+		return []formatting.Node{formatting.NewMaskedLink(c.plain(n), string(n.Destination))}
+	case *ast.AutoLink:
+		url := string(n.URL(c.source))
+		if n.AutoLinkType == ast.AutoLinkEmail {
+			return []formatting.Node{formatting.NewMaskedLink(url, "mailto:"+url)}
+		}
+		return []formatting.Node{formatting.NewURL(url)}
+	case *ast.RawHTML:
+		return nil
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			node = formatting.NewBold()
+		} else {
+			node = formatting.NewItalics()
+		}
+	case *east.Strikethrough:
+		node = formatting.NewStrikethrough()
+	case *Underline:
+		node = formatting.NewUnderline()
+	case *Spoiler:
+		node = formatting.NewSpoiler()
+	case *Mention:
+		return []formatting.Node{formatting.Clone(n.Node)}
+	case *Emoji:
+		return []formatting.Node{formatting.Clone(n.Node)}
+	case *Timestamp:
+		return []formatting.Node{formatting.Clone(n.Node)}
+	default:
+		return c.inlines(n)
+	}
+	for _, child := range c.inlines(n) {
+		node.AppendChild(child)
+	}
+	return []formatting.Node{node}
+}
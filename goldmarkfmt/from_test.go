@@ -0,0 +1,42 @@
+package goldmarkfmt
+
+import (
+	"testing"
+
+	formatting "github.com/delthas/discord-formatting"
+)
+
+func TestFromGoldmark(t *testing.T) {
+	for text, want := range map[string]string{
+		"hello *world*":   "[[text \"hello \"] [italics [text \"world\"]]]",
+		"a\nb  \nc":       "[[text \"a \"] [text \"b\\n\"] [text \"c\"]]",
+		"a\n\nb":          "[[text \"a\"] [text \"\\n\"] [text \"\\n\"] [text \"b\"]]",
+		"# t\n#### deep":  "[[header 1 [text \"t\"]] [text \"\\n\"] [bold [text \"deep\"]]]",
+		"- a\n  - b\n- c": "[[list 1 true [text \"a\"]] [list 2 true [text \"b\"]] [list 1 true [text \"c\"]]]",
+		"3. a\n4. b":      "[[list 1 true [text \"3. \"] [text \"a\"]] [list 1 true [text \"4. \"] [text \"b\"]]]",
+		"> a\n> b\n\nc":   "[[blockquote [text \"a \"] [text \"b\"] [text \"\\n\"]] [text \"c\"]]",
+		"```go\nx\n```":   "[[code \"go\" \"x\"]]",
+		"[m](https://e.com \"t\") ![i](https://e.com/i.png) <https://e.com>": "[[url \"m\" \"https://e.com\" title \"t\"] [text \" \"] [url \"i\" \"https://e.com/i.png\"] [text \" \"] [url \"\" \"https://e.com\"]]",
+		"~~s~~ `c` <b>x</b>": "[[strikethrough [text \"s\"]] [text \" \"] [code \"\" \"c\"] [text \" \"] [text \"x\"]]",
+		"***\n":              "[[text \"───\"]]",
+	} {
+		if got := formatting.Debug(ParseMarkdown([]byte(text))); got != want {
+			t.Errorf("error converting %q: want %s, got %s", text, want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	parser := formatting.NewParser(&formatting.MessageParserOptions)
+	for _, text := range []string{
+		"hello **world** __u__ ||s|| <@1234> <:e:1234> <t:1234:R>",
+	} {
+		root := parser.Parse(text)
+		got := FromGoldmark(ToGoldmark(root))
+		formatting.MergeText(root)
+		formatting.MergeText(got)
+		if !formatting.Equal(root, got) {
+			t.Errorf("error round-tripping %q: want %s, got %s", text, formatting.Debug(root), formatting.Debug(got))
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/delthas/discord-formatting/goldmarkfmt
+
+go 1.19
+
+require (
+	github.com/delthas/discord-formatting v0.0.0-20261016151629-267fe1d31ab5
+	github.com/yuin/goldmark v1.7.8
+)
+
+replace github.com/delthas/discord-formatting => ../
@@ -0,0 +1,126 @@
+/*
+Package goldmarkfmt converts between formatting message ASTs and goldmark ASTs, so that Discord messages can be processed
+and rendered with goldmark and its extensions, and so that standard Markdown can be sent to Discord.
+
+It is a separate module, so that the formatting package does not depend on goldmark.
+
+ToGoldmark converts a message AST to a goldmark document. Discord-specific nodes, such as mentions, are converted
+to the custom nodes of this package, which are rendered to HTML by Extension. FromGoldmark converts a goldmark
+document to a message AST, degrading the constructs that Discord does not support.
+*/
+package goldmarkfmt
+
+import (
+	formatting "github.com/delthas/discord-formatting"
+	"github.com/yuin/goldmark/ast"
+)
+
+// KindUnderline is the NodeKind of Underline nodes.
+var KindUnderline = ast.NewNodeKind("DiscordUnderline")
+
+/*
+Underline is an inline goldmark node containing underlined content, converted from a formatting.UnderlineNode.
+*/
+type Underline struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Underline) Kind() ast.NodeKind {
+	return KindUnderline
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Underline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindSpoiler is the NodeKind of Spoiler nodes.
+var KindSpoiler = ast.NewNodeKind("DiscordSpoiler")
+
+/*
+Spoiler is an inline goldmark node containing a spoiler, converted from a formatting.SpoilerNode.
+*/
+type Spoiler struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Spoiler) Kind() ast.NodeKind {
+	return KindSpoiler
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Spoiler) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindMention is the NodeKind of Mention nodes.
+var KindMention = ast.NewNodeKind("DiscordMention")
+
+/*
+Mention is an inline goldmark leaf node containing a mention, converted from a formatting.UserMentionNode,
+formatting.RoleMentionNode, formatting.ChannelMentionNode or formatting.SpecialMentionNode, stored as Node.
+*/
+type Mention struct {
+	ast.BaseInline
+	Node formatting.Node
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Mention) Kind() ast.NodeKind {
+	return KindMention
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Mention) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Node": formatting.Debug(n.Node),
+	}, nil)
+}
+
+// KindEmoji is the NodeKind of Emoji nodes.
+var KindEmoji = ast.NewNodeKind("DiscordEmoji")
+
+/*
+Emoji is an inline goldmark leaf node containing a custom emoji, converted from a formatting.EmojiNode.
+*/
+type Emoji struct {
+	ast.BaseInline
+	Node *formatting.EmojiNode
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Emoji) Kind() ast.NodeKind {
+	return KindEmoji
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Emoji) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Node": formatting.Debug(n.Node),
+	}, nil)
+}
+
+// KindTimestamp is the NodeKind of Timestamp nodes.
+var KindTimestamp = ast.NewNodeKind("DiscordTimestamp")
+
+/*
+Timestamp is an inline goldmark leaf node containing a timestamp, converted from a formatting.TimestampNode.
+*/
+type Timestamp struct {
+	ast.BaseInline
+	Node *formatting.TimestampNode
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Timestamp) Kind() ast.NodeKind {
+	return KindTimestamp
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Timestamp) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Node": formatting.Debug(n.Node),
+	}, nil)
+}
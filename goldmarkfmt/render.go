@@ -0,0 +1,73 @@
+package goldmarkfmt
+
+import (
+	formatting "github.com/delthas/discord-formatting"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+/*
+Extension is a goldmark extension rendering the custom nodes of this package to HTML, as well as strikethrough nodes.
+Leaf nodes, such as mentions, are rendered with formatting.RenderHTML and the RenderOptions of the extension.
+*/
+var Extension goldmark.Extender = &HTMLExtension{}
+
+/*
+HTMLExtension is a goldmark extension rendering the custom nodes of this package to HTML.
+Its RenderOptions are passed to formatting.RenderHTML for rendering leaf nodes, and can be nil.
+*/
+type HTMLExtension struct {
+	RenderOptions *formatting.RenderOptions
+}
+
+// Extend implements goldmark.Extender.Extend.
+func (e *HTMLExtension) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&htmlRenderer{options: e.RenderOptions}, 500),
+		util.Prioritized(extension.NewStrikethroughHTMLRenderer(), 500),
+	))
+}
+
+type htmlRenderer struct {
+	options *formatting.RenderOptions
+}
+
+func (r *htmlRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindUnderline, r.renderTag("u", ""))
+	reg.Register(KindSpoiler, r.renderTag("span", "spoiler"))
+	reg.Register(KindMention, r.renderLeaf)
+	reg.Register(KindEmoji, r.renderLeaf)
+	reg.Register(KindTimestamp, r.renderLeaf)
+}
+
+func (r *htmlRenderer) renderTag(tag string, class string) renderer.NodeRendererFunc {
+	return func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			_, _ = w.WriteString("</" + tag + ">")
+		} else if class != "" {
+			_, _ = w.WriteString("<" + tag + " class=\"" + class + "\">")
+		} else {
+			_, _ = w.WriteString("<" + tag + ">")
+		}
+		return ast.WalkContinue, nil
+	}
+}
+
+func (r *htmlRenderer) renderLeaf(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var node formatting.Node
+	switch n := n.(type) {
+	case *Mention:
+		node = n.Node
+	case *Emoji:
+		node = n.Node
+	case *Timestamp:
+		node = n.Node
+	}
+	return ast.WalkContinue, formatting.WriteHTML(w, node, r.options)
+}
@@ -0,0 +1,214 @@
+package goldmarkfmt
+
+import (
+	"strings"
+
+	formatting "github.com/delthas/discord-formatting"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+/*
+ToGoldmark converts a message AST to a goldmark document, along with the source its text segments refer to,
+which must be passed to the goldmark renderer.
+
+Block quotes, code blocks, headers and lists are converted to their goldmark block node, the other content being
+wrapped in paragraphs, and newlines to hard line breaks. Paragraphs grouped by formatting.ParagraphPass are kept. Consecutive list items are converted to a single list.
+Bold, italics, strikethrough, inline code and links are converted to their goldmark inline node. Discord-specific
+nodes are converted to the custom nodes of this package, such as Mention, which can be rendered to HTML with Extension.
+Unknown tags are converted to their raw text.
+*/
+func ToGoldmark(n formatting.Node) (ast.Node, []byte) {
+	c := &toConverter{}
+	doc := ast.NewDocument()
+	c.blocks(doc, n.Children())
+	return doc, c.source
+}
+
+type toConverter struct {
+	source []byte
+}
+
+// text returns a raw text node with the passed content.
+func (c *toConverter) text(s string) *ast.Text {
+	start := len(c.source)
+	c.source = append(c.source, s...)
+	return ast.NewRawTextSegment(text.NewSegment(start, len(c.source)))
+}
+
+func isBlock(n formatting.Node) bool {
+	switch n := n.(type) {
+	case *formatting.BlockQuoteNode, *formatting.HeaderNode, *formatting.BulletListNode, *formatting.ParagraphNode:
+		return true
+	case *formatting.CodeNode:
+		return !n.Inline
+	default:
+		return false
+	}
+}
+
+// blocks appends the nodes to parent, a block container, wrapping inline nodes in paragraphs.
+func (c *toConverter) blocks(parent ast.Node, nodes []formatting.Node) {
+	var paragraph ast.Node
+	var list *ast.List
+	for _, n := range nodes {
+		if !isBlock(n) {
+			if t, ok := n.(*formatting.TextNode); ok && paragraph == nil {
+				// newlines between blocks are implied by the blocks
+				content := strings.TrimLeft(t.Content, "\n")
+				if content == "" {
+					continue
+				}
+				n = &formatting.TextNode{Content: content}
+			}
+			if paragraph == nil {
+				paragraph = ast.NewParagraph()
+				parent.AppendChild(parent, paragraph)
+			}
+			c.inline(paragraph, n)
+			list = nil
+			continue
+		}
+		trimBreaks(paragraph)
+		paragraph = nil
+		switch n := n.(type) {
+		case *formatting.BlockQuoteNode:
+			quote := ast.NewBlockquote()
+			c.blocks(quote, n.Children())
+			parent.AppendChild(parent, quote)
+		case *formatting.CodeNode:
+			var info *ast.Text
+			if n.Language != "" {
+				info = c.text(n.Language)
+			}
+			code := ast.NewFencedCodeBlock(info)
+			lines := text.NewSegments()
+			for _, line := range strings.SplitAfter(n.Content, "\n") {
+				lines.Append(c.text(line).Segment)
+			}
+			if !strings.HasSuffix(n.Content, "\n") {
+				c.source = append(c.source, '\n')
+				last := lines.At(lines.Len() - 1)
+				lines.Set(lines.Len()-1, text.NewSegment(last.Start, last.Stop+1))
+			}
+			code.SetLines(lines)
+			parent.AppendChild(parent, code)
+		case *formatting.ParagraphNode:
+			p := ast.NewParagraph()
+			c.inlines(p, n.Children())
+			trimBreaks(p)
+			parent.AppendChild(parent, p)
+		case *formatting.HeaderNode:
+			heading := ast.NewHeading(n.Level)
+			c.inlines(heading, n.Children())
+			parent.AppendChild(parent, heading)
+		case *formatting.BulletListNode:
+			if list == nil {
+				marker := byte('-')
+				if n.Delimiter == "*" {
+					marker = '*'
+				}
+				list = ast.NewList(marker)
+				list.IsTight = true
+				parent.AppendChild(parent, list)
+			}
+			item := ast.NewListItem(2)
+			block := ast.NewTextBlock()
+			c.inlines(block, n.Children())
+			item.AppendChild(item, block)
+			list.AppendChild(list, item)
+			continue
+		}
+		list = nil
+	}
+	trimBreaks(paragraph)
+}
+
+// trimBreaks removes the line breaks ending a paragraph.
+func trimBreaks(paragraph ast.Node) {
+	if paragraph == nil {
+		return
+	}
+	for last := paragraph.LastChild(); last != nil; last = paragraph.LastChild() {
+		if t, ok := last.(*ast.Text); !ok || !t.HardLineBreak() || t.Segment.Len() > 0 {
+			return
+		}
+		paragraph.RemoveChild(paragraph, last)
+	}
+}
+
+func (c *toConverter) inlines(parent ast.Node, nodes []formatting.Node) {
+	for _, n := range nodes {
+		c.inline(parent, n)
+	}
+}
+
+func (c *toConverter) inline(parent ast.Node, n formatting.Node) {
+	var node ast.Node
+	switch n := n.(type) {
+	case *formatting.TextNode:
+		for i, line := range strings.Split(n.Content, "\n") {
+			if i > 0 {
+				br := ast.NewText()
+				br.SetHardLineBreak(true)
+				parent.AppendChild(parent, br)
+			}
+			if line != "" {
+				parent.AppendChild(parent, c.text(line))
+			}
+		}
+		return
+	case *formatting.LineBreakNode:
+		br := ast.NewText()
+		br.SetHardLineBreak(true)
+		parent.AppendChild(parent, br)
+		return
+	case *formatting.CodeNode:
+		node = ast.NewCodeSpan()
+		node.AppendChild(node, c.text(n.Content))
+	case *formatting.URLNode:
+		link := ast.NewLink()
+		link.Destination = []byte(n.URL)
+		if n.Title != "" {
+			link.Title = []byte(n.Title)
+		}
+		if n.Mask != "" {
+			link.AppendChild(link, c.text(n.Mask))
+		} else {
+			link.AppendChild(link, c.text(n.URL))
+		}
+		node = link
+	case *formatting.BoldNode:
+		node = ast.NewEmphasis(2)
+	case *formatting.ItalicsNode:
+		node = ast.NewEmphasis(1)
+	case *formatting.StrikethroughNode:
+		node = east.NewStrikethrough()
+	case *formatting.UnderlineNode:
+		node = &Underline{}
+	case *formatting.SpoilerNode:
+		node = &Spoiler{}
+	case *formatting.UserMentionNode, *formatting.RoleMentionNode, *formatting.ChannelMentionNode, *formatting.SpecialMentionNode:
+		parent.AppendChild(parent, &Mention{Node: n})
+		return
+	case *formatting.EmojiNode:
+		parent.AppendChild(parent, &Emoji{Node: n})
+		return
+	case *formatting.NamedEmojiNode:
+		parent.AppendChild(parent, c.text(formatting.Text(n)))
+		return
+	case *formatting.TimestampNode:
+		parent.AppendChild(parent, &Timestamp{Node: n})
+		return
+	case *formatting.UnknownTagNode:
+		parent.AppendChild(parent, c.text(n.Raw))
+		return
+	default:
+		// unwrap unknown nodes, and block nodes nested in inline nodes
+		c.inlines(parent, n.Children())
+		return
+	}
+	c.inlines(node, n.Children())
+	parent.AppendChild(parent, node)
+}
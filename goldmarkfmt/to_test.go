@@ -0,0 +1,41 @@
+package goldmarkfmt
+
+import (
+	"bytes"
+	"testing"
+
+	formatting "github.com/delthas/discord-formatting"
+	"github.com/yuin/goldmark"
+)
+
+func TestToGoldmark(t *testing.T) {
+	parser := formatting.NewParser(&formatting.MessageParserOptions)
+	md := goldmark.New(goldmark.WithExtensions(Extension))
+	for text, want := range map[string]string{
+		"hello **world**":                "<p>hello <strong>world</strong></p>\n",
+		"a\nb":                           "<p>a<br>\nb</p>\n",
+		"*i* __u__ ~~s~~ ||x|| `c` <b>":  "<p><em>i</em> <u>u</u> <del>s</del> <span class=\"spoiler\">x</span> <code>c</code> &lt;b&gt;</p>\n",
+		"[mask](https://example.com)":    "<p><a href=\"https://example.com\">mask</a></p>\n",
+		"<@1234> <#1234>":                "<p><span class=\"mention\">@1234</span> <span class=\"mention\">#1234</span></p>\n",
+		"# title\n- a\n- b\nend":         "<h1>title</h1>\n<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<p>end</p>\n",
+		"> quote\nreply":                 "<blockquote>\n<p>quote</p>\n</blockquote>\n<p>reply</p>\n",
+		"```go\nfmt.Println(\"<\")\n```": "<pre><code class=\"language-go\">fmt.Println(&quot;&lt;&quot;)\n</code></pre>\n",
+		"back\\\\slash &amp;":            "<p>back\\slash &amp;amp;</p>\n",
+	} {
+		doc, source := ToGoldmark(parser.Parse(text))
+		var buf bytes.Buffer
+		if err := md.Renderer().Render(&buf, source, doc); err != nil || buf.String() != want {
+			t.Errorf("error converting %q: want %q, got %q (%v)", text, want, buf.String(), err)
+		}
+	}
+	for text, want := range map[string]string{
+		"a\nb\n\nc":     "<p>a<br>\nb</p>\n<p>c</p>\n",
+		"# a\nb\n\n> c": "<h1>a</h1>\n<p>b</p>\n<blockquote>\n<p>c</p>\n</blockquote>\n",
+	} {
+		doc, source := ToGoldmark(formatting.ParagraphPass(parser.Parse(text)))
+		var buf bytes.Buffer
+		if err := md.Renderer().Render(&buf, source, doc); err != nil || buf.String() != want {
+			t.Errorf("error converting %q: want %q, got %q (%v)", text, want, buf.String(), err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package formatting
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+RenderHTML renders an AST to an HTML fragment, close to how the Discord apps display it.
+
+Formatting is rendered with the usual HTML elements, such as <strong> for a BoldNode. Elements that have
+no HTML equivalent are rendered as <span> elements with a class: spoiler, mention.
+Timestamps are formatted with FormatTimestamp and rendered as <time> elements.
+Custom emoji are rendered as <img> elements, with the URL and alternative text returned by the EmojiMapper of the options, if any.
+Code blocks are rendered as <pre><code>, with a language-* class when their language is known,
+and are syntax-highlighted with the Highlighter of the options, if any.
+Paragraphs grouped by ParagraphPass are rendered as <p> elements, without the line breaks around them.
+Links whose scheme is not http, https, discord or mailto, such as javascript:, are rendered as text,
+and highlighted spans are only colored if their Color is in the #rrggbb form.
+
+The options parameter can be nil, which is equivalent to passing an empty RenderOptions.
+*/
+func RenderHTML(n Node, options *RenderOptions) string {
+	var sb strings.Builder
+	WriteHTML(&sb, n, options)
+	return sb.String()
+}
+
+/*
+WriteHTML renders an AST to an HTML fragment like RenderHTML, writing it to w.
+
+Rendering stops at the first write error, which is returned.
+*/
+func WriteHTML(w io.Writer, n Node, options *RenderOptions) error {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+	r := htmlRenderer{
+		options: options,
+		w:       &errWriter{w: w},
+	}
+	return r.render(n)
+}
+
+// htmlLinkSchemes are the URL schemes of the links rendered by RenderHTML. Links with other schemes are rendered as text.
+var htmlLinkSchemes = []string{"http", "https", "discord", "mailto"}
+
+type htmlRenderer struct {
+	options *RenderOptions
+	w       *errWriter
+	// pre is set when rendering the content of a code block, where newlines are kept as is.
+	pre bool
+}
+
+func (r *htmlRenderer) render(n Node) error {
+	sb := r.w
+	return WalkErr(n, func(n Node, entering bool) error {
+		switch n := n.(type) {
+		case *TextNode:
+			if !entering || paragraphBreak(n) {
+				break
+			}
+			if r.pre {
+				sb.WriteString(html.EscapeString(n.Content))
+			} else {
+				sb.WriteString(strings.ReplaceAll(html.EscapeString(n.Content), "\n", "<br>"))
+			}
+		case *BlockQuoteNode:
+			htmlTag(sb, "blockquote", "", entering)
+		case *CodeNode:
+			if !entering {
+				break
+			}
+			if n.Inline {
+				sb.WriteString("<code>")
+				sb.WriteString(html.EscapeString(n.Content))
+				sb.WriteString("</code>")
+				break
+			}
+			if n.Language != "" {
+				sb.WriteString(fmt.Sprintf("<pre><code class=\"language-%s\">", html.EscapeString(n.Language)))
+			} else {
+				sb.WriteString("<pre><code>")
+			}
+			if h := highlight(n, r.options); h != nil {
+				r.pre = true
+				r.render(h)
+				r.pre = false
+			} else {
+				sb.WriteString(html.EscapeString(n.Content))
+			}
+			sb.WriteString("</code></pre>")
+		case *SpoilerNode:
+			htmlTag(sb, "span", "spoiler", entering)
+		case *URLNode:
+			if !entering {
+				break
+			}
+			text := n.Mask
+			if text == "" {
+				text = n.URL
+			}
+			if _, ok := allowedURL(n.URL, htmlLinkSchemes); !ok {
+				// links with other schemes, such as javascript:, could run scripts
+				sb.WriteString(html.EscapeString(text))
+				break
+			}
+			if n.Title != "" {
+				sb.WriteString(fmt.Sprintf("<a href=\"%s\" title=\"%s\">%s</a>", html.EscapeString(n.URL), html.EscapeString(n.Title), html.EscapeString(text)))
+			} else {
+				sb.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(n.URL), html.EscapeString(text)))
+			}
+		case *EmojiNode:
+			if !entering {
+				break
+			}
+			url, altText := emojiImage(n, r.options)
+			if url == "" {
+				sb.WriteString(html.EscapeString(altText))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("<img class=\"emoji\" src=\"%s\" alt=\"%s\">", html.EscapeString(url), html.EscapeString(altText)))
+		case *NamedEmojiNode:
+			if entering {
+				sb.WriteString(html.EscapeString(nodeText(n)))
+			}
+		case *ChannelMentionNode:
+			if entering {
+				htmlMention(sb, "#"+n.ID)
+			}
+		case *RoleMentionNode:
+			if entering {
+				htmlMention(sb, "@&"+n.ID)
+			}
+		case *UserMentionNode:
+			if entering {
+				htmlMention(sb, "@"+n.ID)
+			}
+		case *SpecialMentionNode:
+			if entering {
+				htmlMention(sb, "@"+n.Mention)
+			}
+		case *TimestampNode:
+			if !entering {
+				break
+			}
+			if t, err := n.Time(); err == nil {
+				sb.WriteString(fmt.Sprintf("<time class=\"timestamp\" datetime=\"%s\">", t.UTC().Format(time.RFC3339)))
+			} else {
+				sb.WriteString("<time class=\"timestamp\">")
+			}
+			sb.WriteString(html.EscapeString(timestampText(n, r.options)))
+			sb.WriteString("</time>")
+		case *UnknownTagNode:
+			if entering {
+				sb.WriteString(html.EscapeString(n.Raw))
+			}
+		case *HeaderNode:
+			htmlTag(sb, fmt.Sprintf("h%d", n.Level), "", entering)
+		case *BulletListNode:
+			if entering {
+				sb.WriteString("<ul><li>")
+			} else {
+				sb.WriteString("</li></ul>")
+			}
+		case *BoldNode:
+			htmlTag(sb, "strong", "", entering)
+		case *UnderlineNode:
+			htmlTag(sb, "u", "", entering)
+		case *ItalicsNode:
+			htmlTag(sb, "em", "", entering)
+		case *StrikethroughNode:
+			htmlTag(sb, "s", "", entering)
+		case *LineBreakNode:
+			if entering {
+				sb.WriteString("<br>")
+			}
+		case *ParagraphNode:
+			htmlTag(sb, "p", "", entering)
+		case *HighlightNode:
+			if !entering {
+				sb.WriteString("</span>")
+				break
+			}
+			sb.WriteString("<span")
+			if n.Class != "" {
+				sb.WriteString(fmt.Sprintf(" class=\"hljs-%s\"", html.EscapeString(n.Class)))
+			}
+			if _, ok := hexColor(n.Color); ok {
+				sb.WriteString(fmt.Sprintf(" style=\"color: %s\"", html.EscapeString(n.Color)))
+			}
+			sb.WriteString(">")
+		}
+		return r.w.err
+	})
+}
+
+// paragraphBreak returns whether t only contains the line breaks before or after a paragraph, as kept by ParagraphPass.
+func paragraphBreak(t *TextNode) bool {
+	if strings.Trim(t.Content, "\n \t") != "" {
+		return false
+	}
+	_, before := t.PrevSibling().(*ParagraphNode)
+	_, after := t.NextSibling().(*ParagraphNode)
+	return before || after
+}
+
+func htmlTag(sb *errWriter, tag string, class string, entering bool) {
+	if !entering {
+		sb.WriteString("</" + tag + ">")
+	} else if class != "" {
+		sb.WriteString("<" + tag + " class=\"" + class + "\">")
+	} else {
+		sb.WriteString("<" + tag + ">")
+	}
+}
+
+func htmlMention(sb *errWriter, text string) {
+	sb.WriteString("<span class=\"mention\">")
+	sb.WriteString(html.EscapeString(text))
+	sb.WriteString("</span>")
+}
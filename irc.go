@@ -0,0 +1,319 @@
+package formatting
+
+import (
+	"io"
+	"strings"
+)
+
+// IRC formatting control codes.
+const (
+	ircBold          = '\x02'
+	ircColor         = '\x03'
+	ircHexColor      = '\x04'
+	ircReset         = '\x0F'
+	ircMonospace     = '\x11'
+	ircReverse       = '\x16'
+	ircItalics       = '\x1D'
+	ircStrikethrough = '\x1E'
+	ircUnderline     = '\x1F'
+)
+
+// ircStyles are the IRC styles that map to formatting nodes, in the order in which their nodes are nested.
+var ircStyles = []rune{ircBold, ircItalics, ircUnderline, ircStrikethrough}
+
+/*
+ParseIRC parses a message with IRC formatting control codes to an AST, for example to bridge IRC messages to Discord
+by serializing the AST with RenderMarkdown.
+
+Bold, italics, underline and strikethrough are parsed to their formatting node, and monospace text to inline code.
+Colors and reverse video have no Discord equivalent and are removed. The reset code ends all formatting.
+The text is parsed as is: Discord formatting syntax in the text is not parsed, and is stored in text nodes.
+*/
+func ParseIRC(text string) Node {
+	p := ircParser{
+		root:  NewRoot(),
+		style: make(map[rune]bool),
+	}
+	p.stack = []Node{p.root}
+	for i := 0; i < len(text); i++ {
+		switch c := rune(text[i]); c {
+		case ircBold, ircItalics, ircUnderline, ircStrikethrough:
+			p.flush()
+			p.style[c] = !p.style[c]
+		case ircMonospace:
+			p.flush()
+			p.monospace = !p.monospace
+		case ircReset:
+			p.flush()
+			p.style = make(map[rune]bool)
+			p.monospace = false
+		case ircColor:
+			i += ircColorLength(text[i+1:], isDigit, 2)
+		case ircHexColor:
+			i += ircColorLength(text[i+1:], isHexDigit, 6)
+		case ircReverse:
+		default:
+			p.sb.WriteByte(text[i])
+		}
+	}
+	p.flush()
+	return p.root
+}
+
+type ircParser struct {
+	root Node
+	// stack is the chain of currently open nodes, starting from the root.
+	stack     []Node
+	style     map[rune]bool
+	monospace bool
+	sb        strings.Builder
+}
+
+// flush appends the text written since the last flush, with the current style.
+func (p *ircParser) flush() {
+	if p.sb.Len() == 0 {
+		return
+	}
+	text := p.sb.String()
+	p.sb.Reset()
+
+	var styles []rune
+	for _, s := range ircStyles {
+		if p.style[s] {
+			styles = append(styles, s)
+		}
+	}
+	// keep the open nodes matching a prefix of the current styles, and open the others
+	open := 0
+	for open < len(styles) && open+1 < len(p.stack) && ircStyle(p.stack[open+1]) == styles[open] {
+		open++
+	}
+	p.stack = p.stack[:open+1]
+	for _, s := range styles[open:] {
+		var n Node
+		switch s {
+		case ircBold:
+			n = &BoldNode{}
+		case ircItalics:
+			n = &ItalicsNode{}
+		case ircUnderline:
+			n = &UnderlineNode{}
+		case ircStrikethrough:
+			n = &StrikethroughNode{}
+		}
+		p.stack[len(p.stack)-1].AppendChild(n)
+		p.stack = append(p.stack, n)
+	}
+
+	parent := p.stack[len(p.stack)-1]
+	if p.monospace {
+		parent.AppendChild(NewCode(text))
+	} else {
+		parent.AppendChild(NewText(text))
+	}
+}
+
+// ircStyle returns the IRC style code of a formatting node.
+func ircStyle(n Node) rune {
+	switch n.(type) {
+	case *BoldNode:
+		return ircBold
+	case *ItalicsNode:
+		return ircItalics
+	case *UnderlineNode:
+		return ircUnderline
+	case *StrikethroughNode:
+		return ircStrikethrough
+	default:
+		return 0
+	}
+}
+
+// ircColorLength returns the length of the arguments of a color code: a foreground color, and an optional background color
+// after a comma, each of at most n digits.
+func ircColorLength(s string, digit func(c byte) bool, n int) int {
+	digits := func(s string) int {
+		i := 0
+		for i < len(s) && i < n && digit(s[i]) {
+			i++
+		}
+		return i
+	}
+	i := digits(s)
+	if i == 0 {
+		return 0
+	}
+	if i < len(s) && s[i] == ',' {
+		if j := digits(s[i+1:]); j > 0 {
+			i += 1 + j
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+/*
+RenderIRC renders an AST to text with IRC formatting control codes, for example to bridge Discord messages to IRC.
+
+Bold, italics, underline and strikethrough are rendered with their control code, and code with the monospace code.
+Spoilers are rendered with the same foreground and background color. Links are rendered as their URL,
+preceded by their Mask if any. Block quotes are rendered with a > before each line.
+
+The options parameter can be nil, which is equivalent to passing an empty RenderOptions.
+*/
+func RenderIRC(n Node, options *RenderOptions) string {
+	var sb strings.Builder
+	WriteIRC(&sb, n, options)
+	return sb.String()
+}
+
+/*
+WriteIRC renders an AST to text with IRC formatting control codes like RenderIRC, writing it to w.
+
+Rendering stops at the first write error, which is returned.
+*/
+func WriteIRC(w io.Writer, n Node, options *RenderOptions) error {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+	r := ircRenderer{
+		options: options,
+		w:       &errWriter{w: w},
+	}
+	return r.render(n)
+}
+
+type ircRenderer struct {
+	options *RenderOptions
+	w       *errWriter
+	quote   int
+	// prefix is set when a quote prefix should be written before the next text of a block quote.
+	prefix bool
+	// active is the number of open nodes of each formatting control code, which is only written
+	// when its first node is entered and its last node is left, as the codes toggle formatting.
+	active map[rune]int
+	// spoiler is the number of open spoilers.
+	spoiler int
+}
+
+func (r *ircRenderer) text(s string) {
+	if r.quote == 0 {
+		r.w.WriteString(s)
+		return
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if r.prefix {
+			r.w.WriteString("> ")
+		}
+		r.w.WriteString(line)
+		r.prefix = strings.HasSuffix(line, "\n")
+	}
+}
+
+func (r *ircRenderer) code(c rune) {
+	r.w.WriteString(string(c))
+}
+
+func (r *ircRenderer) style(c rune, entering bool) {
+	if r.active == nil {
+		r.active = make(map[rune]int)
+	}
+	if entering {
+		r.active[c]++
+		if r.active[c] == 1 {
+			r.code(c)
+		}
+	} else {
+		r.active[c]--
+		if r.active[c] == 0 {
+			r.code(c)
+		}
+	}
+}
+
+func (r *ircRenderer) render(n Node) error {
+	return WalkErr(n, func(n Node, entering bool) error {
+		switch n := n.(type) {
+		case *TextNode:
+			if entering {
+				r.text(n.Content)
+			}
+		case *BlockQuoteNode:
+			if entering {
+				r.quote++
+				r.prefix = true
+			} else {
+				r.quote--
+				r.prefix = false
+			}
+		case *CodeNode:
+			if entering {
+				r.code(ircMonospace)
+				r.text(n.Content)
+				r.code(ircMonospace)
+			}
+		case *SpoilerNode:
+			if entering {
+				if r.spoiler == 0 {
+					r.w.WriteString("\x0301,01")
+				}
+				r.spoiler++
+			} else if r.spoiler--; r.spoiler == 0 {
+				// an empty bold toggle ends the color code, so that a digit after it is not read as a color
+				r.w.WriteString("\x03\x02\x02")
+			}
+		case *URLNode:
+			if !entering {
+				break
+			}
+			if n.Mask != "" && n.Mask != strings.TrimPrefix(n.URL, "mailto:") {
+				r.text(n.Mask + " (" + n.URL + ")")
+			} else if n.Mask != "" {
+				r.text(n.Mask)
+			} else {
+				r.text(n.URL)
+			}
+		case *TimestampNode:
+			if entering {
+				r.text(timestampText(n, r.options))
+			}
+		case *EmojiNode:
+			if entering {
+				_, altText := emojiImage(n, r.options)
+				r.text(altText)
+			}
+		case *HeaderNode, *BoldNode:
+			r.style(ircBold, entering)
+		case *BulletListNode:
+			if entering {
+				if n.NestedLevel > 1 {
+					r.text(strings.Repeat("  ", n.NestedLevel-1))
+				}
+				r.text("• ")
+			} else if n.IncludesNewline {
+				r.text("\n")
+			}
+		case *UnderlineNode:
+			r.style(ircUnderline, entering)
+		case *ItalicsNode:
+			r.style(ircItalics, entering)
+		case *StrikethroughNode:
+			r.style(ircStrikethrough, entering)
+		default:
+			if entering {
+				r.text(nodeText(n))
+			}
+		}
+		return r.w.err
+	})
+}
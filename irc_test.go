@@ -0,0 +1,50 @@
+package formatting
+
+import "testing"
+
+func TestParseIRC(t *testing.T) {
+	for text, want := range map[string]string{
+		"hello":                          `[[text "hello"]]`,
+		"\x02bold\x02 text":              `[[bold [text "bold"]] [text " text"]]`,
+		"\x02a\x1Db\x02c\x0F d":          `[[bold [text "a"] [italics [text "b"]]] [italics [text "c"]] [text " d"]]`,
+		"\x0304,12red\x03 \x04FF0000hex": `[[text "red hex"]]`,
+		"\x0312,x":                       `[[text ",x"]]`,
+		"\x11code\x11 *not bold*":        `[[code "" "code"] [text " *not bold*"]]`,
+		"\x1Fu\x1E\x1Fs\x1E":             `[[underline [text "u"]] [strikethrough [text "s"]]]`,
+	} {
+		if got := Debug(ParseIRC(text)); got != want {
+			t.Errorf("error parsing %q: want %s, got %s", text, want, got)
+		}
+	}
+	if got, want := RenderMarkdown(ParseIRC("\x02hi\x02 *there*"), &MarkdownOptions{MinimalEscaping: true}), "**hi** \\*there*"; got != want {
+		t.Errorf("error converting IRC to markdown: want %q, got %q", want, got)
+	}
+}
+
+func TestRenderIRC(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	for text, want := range map[string]string{
+		"**bold** *it* __u__ ~~s~~":                       "\x02bold\x02 \x1Dit\x1D \x1Fu\x1F \x1Es\x1E",
+		"`code` ||spoiler||":                              "\x11code\x11 \x0301,01spoiler\x03\x02\x02",
+		"[mask](https://example.com) https://example.com": "mask (https://example.com) https://example.com",
+		"<@1234> <:e:1234>":                               "@1234 :e:",
+		"> a\nb":                                          "> a\nb",
+		">>> a\nb":                                        "> a\n> b",
+		"- a\n- b":                                        "• a\n• b",
+		"||a||5 apples":                                   "\x0301,01a\x03\x02\x025 apples",
+		"# **a** b":                                       "\x02a b\x02",
+	} {
+		if got := RenderIRC(parser.Parse(text), nil); got != want {
+			t.Errorf("error rendering %q: want %q, got %q", text, want, got)
+		}
+	}
+	if got, want := RenderIRC(ParseIRC("\x02a\x1Db\x0F c"), nil), "\x02a\x1Db\x1D\x02 c"; got != want {
+		t.Errorf("error rendering parsed IRC: want %q, got %q", want, got)
+	}
+	if got, want := Debug(ParseIRC(RenderIRC(parser.Parse("||a||5 apples"), nil))), `[[text "a"] [text "5 apples"]]`; got != want {
+		t.Errorf("error parsing rendered spoiler: want %q, got %q", want, got)
+	}
+	if got, want := RenderIRC(NewRoot(&BulletListNode{}), nil), "• "; got != want {
+		t.Errorf("error rendering list item without level: want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.23
+
+package formatting
+
+import "iter"
+
+/*
+All returns an iterator over all the nodes of the passed tree, in depth-first order, the root being first.
+*/
+func All(n Node) iter.Seq[Node] {
+	return func(yield func(Node) bool) {
+		WalkWithStatus(n, func(n Node, entering bool) WalkStatus {
+			if entering && !yield(n) {
+				return WalkStop
+			}
+			return WalkContinue
+		})
+	}
+}
+
+/*
+ByType returns an iterator over all the nodes of the passed tree of type T, in depth-first order.
+
+For example, ByType[*UserMentionNode](root) iterates over all the user mentions of a message.
+*/
+func ByType[T Node](n Node) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for n := range All(n) {
+			if t, ok := n.(T); ok && !yield(t) {
+				return
+			}
+		}
+	}
+}
+
+/*
+Events returns an iterator over the events of a walk of the passed AST, in the order of Walk:
+each node is yielded with true on entering it, and with false on leaving it.
+*/
+func Events(n Node) iter.Seq2[Node, bool] {
+	return func(yield func(Node, bool) bool) {
+		walk(n, func(n Node, entering bool) WalkStatus {
+			if !yield(n, entering) {
+				return WalkStop
+			}
+			return WalkContinue
+		})
+	}
+}
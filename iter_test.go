@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package formatting
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIter(t *testing.T) {
+	root := NewParser(nil).Parse("a <@1> **<@2>** <@3>")
+	var kinds []NodeKind
+	for n := range All(root) {
+		kinds = append(kinds, n.Kind())
+		if len(kinds) == 3 {
+			break
+		}
+	}
+	if want := []NodeKind{KindRoot, KindText, KindUserMention}; fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("error iterating: want %v, got %v", want, kinds)
+	}
+	var ids []string
+	for n := range ByType[*UserMentionNode](root) {
+		ids = append(ids, n.ID)
+		if n.ID == "2" {
+			break
+		}
+	}
+	if want := []string{"1", "2"}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("error iterating by type: want %v, got %v", want, ids)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	root := NewParser(nil).Parse("a **b**")
+	var got []Event
+	for n, entering := range Events(root) {
+		got = append(got, Event{Node: n, Entering: entering})
+	}
+	if want := Flatten(root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("error iterating events: want %v, got %v", want, got)
+	}
+}
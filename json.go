@@ -0,0 +1,260 @@
+package formatting
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// jsonNode is the JSON representation of a Node, as documented in MarshalJSON.
+type jsonNode struct {
+	Type            string      `json:"type"`
+	Start           int         `json:"start"`
+	End             int         `json:"end"`
+	Children        []*jsonNode `json:"children,omitempty"`
+	Content         string      `json:"content,omitempty"`
+	Escaped         bool        `json:"escaped,omitempty"`
+	Language        string      `json:"language,omitempty"`
+	RawLanguage     string      `json:"rawLanguage,omitempty"`
+	Inline          bool        `json:"inline,omitempty"`
+	Delimiter       string      `json:"delimiter,omitempty"`
+	URL             string      `json:"url,omitempty"`
+	Mask            string      `json:"mask,omitempty"`
+	Title           string      `json:"title,omitempty"`
+	Invite          string      `json:"invite,omitempty"`
+	Suppressed      bool        `json:"suppressed,omitempty"`
+	Animated        bool        `json:"animated,omitempty"`
+	Text            string      `json:"text,omitempty"`
+	ID              string      `json:"id,omitempty"`
+	Mention         string      `json:"mention,omitempty"`
+	Stamp           string      `json:"stamp,omitempty"`
+	Format          string      `json:"format,omitempty"`
+	Raw             string      `json:"raw,omitempty"`
+	Name            string      `json:"name,omitempty"`
+	SkinTone        int         `json:"skinTone,omitempty"`
+	Level           int         `json:"level,omitempty"`
+	Marker          string      `json:"marker,omitempty"`
+	NestedLevel     int         `json:"nestedLevel,omitempty"`
+	IncludesNewline bool        `json:"includesNewline,omitempty"`
+	Class           string      `json:"class,omitempty"`
+	Color           string      `json:"color,omitempty"`
+}
+
+/*
+MarshalJSON serializes an AST to JSON, for example to send parsed messages to a web frontend.
+
+Unlike Debug, the JSON shape is stable. Each node is an object with:
+  - a "type" discriminator, the name of its kind as returned by NodeKind.String, such as "bold" or "usermention";
+  - its "start" and "end" byte offsets in the source message, as returned by Span;
+  - its "children" array, omitted if the node has no children;
+  - the fields of its type, in camel case, such as "content" for TextNode.Content
+    or "nestedLevel" for BulletListNode.NestedLevel, omitted if they are empty.
+
+For example, **hi** is serialized as:
+
+	{"type":"root","start":0,"end":6,"children":[{"type":"bold","start":0,"end":6,"children":[{"type":"text","start":2,"end":4,"content":"hi"}]}]}
+
+Attributes set with SetAttr are not serialized. Use UnmarshalJSON to deserialize the AST.
+*/
+func MarshalJSON(n Node) ([]byte, error) {
+	return json.Marshal(toJSONNode(n))
+}
+
+func toJSONNode(n Node) *jsonNode {
+	j := &jsonNode{
+		Type:  n.Kind().String(),
+		Start: n.Span().Start,
+		End:   n.Span().End,
+	}
+	for _, c := range n.Children() {
+		j.Children = append(j.Children, toJSONNode(c))
+	}
+	switch n := n.(type) {
+	case *TextNode:
+		j.Content = n.Content
+		j.Escaped = n.Escaped
+	case *BlockQuoteNode:
+		j.Delimiter = n.Delimiter
+	case *CodeNode:
+		j.Content = n.Content
+		j.Language = n.Language
+		j.RawLanguage = n.RawLanguage
+		j.Inline = n.Inline
+		j.Delimiter = n.Delimiter
+	case *URLNode:
+		j.URL = n.URL
+		j.Mask = n.Mask
+		j.Title = n.Title
+		j.Invite = n.Invite
+		j.Suppressed = n.Suppressed
+	case *EmojiNode:
+		j.Animated = n.Animated
+		j.Text = n.Text
+		j.ID = n.ID
+	case *NamedEmojiNode:
+		j.Name = n.Name
+		j.SkinTone = n.SkinTone
+	case *ChannelMentionNode:
+		j.ID = n.ID
+	case *RoleMentionNode:
+		j.ID = n.ID
+	case *UserMentionNode:
+		j.ID = n.ID
+	case *SpecialMentionNode:
+		j.Mention = n.Mention
+	case *TimestampNode:
+		j.Stamp = n.Stamp
+		j.Format = n.Format
+	case *UnknownTagNode:
+		j.Raw = n.Raw
+		j.Name = n.Name
+		j.Content = n.Content
+	case *HeaderNode:
+		j.Level = n.Level
+		j.Marker = n.Marker
+	case *BulletListNode:
+		j.NestedLevel = n.NestedLevel
+		j.IncludesNewline = n.IncludesNewline
+		j.Delimiter = n.Delimiter
+	case *ItalicsNode:
+		j.Delimiter = n.Delimiter
+	case *HighlightNode:
+		j.Class = n.Class
+		j.Color = n.Color
+	}
+	return j
+}
+
+/*
+UnmarshalJSON deserializes an AST serialized with MarshalJSON.
+
+The returned tree is indexed. Unknown fields are ignored. An error is returned if the data is not valid JSON,
+if it contains a node of an unknown type, or if it contains a header or list item whose level is out of range.
+*/
+func UnmarshalJSON(data []byte) (Node, error) {
+	var j jsonNode
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	n, err := fromJSONNode(&j)
+	if err != nil {
+		return nil, err
+	}
+	Index(n)
+	return n, nil
+}
+
+func fromJSONNode(root *jsonNode) (Node, error) {
+	// decode the tree iteratively with an explicit stack, so that deep trees cannot overflow the goroutine stack
+	type frame struct {
+		j      *jsonNode
+		parent Node
+	}
+	var result Node
+	stack := []frame{{j: root}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n, err := fromJSONFields(f.j)
+		if err != nil {
+			return nil, err
+		}
+		if f.parent == nil {
+			result = n
+		} else {
+			f.parent.AppendChild(n)
+		}
+		for i := len(f.j.Children) - 1; i >= 0; i-- {
+			stack = append(stack, frame{j: f.j.Children[i], parent: n})
+		}
+	}
+	return result, nil
+}
+
+// fromJSONFields returns the node of j, without its children.
+func fromJSONFields(j *jsonNode) (Node, error) {
+	if j == nil {
+		return nil, fmt.Errorf("invalid node: null")
+	}
+	kind, ok := ParseNodeKind(j.Type)
+	if !ok {
+		return nil, fmt.Errorf("invalid node type: %q", j.Type)
+	}
+	n := newNodeOfKind(kind)
+	n.setSpan(Span{Start: j.Start, End: j.End})
+	switch n := n.(type) {
+	case *TextNode:
+		n.Content = j.Content
+		n.Escaped = j.Escaped
+	case *BlockQuoteNode:
+		n.Delimiter = j.Delimiter
+	case *CodeNode:
+		n.Content = j.Content
+		n.Language = j.Language
+		n.RawLanguage = j.RawLanguage
+		n.Inline = j.Inline
+		n.Delimiter = j.Delimiter
+	case *URLNode:
+		n.URL = j.URL
+		n.Mask = j.Mask
+		n.Title = j.Title
+		n.Invite = j.Invite
+		n.Suppressed = j.Suppressed
+	case *EmojiNode:
+		n.Animated = j.Animated
+		n.Text = j.Text
+		n.ID = j.ID
+	case *NamedEmojiNode:
+		n.Name = j.Name
+		n.SkinTone = j.SkinTone
+	case *ChannelMentionNode:
+		n.ID = j.ID
+	case *RoleMentionNode:
+		n.ID = j.ID
+	case *UserMentionNode:
+		n.ID = j.ID
+	case *SpecialMentionNode:
+		n.Mention = j.Mention
+	case *TimestampNode:
+		n.Stamp = j.Stamp
+		n.Format = j.Format
+	case *UnknownTagNode:
+		n.Raw = j.Raw
+		n.Name = j.Name
+		n.Content = j.Content
+	case *HeaderNode:
+		n.Level = j.Level
+		n.Marker = j.Marker
+	case *BulletListNode:
+		n.NestedLevel = j.NestedLevel
+		n.IncludesNewline = j.IncludesNewline
+		n.Delimiter = j.Delimiter
+	case *ItalicsNode:
+		n.Delimiter = j.Delimiter
+	case *HighlightNode:
+		n.Class = j.Class
+		n.Color = j.Color
+	}
+	if err := checkLevels(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// maxNestedLevel is the maximum BulletListNode.NestedLevel of deserialized nodes.
+const maxNestedLevel = 32
+
+// checkLevels returns an error if the header level or list item level of a deserialized node is out of range,
+// as renderers rely on them being valid.
+func checkLevels(n Node) error {
+	switch n := n.(type) {
+	case *HeaderNode:
+		if n.Level < 1 || n.Level > 3 {
+			return fmt.Errorf("invalid header level: %d", n.Level)
+		}
+	case *BulletListNode:
+		if n.NestedLevel < 1 || n.NestedLevel > maxNestedLevel {
+			return fmt.Errorf("invalid list item nested level: %d", n.NestedLevel)
+		}
+	}
+	return nil
+}
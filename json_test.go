@@ -0,0 +1,63 @@
+package formatting
+
+import "testing"
+
+func TestJSON(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	b, err := MarshalJSON(parser.Parse("**hi**"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"root","start":0,"end":6,"children":[{"type":"bold","start":0,"end":6,"children":[{"type":"text","start":2,"end":4,"content":"hi"}]}]}`
+	if string(b) != want {
+		t.Errorf("marshaling: want %s, got %s", want, b)
+	}
+	b, err = MarshalJSON(parser.Parse("\\*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"type":"root","start":0,"end":2,"children":[{"type":"text","start":0,"end":2,"content":"*","escaped":true}]}`
+	if string(b) != want {
+		t.Errorf("marshaling escape: want %s, got %s", want, b)
+	}
+
+	for _, text := range []string{
+		"",
+		"hi **bold _it_** ||spoiler|| ~~s~~ __u__",
+		"> quote\n# header\n- item\n  * nested",
+		"`code` ```go\nfunc()\n``` [mask](https://example.com \"title\") https://discord.gg/abc <https://example.com>",
+		"<a:e:1> <#1> <@&2> <@3> @everyone <t:1234:R>",
+	} {
+		root := parser.Parse(text)
+		b, err := MarshalJSON(root)
+		if err != nil {
+			t.Errorf("marshaling %q: %v", text, err)
+			continue
+		}
+		got, err := UnmarshalJSON(b)
+		if err != nil {
+			t.Errorf("unmarshaling %q: %v", text, err)
+			continue
+		}
+		if !Equal(got, root) || Debug(got) != Debug(root) {
+			t.Errorf("round trip of %q: want %s, got %s", text, Debug(root), Debug(got))
+		}
+		for _, c := range got.Children() {
+			if c.Parent() != got {
+				t.Errorf("round trip of %q: tree is not indexed", text)
+			}
+		}
+	}
+
+	if _, err := UnmarshalJSON([]byte(`{"type":"foo"}`)); err == nil {
+		t.Errorf("unmarshaling unknown type: want error")
+	}
+	if _, err := UnmarshalJSON([]byte(`{"type":"root","children":[null]}`)); err == nil {
+		t.Errorf("unmarshaling null child: want error")
+	}
+	for _, data := range []string{`{"type":"list"}`, `{"type":"list","nestedLevel":-1}`, `{"type":"header","level":7}`} {
+		if _, err := UnmarshalJSON([]byte(data)); err == nil {
+			t.Errorf("unmarshaling %s: want error", data)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package formatting
+
+/*
+NodeKind is the kind of a Node, returned by Node.Kind. Each Node type has its own kind, such as KindText for TextNode.
+
+Unlike type switches, kinds are comparable values, which can be used as map keys, for example for dispatch tables.
+Kind values are stable: new kinds are only added at the end.
+*/
+type NodeKind int
+
+const (
+	// KindRoot is the kind of the root Node returned by Parse.
+	KindRoot NodeKind = iota + 1
+	KindText
+	KindBlockQuote
+	KindCode
+	KindSpoiler
+	KindURL
+	KindEmoji
+	KindChannelMention
+	KindRoleMention
+	KindUserMention
+	KindSpecialMention
+	KindTimestamp
+	KindUnknownTag
+	KindHeader
+	KindBulletList
+	KindBold
+	KindUnderline
+	KindItalics
+	KindStrikethrough
+	KindHighlight
+	KindLineBreak
+	KindParagraph
+	KindNamedEmoji
+)
+
+var kindNames = map[NodeKind]string{
+	KindRoot:           "root",
+	KindText:           "text",
+	KindBlockQuote:     "blockquote",
+	KindCode:           "code",
+	KindSpoiler:        "spoiler",
+	KindURL:            "url",
+	KindEmoji:          "emoji",
+	KindChannelMention: "channelmention",
+	KindRoleMention:    "rolemention",
+	KindUserMention:    "usermention",
+	KindSpecialMention: "specialmention",
+	KindTimestamp:      "timestamp",
+	KindUnknownTag:     "unknowntag",
+	KindHeader:         "header",
+	KindBulletList:     "list",
+	KindBold:           "bold",
+	KindUnderline:      "underline",
+	KindItalics:        "italics",
+	KindStrikethrough:  "strikethrough",
+	KindHighlight:      "highlight",
+	KindLineBreak:      "linebreak",
+	KindParagraph:      "paragraph",
+	KindNamedEmoji:     "namedemoji",
+}
+
+/*
+String returns the name of the kind, such as "text" for KindText, or "invalid" if the kind is unknown.
+*/
+func (k NodeKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return "invalid"
+}
+
+/*
+ParseNodeKind returns the kind named name, as returned by NodeKind.String. It returns false if there is no such kind.
+*/
+func ParseNodeKind(name string) (NodeKind, bool) {
+	for k, n := range kindNames {
+		if n == name {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// newNodeOfKind returns a new empty Node of kind k, or nil if the kind is unknown.
+func newNodeOfKind(k NodeKind) Node {
+	switch k {
+	case KindRoot:
+		return NewRoot()
+	case KindText:
+		return &TextNode{}
+	case KindBlockQuote:
+		return &BlockQuoteNode{}
+	case KindCode:
+		return &CodeNode{}
+	case KindSpoiler:
+		return &SpoilerNode{}
+	case KindURL:
+		return &URLNode{}
+	case KindEmoji:
+		return &EmojiNode{}
+	case KindChannelMention:
+		return &ChannelMentionNode{}
+	case KindRoleMention:
+		return &RoleMentionNode{}
+	case KindUserMention:
+		return &UserMentionNode{}
+	case KindSpecialMention:
+		return &SpecialMentionNode{}
+	case KindTimestamp:
+		return &TimestampNode{}
+	case KindUnknownTag:
+		return &UnknownTagNode{}
+	case KindHeader:
+		return &HeaderNode{}
+	case KindBulletList:
+		return &BulletListNode{}
+	case KindBold:
+		return &BoldNode{}
+	case KindUnderline:
+		return &UnderlineNode{}
+	case KindItalics:
+		return &ItalicsNode{}
+	case KindStrikethrough:
+		return &StrikethroughNode{}
+	case KindHighlight:
+		return &HighlightNode{}
+	case KindLineBreak:
+		return &LineBreakNode{}
+	case KindParagraph:
+		return &ParagraphNode{}
+	case KindNamedEmoji:
+		return &NamedEmojiNode{}
+	default:
+		return nil
+	}
+}
+
+// Kind returns KindRoot.
+func (*node) Kind() NodeKind {
+	return KindRoot
+}
+
+// Kind returns KindText.
+func (*TextNode) Kind() NodeKind {
+	return KindText
+}
+
+// Kind returns KindBlockQuote.
+func (*BlockQuoteNode) Kind() NodeKind {
+	return KindBlockQuote
+}
+
+// Kind returns KindCode.
+func (*CodeNode) Kind() NodeKind {
+	return KindCode
+}
+
+// Kind returns KindSpoiler.
+func (*SpoilerNode) Kind() NodeKind {
+	return KindSpoiler
+}
+
+// Kind returns KindURL.
+func (*URLNode) Kind() NodeKind {
+	return KindURL
+}
+
+// Kind returns KindEmoji.
+func (*EmojiNode) Kind() NodeKind {
+	return KindEmoji
+}
+
+// Kind returns KindChannelMention.
+func (*ChannelMentionNode) Kind() NodeKind {
+	return KindChannelMention
+}
+
+// Kind returns KindRoleMention.
+func (*RoleMentionNode) Kind() NodeKind {
+	return KindRoleMention
+}
+
+// Kind returns KindUserMention.
+func (*UserMentionNode) Kind() NodeKind {
+	return KindUserMention
+}
+
+// Kind returns KindSpecialMention.
+func (*SpecialMentionNode) Kind() NodeKind {
+	return KindSpecialMention
+}
+
+// Kind returns KindTimestamp.
+func (*TimestampNode) Kind() NodeKind {
+	return KindTimestamp
+}
+
+// Kind returns KindUnknownTag.
+func (*UnknownTagNode) Kind() NodeKind {
+	return KindUnknownTag
+}
+
+// Kind returns KindHeader.
+func (*HeaderNode) Kind() NodeKind {
+	return KindHeader
+}
+
+// Kind returns KindBulletList.
+func (*BulletListNode) Kind() NodeKind {
+	return KindBulletList
+}
+
+// Kind returns KindBold.
+func (*BoldNode) Kind() NodeKind {
+	return KindBold
+}
+
+// Kind returns KindUnderline.
+func (*UnderlineNode) Kind() NodeKind {
+	return KindUnderline
+}
+
+// Kind returns KindItalics.
+func (*ItalicsNode) Kind() NodeKind {
+	return KindItalics
+}
+
+// Kind returns KindStrikethrough.
+func (*StrikethroughNode) Kind() NodeKind {
+	return KindStrikethrough
+}
+
+// Kind returns KindHighlight.
+func (*HighlightNode) Kind() NodeKind {
+	return KindHighlight
+}
+
+// Kind returns KindLineBreak.
+func (*LineBreakNode) Kind() NodeKind {
+	return KindLineBreak
+}
+
+// Kind returns KindParagraph.
+func (*ParagraphNode) Kind() NodeKind {
+	return KindParagraph
+}
+
+// Kind returns KindNamedEmoji.
+func (*NamedEmojiNode) Kind() NodeKind {
+	return KindNamedEmoji
+}
@@ -0,0 +1,109 @@
+package formatting
+
+import "strings"
+
+// languageAliases maps code block language aliases to their canonical name, following highlight.js.
+var languageAliases = map[string]string{
+	"apacheconf":    "apache",
+	"atom":          "xml",
+	"c++":           "cpp",
+	"cc":            "cpp",
+	"cjs":           "javascript",
+	"clj":           "clojure",
+	"coffee":        "coffeescript",
+	"console":       "shell",
+	"cs":            "csharp",
+	"cson":          "coffeescript",
+	"cts":           "typescript",
+	"cxx":           "cpp",
+	"docker":        "dockerfile",
+	"edn":           "clojure",
+	"erl":           "erlang",
+	"ex":            "elixir",
+	"exs":           "elixir",
+	"fs":            "fsharp",
+	"fsi":           "fsharp",
+	"fsscript":      "fsharp",
+	"fsx":           "fsharp",
+	"gemspec":       "ruby",
+	"golang":        "go",
+	"gql":           "graphql",
+	"gyp":           "python",
+	"h":             "c",
+	"h++":           "cpp",
+	"hh":            "cpp",
+	"hpp":           "cpp",
+	"hs":            "haskell",
+	"html":          "xml",
+	"https":         "http",
+	"hxx":           "cpp",
+	"iced":          "coffeescript",
+	"ipython":       "python",
+	"irb":           "ruby",
+	"js":            "javascript",
+	"jsx":           "javascript",
+	"kt":            "kotlin",
+	"kts":           "kotlin",
+	"mak":           "makefile",
+	"make":          "makefile",
+	"md":            "markdown",
+	"mjs":           "javascript",
+	"mk":            "makefile",
+	"mkd":           "markdown",
+	"mkdown":        "markdown",
+	"ml":            "ocaml",
+	"mm":            "objectivec",
+	"mts":           "typescript",
+	"nginxconf":     "nginx",
+	"obj-c":         "objectivec",
+	"obj-c++":       "objectivec",
+	"objc":          "objectivec",
+	"objective-c++": "objectivec",
+	"patch":         "diff",
+	"php3":          "php",
+	"php4":          "php",
+	"php5":          "php",
+	"php6":          "php",
+	"php7":          "php",
+	"php8":          "php",
+	"pl":            "perl",
+	"pm":            "perl",
+	"podspec":       "ruby",
+	"postgres":      "pgsql",
+	"postgresql":    "pgsql",
+	"proto":         "protobuf",
+	"ps":            "powershell",
+	"ps1":           "powershell",
+	"py":            "python",
+	"rb":            "ruby",
+	"rs":            "rust",
+	"rss":           "xml",
+	"sh":            "bash",
+	"shellsession":  "shell",
+	"svg":           "xml",
+	"tex":           "latex",
+	"text":          "plaintext",
+	"thor":          "ruby",
+	"toml":          "ini",
+	"ts":            "typescript",
+	"tsx":           "typescript",
+	"txt":           "plaintext",
+	"vb":            "vbnet",
+	"xhtml":         "xml",
+	"yml":           "yaml",
+	"zsh":           "bash",
+}
+
+/*
+NormalizeLanguage returns the canonical name of a code block language, such as javascript for js,
+the way Discord (through highlight.js) resolves language aliases.
+
+The language is lowercased. Languages that are not known aliases are returned lowercased but otherwise unchanged.
+*/
+func NormalizeLanguage(language string) string {
+	language = strings.ToLower(language)
+	if canonical, ok := languageAliases[language]; ok {
+		return canonical
+	}
+	return language
+}
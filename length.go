@@ -0,0 +1,72 @@
+package formatting
+
+import "unicode/utf8"
+
+/*
+WidthPolicy defines the visible width of the nodes whose display depends on the client, used by VisibleLength.
+
+Width is called for every node that is not a TextNode or a CodeNode and that has a text as defined by Text:
+links, emoji, mentions, timestamps and unknown tags. It returns the number of characters the node takes when displayed,
+for example the length of the resolved user name of a UserMentionNode.
+*/
+type WidthPolicy interface {
+	Width(n Node) int
+}
+
+/*
+WidthFunc is an adapter to allow the use of ordinary functions as a WidthPolicy.
+*/
+type WidthFunc func(n Node) int
+
+/*
+Width calls f(n).
+*/
+func (f WidthFunc) Width(n Node) int {
+	return f(n)
+}
+
+/*
+DefaultWidthPolicy is the WidthPolicy used by VisibleLength when none is passed.
+
+Emoji are displayed as images and count as a single character. Other nodes count as the number of characters
+of their text as defined by Text, for example @1234 for a user mention.
+*/
+var DefaultWidthPolicy WidthPolicy = WidthFunc(func(n Node) int {
+	if _, ok := n.(*EmojiNode); ok {
+		return 1
+	}
+	return utf8.RuneCountInString(nodeText(n))
+})
+
+/*
+VisibleLength returns the number of characters of the passed tree as displayed, excluding formatting markers,
+for example for truncating a message preview or checking it against a length limit.
+
+Text and code count as their number of characters; the other nodes, such as mentions and emoji, count as
+defined by the passed policy. The policy parameter can be nil, which is equivalent to passing DefaultWidthPolicy.
+*/
+func VisibleLength(n Node, policy WidthPolicy) int {
+	if policy == nil {
+		policy = DefaultWidthPolicy
+	}
+	length := 0
+	Walk(n, func(n Node, entering bool) {
+		if !entering {
+			if n, ok := n.(*BulletListNode); ok && n.IncludesNewline {
+				length++
+			}
+			return
+		}
+		switch n := n.(type) {
+		case *TextNode:
+			length += utf8.RuneCountInString(n.Content)
+		case *CodeNode:
+			length += utf8.RuneCountInString(n.Content)
+		default:
+			if nodeText(n) != "" {
+				length += policy.Width(n)
+			}
+		}
+	})
+	return length
+}
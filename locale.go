@@ -0,0 +1,279 @@
+package formatting
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Locale contains the localized names, layouts and phrases used for formatting timestamps with FormatTimestamp.
+
+Layouts are made of the following tokens, other text being copied as is. Text between square brackets
+is copied as is, without the brackets.
+
+	YYYY  year             2026
+	MMMM  month name       October
+	MM    month, padded    10
+	M     month            10
+	DD    day, padded      06
+	D     day              6
+	dddd  weekday name     Friday
+	HH    24-hour, padded  16
+	H     24-hour          16
+	hh    12-hour, padded  04
+	h     12-hour          4
+	mm    minute, padded   20
+	ss    second, padded   30
+	A     AM or PM         PM
+
+Several locales are provided, such as LocaleEnglishUS. Custom locales can be defined as well.
+*/
+type Locale struct {
+	// Months are the names of the months, starting from January.
+	Months [12]string
+	// Weekdays are the names of the days of the week, starting from Sunday.
+	Weekdays [7]string
+	// AM and PM are the day period markers of 12-hour clocks.
+	AM string
+	PM string
+
+	// ShortTime is the layout of TimestampShortTime, and so on for the other styles.
+	ShortTime     string
+	LongTime      string
+	ShortDate     string
+	LongDate      string
+	ShortDateTime string
+	LongDateTime  string
+
+	// Now is the relative time phrase for the current time.
+	Now string
+	// Past and Future are the relative time phrases for past and future times, with a %s verb for the duration.
+	Past   string
+	Future string
+	// Units are the singular and plural names of the relative time units: years, months, days, hours, minutes, seconds.
+	Units [6][2]string
+}
+
+/*
+LocaleEnglishUS is the English (United States) locale. This is the default locale.
+*/
+var LocaleEnglishUS = Locale{
+	Months:        [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	Weekdays:      [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+	AM:            "AM",
+	PM:            "PM",
+	ShortTime:     "h:mm A",
+	LongTime:      "h:mm:ss A",
+	ShortDate:     "MM/DD/YYYY",
+	LongDate:      "MMMM D, YYYY",
+	ShortDateTime: "MMMM D, YYYY h:mm A",
+	LongDateTime:  "dddd, MMMM D, YYYY h:mm A",
+	Now:           "now",
+	Past:          "%s ago",
+	Future:        "in %s",
+	Units:         [6][2]string{{"year", "years"}, {"month", "months"}, {"day", "days"}, {"hour", "hours"}, {"minute", "minutes"}, {"second", "seconds"}},
+}
+
+/*
+LocaleEnglishGB is the English (United Kingdom) locale.
+*/
+var LocaleEnglishGB = Locale{
+	Months:        LocaleEnglishUS.Months,
+	Weekdays:      LocaleEnglishUS.Weekdays,
+	AM:            "am",
+	PM:            "pm",
+	ShortTime:     "HH:mm",
+	LongTime:      "HH:mm:ss",
+	ShortDate:     "DD/MM/YYYY",
+	LongDate:      "D MMMM YYYY",
+	ShortDateTime: "D MMMM YYYY HH:mm",
+	LongDateTime:  "dddd, D MMMM YYYY HH:mm",
+	Now:           LocaleEnglishUS.Now,
+	Past:          LocaleEnglishUS.Past,
+	Future:        LocaleEnglishUS.Future,
+	Units:         LocaleEnglishUS.Units,
+}
+
+/*
+LocaleFrench is the French locale.
+*/
+var LocaleFrench = Locale{
+	Months:        [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	Weekdays:      [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+	AM:            "AM",
+	PM:            "PM",
+	ShortTime:     "HH:mm",
+	LongTime:      "HH:mm:ss",
+	ShortDate:     "DD/MM/YYYY",
+	LongDate:      "D MMMM YYYY",
+	ShortDateTime: "D MMMM YYYY HH:mm",
+	LongDateTime:  "dddd D MMMM YYYY HH:mm",
+	Now:           "maintenant",
+	Past:          "il y a %s",
+	Future:        "dans %s",
+	Units:         [6][2]string{{"an", "ans"}, {"mois", "mois"}, {"jour", "jours"}, {"heure", "heures"}, {"minute", "minutes"}, {"seconde", "secondes"}},
+}
+
+/*
+LocaleGerman is the German locale.
+*/
+var LocaleGerman = Locale{
+	Months:        [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+	Weekdays:      [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+	AM:            "AM",
+	PM:            "PM",
+	ShortTime:     "HH:mm",
+	LongTime:      "HH:mm:ss",
+	ShortDate:     "DD.MM.YYYY",
+	LongDate:      "D. MMMM YYYY",
+	ShortDateTime: "D. MMMM YYYY HH:mm",
+	LongDateTime:  "dddd, D. MMMM YYYY HH:mm",
+	Now:           "jetzt",
+	Past:          "vor %s",
+	Future:        "in %s",
+	Units:         [6][2]string{{"Jahr", "Jahren"}, {"Monat", "Monaten"}, {"Tag", "Tagen"}, {"Stunde", "Stunden"}, {"Minute", "Minuten"}, {"Sekunde", "Sekunden"}},
+}
+
+/*
+LocaleSpanish is the Spanish (Spain) locale.
+*/
+var LocaleSpanish = Locale{
+	Months:        [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+	Weekdays:      [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+	AM:            "a. m.",
+	PM:            "p. m.",
+	ShortTime:     "H:mm",
+	LongTime:      "H:mm:ss",
+	ShortDate:     "DD/MM/YYYY",
+	LongDate:      "D [de] MMMM [de] YYYY",
+	ShortDateTime: "D [de] MMMM [de] YYYY H:mm",
+	LongDateTime:  "dddd, D [de] MMMM [de] YYYY H:mm",
+	Now:           "ahora",
+	Past:          "hace %s",
+	Future:        "dentro de %s",
+	Units:         [6][2]string{{"año", "años"}, {"mes", "meses"}, {"día", "días"}, {"hora", "horas"}, {"minuto", "minutos"}, {"segundo", "segundos"}},
+}
+
+/*
+Locales maps Discord locale codes, such as en-US, to their Locale.
+*/
+var Locales = map[string]*Locale{
+	"en-US":  &LocaleEnglishUS,
+	"en-GB":  &LocaleEnglishGB,
+	"fr":     &LocaleFrench,
+	"de":     &LocaleGerman,
+	"es-ES":  &LocaleSpanish,
+	"es-419": &LocaleSpanish,
+}
+
+// layoutTokens are the layout tokens, ordered so that longer tokens are matched first.
+var layoutTokens = []string{"YYYY", "MMMM", "MM", "M", "DD", "D", "dddd", "HH", "H", "hh", "h", "mm", "ss", "A"}
+
+func (l *Locale) layout(style TimestampStyle) string {
+	switch style {
+	case TimestampShortTime:
+		return l.ShortTime
+	case TimestampLongTime:
+		return l.LongTime
+	case TimestampShortDate:
+		return l.ShortDate
+	case TimestampLongDate:
+		return l.LongDate
+	case TimestampLongDateTime:
+		return l.LongDateTime
+	default:
+		return l.ShortDateTime
+	}
+}
+
+func (l *Locale) format(t time.Time, layout string) string {
+	var sb strings.Builder
+	for len(layout) > 0 {
+		if layout[0] == '[' {
+			if end := strings.IndexByte(layout, ']'); end >= 0 {
+				sb.WriteString(layout[1:end])
+				layout = layout[end+1:]
+				continue
+			}
+		}
+		token := ""
+		for _, tok := range layoutTokens {
+			if strings.HasPrefix(layout, tok) {
+				token = tok
+				break
+			}
+		}
+		if token == "" {
+			sb.WriteByte(layout[0])
+			layout = layout[1:]
+			continue
+		}
+		layout = layout[len(token):]
+		hour12 := t.Hour() % 12
+		if hour12 == 0 {
+			hour12 = 12
+		}
+		switch token {
+		case "YYYY":
+			sb.WriteString(fmt.Sprintf("%d", t.Year()))
+		case "MMMM":
+			sb.WriteString(l.Months[t.Month()-1])
+		case "MM":
+			sb.WriteString(fmt.Sprintf("%02d", t.Month()))
+		case "M":
+			sb.WriteString(fmt.Sprintf("%d", t.Month()))
+		case "DD":
+			sb.WriteString(fmt.Sprintf("%02d", t.Day()))
+		case "D":
+			sb.WriteString(fmt.Sprintf("%d", t.Day()))
+		case "dddd":
+			sb.WriteString(l.Weekdays[t.Weekday()])
+		case "HH":
+			sb.WriteString(fmt.Sprintf("%02d", t.Hour()))
+		case "H":
+			sb.WriteString(fmt.Sprintf("%d", t.Hour()))
+		case "hh":
+			sb.WriteString(fmt.Sprintf("%02d", hour12))
+		case "h":
+			sb.WriteString(fmt.Sprintf("%d", hour12))
+		case "mm":
+			sb.WriteString(fmt.Sprintf("%02d", t.Minute()))
+		case "ss":
+			sb.WriteString(fmt.Sprintf("%02d", t.Second()))
+		case "A":
+			if t.Hour() < 12 {
+				sb.WriteString(l.AM)
+			} else {
+				sb.WriteString(l.PM)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func (l *Locale) relative(seconds int64) string {
+	if seconds == 0 {
+		return l.Now
+	}
+	abs := seconds
+	if abs < 0 {
+		abs = -abs
+	}
+	for i, unit := range relativeUnits {
+		if abs < unit {
+			continue
+		}
+		value := abs / unit
+		name := l.Units[i][1]
+		if value == 1 {
+			name = l.Units[i][0]
+		}
+		text := fmt.Sprintf("%d %s", value, name)
+		if seconds < 0 {
+			return fmt.Sprintf(l.Past, text)
+		}
+		return fmt.Sprintf(l.Future, text)
+	}
+	panic("unreachable: relative time is at least one second")
+}
@@ -0,0 +1,285 @@
+package formatting
+
+import "strings"
+
+/*
+MarkdownOptions is a configuration object used for serializing an AST back to a message with RenderMarkdown.
+
+An empty MarkdownOptions, or passing nil instead, is the default configuration.
+*/
+type MarkdownOptions struct {
+	// MinimalEscaping, if set, only escapes the characters of text nodes that would otherwise be displayed
+	// as formatting, for example not escaping a lone *, instead of escaping all characters like Escape.
+	MinimalEscaping bool
+	// Parser is the configuration of the parser the message is meant for, used to find the characters to escape
+	// when MinimalEscaping is set. If nil, DefaultParserOptions is used.
+	Parser *ParserOptions
+}
+
+/*
+RenderMarkdown serializes an AST back to a Discord message, which is parsed back to the same AST.
+
+The text of text nodes is escaped with Escape, or with EscapeMinimal if MinimalEscaping is set in the options.
+In that case, if the escaped text nodes are still interpreted as formatting in the context of their surrounding nodes,
+the whole message is serialized again with Escape instead.
+
+Escaped text nodes are serialized with their backslash escape, such as \*.
+Formatting is serialized with its original Delimiter if it is set, and with the usual Discord syntax otherwise.
+The masks and titles of masked links are escaped with backslashes.
+Code content that Discord cannot represent is not parsed back to the same AST: a zero-width space is inserted
+in each ``` of code blocks, and inline code containing backticks is split around escaped backticks.
+
+The options parameter can be nil, which is equivalent to passing an empty MarkdownOptions.
+*/
+func RenderMarkdown(n Node, options *MarkdownOptions) string {
+	if options == nil {
+		options = &MarkdownOptions{}
+	}
+	if !options.MinimalEscaping {
+		return renderMarkdown(n, Escape)
+	}
+	// escape adjacent text nodes together, as they could be formatting together
+	s := renderMarkdown(markdownRoot(n, false), func(text string) string {
+		return EscapeMinimal(text, options.Parser)
+	})
+	if Equal(markdownRoot(n, true), markdownRoot(NewParser(options.Parser).Parse(s), true)) {
+		return s
+	}
+	return renderMarkdown(n, Escape)
+}
+
+// markdownRoot returns a copy of n as a root node with merged text, to compare it to the parsed markdown of n.
+// If escapes is false, escaped text nodes are kept separate, so that they are serialized with their backslash.
+func markdownRoot(n Node, escapes bool) Node {
+	n = Clone(n)
+	if n.Kind() != KindRoot {
+		root := &node{}
+		root.self = root
+		root.AppendChild(n)
+		n = root
+	}
+	mergeText(n, escapes)
+	return n
+}
+
+func renderMarkdown(n Node, escape func(text string) string) string {
+	sb := &strings.Builder{}
+	// parents are the builders of the parents of the > block quotes and list items being rendered,
+	// whose lines are prefixed once rendered
+	var parents []*strings.Builder
+	enter := func() {
+		parents = append(parents, sb)
+		sb = &strings.Builder{}
+	}
+	leave := func() string {
+		content := sb.String()
+		sb = parents[len(parents)-1]
+		parents = parents[:len(parents)-1]
+		return content
+	}
+	Walk(n, func(n Node, entering bool) {
+		switch n := n.(type) {
+		case *TextNode:
+			if !entering {
+				break
+			}
+			if n.Escaped {
+				// keep the original escape if the content is still an escapable character
+				if m := scanEscape(nil, "\\"+n.Content, nil); m != nil && m[1] == len(n.Content)+1 {
+					sb.WriteString("\\" + n.Content)
+					break
+				}
+			}
+			sb.WriteString(escape(n.Content))
+		case *BlockQuoteNode:
+			if n.Delimiter != ">" {
+				if entering {
+					// >>> only starts a block quote at the start of a line
+					if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+						sb.WriteString("\n")
+					}
+					sb.WriteString(">>> ")
+				}
+			} else if entering {
+				enter()
+			} else {
+				sb.WriteString(prefixLines(leave(), "> ", "> "))
+			}
+		case *CodeNode:
+			if entering {
+				sb.WriteString(codeMarkdown(n))
+			}
+		case *SpoilerNode:
+			sb.WriteString("||")
+		case *URLNode:
+			if entering {
+				sb.WriteString(urlMarkdown(n))
+			}
+		case *EmojiNode:
+			if !entering {
+				break
+			}
+			if n.Animated {
+				sb.WriteString("<a:" + n.Text + ":" + n.ID + ">")
+			} else {
+				sb.WriteString("<:" + n.Text + ":" + n.ID + ">")
+			}
+		case *NamedEmojiNode:
+			if entering {
+				sb.WriteString(nodeText(n))
+			}
+		case *ChannelMentionNode:
+			if entering {
+				sb.WriteString("<#" + n.ID + ">")
+			}
+		case *RoleMentionNode:
+			if entering {
+				sb.WriteString("<@&" + n.ID + ">")
+			}
+		case *UserMentionNode:
+			if entering {
+				sb.WriteString("<@" + n.ID + ">")
+			}
+		case *SpecialMentionNode:
+			if entering {
+				sb.WriteString("@" + n.Mention)
+			}
+		case *TimestampNode, *UnknownTagNode:
+			if entering {
+				sb.WriteString(nodeText(n))
+			}
+		case *HeaderNode:
+			if entering {
+				if n.Marker != "" {
+					sb.WriteString(n.Marker)
+				} else {
+					sb.WriteString(strings.Repeat("#", n.Level) + " ")
+				}
+			}
+		case *BulletListNode:
+			if entering {
+				enter()
+				break
+			}
+			delimiter := n.Delimiter
+			if delimiter == "" {
+				delimiter = "-"
+			}
+			indent := ""
+			if n.NestedLevel > 1 {
+				indent = strings.Repeat("  ", n.NestedLevel-1)
+			}
+			// the next lines of the item are indented to continue it
+			sb.WriteString(prefixLines(leave(), indent+delimiter+" ", indent+"  "))
+			if n.IncludesNewline {
+				sb.WriteString("\n")
+			}
+		case *BoldNode:
+			sb.WriteString("**")
+		case *UnderlineNode:
+			sb.WriteString("__")
+		case *ItalicsNode:
+			if n.Delimiter == "_" {
+				sb.WriteString("_")
+			} else {
+				sb.WriteString("*")
+			}
+		case *StrikethroughNode:
+			sb.WriteString("~~")
+		case *LineBreakNode:
+			if entering {
+				sb.WriteString("  \n")
+			}
+		}
+	})
+	return sb.String()
+}
+
+func codeMarkdown(n *CodeNode) string {
+	if !n.Inline {
+		language := n.RawLanguage
+		if language == "" {
+			language = n.Language
+		}
+		return "```" + language + "\n" + breakFences(n.Content) + "\n```"
+	}
+	if strings.Contains(n.Content, "`") {
+		// inline code cannot contain backticks, keep them as escaped text between code spans
+		var sb strings.Builder
+		for i, part := range strings.Split(n.Content, "`") {
+			if i > 0 {
+				sb.WriteString("\\`")
+			}
+			if part != "" {
+				sb.WriteString("`" + part + "`")
+			}
+		}
+		return sb.String()
+	}
+	delimiter := n.Delimiter
+	if delimiter == "" {
+		delimiter = "`"
+	}
+	return delimiter + n.Content + delimiter
+}
+
+// breakFences returns content with a zero-width space inserted in each ```, which would otherwise close
+// the code block early, as Discord closes code blocks on the first ```.
+func breakFences(content string) string {
+	if !strings.Contains(content, "```") {
+		return content
+	}
+	var sb strings.Builder
+	run := 0
+	for i := 0; i < len(content); i++ {
+		if content[i] != '`' {
+			run = 0
+		} else if run++; run == 3 {
+			sb.WriteString("\u200b")
+			run = 1
+		}
+		sb.WriteByte(content[i])
+	}
+	return sb.String()
+}
+
+func urlMarkdown(n *URLNode) string {
+	url := n.URL
+	if n.Suppressed {
+		url = "<" + url + ">"
+	}
+	if n.Mask == "" {
+		return url
+	}
+	if strings.HasPrefix(n.URL, "mailto:") && n.Mask == strings.TrimPrefix(n.URL, "mailto:") {
+		return n.Mask
+	}
+	s := "[" + escapeLinkText(n.Mask, "\\[]") + "](" + url
+	if n.Title != "" {
+		s += " \"" + escapeLinkText(n.Title, "\\\"')") + "\""
+	}
+	return s + ")"
+}
+
+// escapeLinkText returns text with a backslash before each of characters, which could otherwise end
+// the mask or title of a masked link early.
+func escapeLinkText(text string, characters string) string {
+	if !strings.ContainsAny(text, characters) {
+		return text
+	}
+	var sb strings.Builder
+	sb.Grow(len(text) + 2)
+	for _, r := range text {
+		if strings.ContainsRune(characters, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// prefixLines returns content prefixed with first, and each of its next lines prefixed with next.
+func prefixLines(content string, first string, next string) string {
+	body := strings.TrimSuffix(content, "\n")
+	return first + strings.ReplaceAll(body, "\n", "\n"+next) + content[len(body):]
+}
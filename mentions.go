@@ -0,0 +1,105 @@
+package formatting
+
+/*
+AllowedMentions is the allowed_mentions object of the Discord API, which controls who is pinged by a message.
+It can be encoded to JSON as is.
+
+Parse contains the types of mentions pinged without restriction: AllowedMentionEveryone, AllowedMentionRoles or AllowedMentionUsers.
+Users and Roles contain the IDs of the users and roles that are pinged, if their type is not in Parse.
+*/
+type AllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+const (
+	// AllowedMentionEveryone allows @everyone and @here to ping.
+	AllowedMentionEveryone = "everyone"
+	// AllowedMentionRoles allows all role mentions to ping.
+	AllowedMentionRoles = "roles"
+	// AllowedMentionUsers allows all user mentions to ping.
+	AllowedMentionUsers = "users"
+)
+
+/*
+ComputeAllowedMentions returns the AllowedMentions that allow exactly the mentions of the passed message to ping,
+for example to send relayed content without pinging more than what is displayed as a mention.
+
+The users and roles mentioned in the message are listed in Users and Roles, without duplicates.
+If the message contains @everyone or @here, AllowedMentionEveryone is in Parse. Text that is not parsed as a mention,
+such as the content of code, never pings. Parse is never nil, so that nothing else is allowed when encoded to JSON.
+*/
+func ComputeAllowedMentions(n Node) *AllowedMentions {
+	mentions := &AllowedMentions{
+		Parse: []string{},
+	}
+	seen := make(map[string]bool)
+	Walk(n, func(n Node, entering bool) {
+		if !entering {
+			return
+		}
+		switch n := n.(type) {
+		case *UserMentionNode:
+			if !seen["@"+n.ID] {
+				seen["@"+n.ID] = true
+				mentions.Users = append(mentions.Users, n.ID)
+			}
+		case *RoleMentionNode:
+			if !seen["@&"+n.ID] {
+				seen["@&"+n.ID] = true
+				mentions.Roles = append(mentions.Roles, n.ID)
+			}
+		case *SpecialMentionNode:
+			if !seen[AllowedMentionEveryone] {
+				seen[AllowedMentionEveryone] = true
+				mentions.Parse = append(mentions.Parse, AllowedMentionEveryone)
+			}
+		}
+	})
+	return mentions
+}
+
+/*
+Resolver resolves the IDs of mentions to the names displayed by Discord, used by ResolveMentionsPass.
+
+Each method returns the name of the user, role or channel with the passed ID, without the @ or # prefix,
+or an empty string if it is unknown. UserName should return the display name of the user, for example their
+server nickname.
+*/
+type Resolver interface {
+	UserName(id string) string
+	RoleName(id string) string
+	ChannelName(id string) string
+}
+
+/*
+ResolveMentionsPass returns a Pass that replaces the mention nodes with a TextNode containing their display text,
+as displayed by Discord, such as @alice for a user, @moderators for a role, #general for a channel,
+and @everyone for special mentions.
+
+Mentions whose name is unknown to the resolver are replaced with the same text as in Discord:
+@unknown-user, @deleted-role and #unknown.
+*/
+func ResolveMentionsPass(r Resolver) Pass {
+	name := func(name string, unknown string) string {
+		if name == "" {
+			return unknown
+		}
+		return name
+	}
+	return ReplacePass(func(n Node) Node {
+		switch n := n.(type) {
+		case *UserMentionNode:
+			return &TextNode{Content: "@" + name(r.UserName(n.ID), "unknown-user")}
+		case *RoleMentionNode:
+			return &TextNode{Content: "@" + name(r.RoleName(n.ID), "deleted-role")}
+		case *ChannelMentionNode:
+			return &TextNode{Content: "#" + name(r.ChannelName(n.ID), "unknown")}
+		case *SpecialMentionNode:
+			return &TextNode{Content: "@" + n.Mention}
+		default:
+			return nil
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package formatting
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestComputeAllowedMentions(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	mentions := ComputeAllowedMentions(parser.Parse("<@1> <@!2> <@1> <@&3> `<@4> @everyone`"))
+	if b, err := json.Marshal(mentions); err != nil || string(b) != `{"parse":[],"roles":["3"],"users":["1","2"]}` {
+		t.Errorf("error computing allowed mentions: got %s (%v)", b, err)
+	}
+	mentions = ComputeAllowedMentions(parser.Parse("hi @here **@everyone**"))
+	if b, err := json.Marshal(mentions); err != nil || string(b) != `{"parse":["everyone"]}` {
+		t.Errorf("error computing allowed mentions: got %s (%v)", b, err)
+	}
+}
+
+type testResolver map[string]string
+
+func (r testResolver) UserName(id string) string {
+	return r["@"+id]
+}
+
+func (r testResolver) RoleName(id string) string {
+	return r["@&"+id]
+}
+
+func (r testResolver) ChannelName(id string) string {
+	return r["#"+id]
+}
+
+func TestResolveMentionsPass(t *testing.T) {
+	r := testResolver{
+		"@1":  "alice",
+		"@&2": "moderators",
+		"#3":  "general",
+	}
+	root := ResolveMentionsPass(r)(NewParser(nil).Parse("**<@1>** <@&2> <#3> <@4> <@&4> <#4> @here"))
+	want := `[[bold [text "@alice"]] [text " "] [text "@moderators"] [text " "] [text "#general"] [text " "] [text "@unknown-user"] [text " "] [text "@deleted-role"] [text " "] [text "#unknown"] [text " "] [text "@here"]]`
+	if got := Debug(root); got != want {
+		t.Errorf("error resolving mentions: want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,73 @@
+package formatting
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// normalizeNewlines returns source with its CRLF and CR line breaks replaced with LF, and the offsets in the returned
+// string of the LF of each replaced CRLF, after which the offsets of the returned string are shifted by one more byte.
+func normalizeNewlines(source string) (string, []int) {
+	var sb strings.Builder
+	sb.Grow(len(source))
+	var removed []int
+	for {
+		i := strings.IndexByte(source, '\r')
+		if i < 0 {
+			sb.WriteString(source)
+			return sb.String(), removed
+		}
+		sb.WriteString(source[:i])
+		if i+1 < len(source) && source[i+1] == '\n' {
+			removed = append(removed, sb.Len())
+		} else {
+			sb.WriteByte('\n')
+		}
+		source = source[i+1:]
+	}
+}
+
+// parseNormalized parses source like parse, after normalizing its line breaks, and maps the spans of the parsed nodes,
+// tokens and diagnostics back to offsets in source.
+func parseNormalized(ctx context.Context, source string, options *ParserOptions, rules *ruleSet, mode parseMode) (Node, []Diagnostic, error) {
+	normalized, removed := normalizeNewlines(source)
+	offset := func(o int) int {
+		return o + sort.SearchInts(removed, o)
+	}
+	span := func(s Span) Span {
+		if s.Start < 0 {
+			return s
+		}
+		return Span{Start: offset(s.Start), End: offset(s.End)}
+	}
+	mapSpans := func(n Node, entering bool) {
+		if entering {
+			n.setSpan(span(n.Span()))
+		}
+	}
+	events := mode.events
+	if events != nil {
+		mode.events = func(n Node, entering bool) {
+			mapSpans(n, entering)
+			events(n, entering)
+		}
+	}
+	if tokens := mode.tokens; tokens != nil {
+		mode.tokens = func(t Token) {
+			t.Span = span(t.Span)
+			for i := range t.Groups {
+				t.Groups[i] = span(t.Groups[i])
+			}
+			tokens(t)
+		}
+	}
+	root, diagnostics, err := parse(ctx, normalized, options, rules, mode)
+	if root != nil && events == nil {
+		Walk(root, mapSpans)
+	}
+	for i := range diagnostics {
+		diagnostics[i].Offset = offset(diagnostics[i].Offset)
+	}
+	return root, diagnostics, err
+}
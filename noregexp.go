@@ -0,0 +1,29 @@
+//go:build noregexp
+
+package formatting
+
+// With the noregexp build tag, the regexp package is not used, for targets where it is too large,
+// such as TinyGo on microcontrollers or small WebAssembly modules. The rules are only matched with their scanners,
+// which all the rules have, so messages are parsed as without the tag. Only the rules of ParserOptions.Matchers
+// can still use regular expressions, with their own package.
+
+// hasRegexp is whether the patterns of the rules can be run, which is not the case with the noregexp build tag.
+const hasRegexp = false
+
+// lazyRegexp is the source of a regular expression, which is never compiled with the noregexp build tag.
+type lazyRegexp struct {
+	expr string
+}
+
+func lazyCompile(expr string) *lazyRegexp {
+	return &lazyRegexp{expr: expr}
+}
+
+// FindStringSubmatchIndex never matches, as the patterns cannot be run.
+func (r *lazyRegexp) FindStringSubmatchIndex(s string) []int {
+	return nil
+}
+
+func (r *lazyRegexp) String() string {
+	return r.expr
+}
@@ -0,0 +1,31 @@
+//go:build noregexp
+
+package formatting
+
+import "testing"
+
+func TestNoRegexp(t *testing.T) {
+	for _, r := range parserRules {
+		if r.scan == nil {
+			t.Errorf("rule %s has no scanner, so it is disabled without the regexp package", r.name)
+		}
+	}
+	options := MessageParserOptions
+	options.EnableMaskedLinks = true
+	options.EnableUnknownTags = true
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"> **a**\n```go\nb```", `[[blockquote [bold [text "a"]] [text "\n"]] [code "go" "b"]]`},
+		{"<https://discord.gg/a> https://a.b", `[[url "" "https://discord.gg/a" invite "a" suppressed] [text " "] [url "" "https://a.b"]]`},
+		{"[a](https://a.b) a@b.c # a", `[[url "a" "https://a.b"] [text " "] [url "a@b.c" "mailto:a@b.c"] [text " "] [text "# a"]]`},
+		{"# a\n- b <id:home>", `[[header 1 [text "a"]] [text "\n"] [list 1 false [text "b "] [unknowntag "id" "home"]]]`},
+	}
+	p := NewParser(&options)
+	for _, test := range tests {
+		if got := Debug(p.Parse(test.source)); got != test.want {
+			t.Errorf("parsing %q: want %s, got %s", test.source, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package formatting
+
+import (
+	"html"
+	"strings"
+)
+
+/*
+ParseHTML parses an HTML fragment to an AST, for example to bridge Matrix messages to Discord by serializing the AST
+with RenderMarkdown. It supports the HTML subset used by the formatted_body of Matrix messages:
+
+  - b and strong, i and em, u, del, s and strike: formatting nodes
+  - code: inline code, and pre: code blocks, with the language of their language-* class
+  - blockquote: block quotes
+  - a: links, with their text as their Mask, or as text if their scheme is not in DiscordMaskedLinkSchemes
+  - span with a data-mx-spoiler attribute: spoilers
+  - h1 to h6: headers, li: list items, p and br: newlines
+
+Other elements are replaced with their content, except mx-reply elements, the fallback of Matrix replies, which are removed.
+Whitespace is collapsed outside of code blocks, as in HTML. The parser is lenient: unclosed elements are closed
+at the end of the fragment, a < that does not start a tag is kept as text, and it never fails.
+*/
+func ParseHTML(source string) Node {
+	root := NewRoot()
+	p := htmlParser{
+		stack: []htmlElement{{node: root}},
+	}
+	// text is the text read since the last tag, which may contain a < that does not start a tag
+	var text strings.Builder
+	for source != "" {
+		i := strings.IndexByte(source, '<')
+		if i < 0 {
+			text.WriteString(source)
+			break
+		}
+		text.WriteString(source[:i])
+		source = source[i:]
+		n, tag := p.tag(source)
+		if n == 0 {
+			text.WriteByte('<')
+			source = source[1:]
+			continue
+		}
+		if text.Len() > 0 {
+			p.text(html.UnescapeString(text.String()))
+			text.Reset()
+		}
+		if tag != nil {
+			tag()
+		}
+		source = source[n:]
+	}
+	if text.Len() > 0 {
+		p.text(html.UnescapeString(text.String()))
+	}
+	for len(p.stack) > 1 {
+		p.pop()
+	}
+	trimNewlines(root)
+	return root
+}
+
+// htmlVoidElements are the HTML elements that have no end tag.
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlAttribute is an attribute of an HTML start tag, with its value unescaped.
+type htmlAttribute struct {
+	name  string
+	value string
+}
+
+/*
+tag reads the tag, comment or declaration that source starts with. It returns the length of the tag,
+and the function handling it, which is nil for comments and declarations.
+The length is 0 if source does not start with a tag, in which case its < is text.
+*/
+func (p *htmlParser) tag(source string) (int, func()) {
+	switch {
+	case strings.HasPrefix(source, "<!--"):
+		if i := strings.Index(source[4:], "-->"); i >= 0 {
+			return 4 + i + 3, nil
+		}
+		return len(source), nil
+	case strings.HasPrefix(source, "<!"), strings.HasPrefix(source, "<?"):
+		if i := strings.IndexByte(source, '>'); i >= 0 {
+			return i + 1, nil
+		}
+		return len(source), nil
+	case strings.HasPrefix(source, "</"):
+		name := htmlName(source[2:])
+		i := strings.IndexByte(source, '>')
+		if name == "" || i < 0 {
+			return 0, nil
+		}
+		name = strings.ToLower(name)
+		return i + 1, func() { p.end(name) }
+	}
+	name := htmlName(source[1:])
+	if name == "" {
+		return 0, nil
+	}
+	var attrs []htmlAttribute
+	i := 1 + len(name)
+	name = strings.ToLower(name)
+	for {
+		for i < len(source) && (isSpaceByte(source[i]) || source[i] == '/') {
+			i++
+		}
+		if i >= len(source) {
+			return 0, nil
+		}
+		if source[i] == '>' {
+			break
+		}
+		j := i
+		for j < len(source) && !isSpaceByte(source[j]) && !strings.ContainsRune("=>/", rune(source[j])) {
+			j++
+		}
+		if j == i {
+			// a lone =, as in <a =b>
+			j++
+		}
+		attr := htmlAttribute{name: strings.ToLower(source[i:j])}
+		i = j
+		for i < len(source) && isSpaceByte(source[i]) {
+			i++
+		}
+		if i < len(source) && source[i] == '=' {
+			i++
+			for i < len(source) && isSpaceByte(source[i]) {
+				i++
+			}
+			if i < len(source) && (source[i] == '"' || source[i] == '\'') {
+				end := strings.IndexByte(source[i+1:], source[i])
+				if end < 0 {
+					return 0, nil
+				}
+				attr.value = source[i+1 : i+1+end]
+				i += 1 + end + 1
+			} else {
+				j := i
+				for j < len(source) && !isSpaceByte(source[j]) && source[j] != '>' {
+					j++
+				}
+				attr.value = source[i:j]
+				i = j
+			}
+			attr.value = html.UnescapeString(attr.value)
+		}
+		attrs = append(attrs, attr)
+	}
+	return i + 1, func() {
+		p.start(name, attrs)
+		if htmlVoidElements[name] {
+			p.end(name)
+		}
+	}
+}
+
+// htmlName returns the tag name that source starts with, or "" if it does not start with a tag name.
+func htmlName(source string) string {
+	if source == "" || !(source[0] >= 'a' && source[0] <= 'z' || source[0] >= 'A' && source[0] <= 'Z') {
+		return ""
+	}
+	i := 1
+	for i < len(source) && !isSpaceByte(source[i]) && source[i] != '/' && source[i] != '>' {
+		i++
+	}
+	return source[:i]
+}
+
+type htmlElement struct {
+	name string
+	// node is the node the content of the element is appended to.
+	node Node
+	// code and link are set for elements whose text content is collected into a CodeNode or URLNode.
+	code *CodeNode
+	link *URLNode
+	// text is the collected text content of code and link elements.
+	text *strings.Builder
+	// skip is set for elements whose content is removed.
+	skip bool
+	// newline is set for elements followed by a newline.
+	newline bool
+}
+
+type htmlParser struct {
+	stack []htmlElement
+}
+
+func (p *htmlParser) top() *htmlElement {
+	return &p.stack[len(p.stack)-1]
+}
+
+// collector returns the nearest element collecting text, if any.
+func (p *htmlParser) collector() *htmlElement {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if p.stack[i].text != nil {
+			return &p.stack[i]
+		}
+	}
+	return nil
+}
+
+// item returns the node of the nearest list item element, if any.
+func (p *htmlParser) item() *BulletListNode {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if p.stack[i].name == "li" {
+			if n, ok := p.stack[i].node.(*BulletListNode); ok {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+func (p *htmlParser) pre() bool {
+	c := p.collector()
+	return c != nil && c.code != nil && !c.code.Inline
+}
+
+func (p *htmlParser) start(name string, attrs []htmlAttribute) {
+	parent := p.top()
+	e := htmlElement{
+		name: name,
+		node: parent.node,
+		skip: parent.skip,
+	}
+	if e.skip {
+		p.stack = append(p.stack, e)
+		return
+	}
+	if c := p.collector(); c != nil {
+		// formatting inside code and links is not supported, keep the text only
+		if name == "code" && c.code != nil {
+			c.code.Language = htmlLanguage(attrs)
+			c.code.RawLanguage = c.code.Language
+		} else if name == "br" {
+			c.text.WriteString("\n")
+		}
+		p.stack = append(p.stack, e)
+		return
+	}
+	var n Node
+	switch name {
+	case "b", "strong":
+		n = &BoldNode{}
+	case "i", "em":
+		n = &ItalicsNode{}
+	case "u":
+		n = &UnderlineNode{}
+	case "del", "s", "strike":
+		n = &StrikethroughNode{}
+	case "blockquote":
+		n = NewBlockQuote()
+		e.newline = true
+	case "span":
+		if htmlAttr(attrs, "data-mx-spoiler") != nil {
+			n = &SpoilerNode{}
+		}
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(name[1] - '0')
+		if level > 3 {
+			level = 3
+		}
+		n = NewHeader(level)
+		e.newline = true
+	case "li":
+		item := NewListItem()
+		for _, o := range p.stack {
+			if o.name == "li" {
+				item.NestedLevel++
+			}
+		}
+		if outer := p.item(); outer != nil {
+			// list items are not nested in the AST, the nested item follows its outer item
+			outer.Parent().AppendChild(item)
+			e.node = item
+			p.stack = append(p.stack, e)
+			return
+		}
+		n = item
+	case "ul", "ol":
+		e.newline = p.item() == nil
+	case "p", "div":
+		e.newline = true
+	case "br":
+		parent.node.AppendChild(NewText("\n"))
+	case "code":
+		e.code = NewCode("")
+		e.text = &strings.Builder{}
+	case "pre":
+		e.code = NewCodeBlock("", "")
+		e.text = &strings.Builder{}
+		e.newline = true
+	case "a":
+		e.link = &URLNode{}
+		if href := htmlAttr(attrs, "href"); href != nil {
+			// links with other schemes, such as javascript:, are kept as text, as in Discord masked links
+			e.link.URL, _ = allowedURL(*href, DiscordMaskedLinkSchemes)
+		}
+		e.text = &strings.Builder{}
+	case "mx-reply":
+		e.skip = true
+	}
+	if n != nil {
+		parent.node.AppendChild(n)
+		e.node = n
+	}
+	p.stack = append(p.stack, e)
+}
+
+func (p *htmlParser) end(name string) {
+	for i := len(p.stack) - 1; i > 0; i-- {
+		if p.stack[i].name == name {
+			for len(p.stack) > i {
+				p.pop()
+			}
+			return
+		}
+	}
+}
+
+func (p *htmlParser) pop() {
+	e := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	if e.skip && !p.top().skip {
+		return
+	}
+	parent := p.top().node
+	switch {
+	case e.code != nil:
+		e.code.Content = e.text.String()
+		if !e.code.Inline {
+			e.code.Content = strings.TrimSuffix(e.code.Content, "\n")
+		}
+		parent.AppendChild(e.code)
+	case e.link != nil && e.link.URL == "":
+		if text := e.text.String(); text != "" {
+			parent.AppendChild(NewText(text))
+		}
+	case e.link != nil:
+		if text := e.text.String(); text != e.link.URL {
+			e.link.Mask = text
+		}
+		parent.AppendChild(e.link)
+	}
+	if e.newline {
+		if c := parent.Children(); len(c) == 0 || !endsWithNewline(c[len(c)-1]) {
+			parent.AppendChild(NewText("\n"))
+		}
+	}
+}
+
+func (p *htmlParser) text(text string) {
+	if p.top().skip {
+		return
+	}
+	c := p.collector()
+	if !p.pre() {
+		text = collapseSpace(text)
+		if c == nil && lineStart(p.top().node) {
+			text = strings.TrimLeft(text, " ")
+		}
+		if text == "" {
+			return
+		}
+	}
+	if c != nil {
+		c.text.WriteString(text)
+		return
+	}
+	p.top().node.AppendChild(NewText(text))
+}
+
+func htmlAttr(attrs []htmlAttribute, name string) *string {
+	for _, a := range attrs {
+		if a.name == name {
+			return &a.value
+		}
+	}
+	return nil
+}
+
+// htmlLanguage returns the language of a code element, from its language-* class.
+func htmlLanguage(attrs []htmlAttribute) string {
+	class := htmlAttr(attrs, "class")
+	if class == nil {
+		return ""
+	}
+	for _, c := range strings.Fields(*class) {
+		if strings.HasPrefix(c, "language-") {
+			return strings.TrimPrefix(c, "language-")
+		}
+	}
+	return ""
+}
+
+// collapseSpace replaces each run of whitespace of text with a single space, as displayed in HTML.
+func collapseSpace(text string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range text {
+		switch r {
+		case ' ', '\t', '\n', '\r', '\f':
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if space {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+// lineStart returns whether the content appended to n is at the start of a line.
+func lineStart(n Node) bool {
+	for n != nil {
+		c := n.Children()
+		if len(c) > 0 {
+			return endsWithNewline(c[len(c)-1])
+		}
+		if n.Kind() != KindRoot && n.Kind() != KindBlockQuote && n.Kind() != KindHeader && n.Kind() != KindBulletList {
+			n = n.Parent()
+			continue
+		}
+		return true
+	}
+	return true
+}
+
+func endsWithNewline(n Node) bool {
+	switch n := n.(type) {
+	case *TextNode:
+		return strings.HasSuffix(n.Content, "\n")
+	case *BulletListNode:
+		return n.IncludesNewline
+	default:
+		return false
+	}
+}
+
+// trimNewlines removes the newlines ending the last children of n.
+func trimNewlines(n Node) {
+	for {
+		c := n.Children()
+		if len(c) == 0 {
+			return
+		}
+		t, ok := c[len(c)-1].(*TextNode)
+		if !ok {
+			return
+		}
+		t.Content = strings.TrimRight(t.Content, "\n")
+		if t.Content != "" {
+			return
+		}
+		n.RemoveChild(t)
+	}
+}
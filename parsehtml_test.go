@@ -0,0 +1,33 @@
+package formatting
+
+import "testing"
+
+func TestParseHTML(t *testing.T) {
+	for text, want := range map[string]string{
+		"hello &amp; <b>world</b>":                            `[[text "hello & "] [bold [text "world"]]]`,
+		"<strong>a <em>b</em></strong> <u>c</u> <del>d</del>": `[[bold [text "a "] [italics [text "b"]]] [text " "] [underline [text "c"]] [text " "] [strikethrough [text "d"]]]`,
+		"a<br>b<br/>c":          `[[text "a"] [text "\n"] [text "b"] [text "\n"] [text "c"]]`,
+		"<p>a</p>\n<p>b</p>":    `[[text "a"] [text "\n"] [text "b"]]`,
+		"<code>x &lt; y</code>": `[[code "" "x < y"]]`,
+		"<pre><code class=\"language-go\">a\n  b\n</code></pre>":  `[[code "go" "a\n  b"]]`,
+		"<a href=\"https://example.com\">link <b>text</b></a>":    `[[url "link text" "https://example.com"]]`,
+		"<a href=\"https://example.com\">https://example.com</a>": `[[url "" "https://example.com"]]`,
+		"<span data-mx-spoiler=\"reason\">secret</span>":          `[[spoiler [text "secret"]]]`,
+		"<blockquote>quote</blockquote>reply":                     `[[blockquote [text "quote"]] [text "\n"] [text "reply"]]`,
+		"<mx-reply><blockquote>old</blockquote></mx-reply>new":    `[[text "new"]]`,
+		"<h1>title</h1><ul><li>a</li><li>b</li></ul>":             `[[header 1 [text "title"]] [text "\n"] [list 1 true [text "a"]] [list 1 true [text "b"]]]`,
+		"<b>unclosed <i>tags":                                     `[[bold [text "unclosed "] [italics [text "tags"]]]]`,
+		"<font color=\"red\">colored</font>":                      `[[text "colored"]]`,
+		"1 < 2 and <b>bold</b> after":                             `[[text "1 < 2 and "] [bold [text "bold"]] [text " after"]]`,
+		"a <3 b <b>c</b>":                                         `[[text "a <3 b "] [bold [text "c"]]]`,
+		"<a href=https://example.com>x</a> tail":                  `[[url "x" "https://example.com"] [text " tail"]]`,
+		"<a href=\"javascript:alert(1)\">x</a>":                   `[[text "x"]]`,
+		"<b>a<i>b</b>c</i> d":                                     `[[bold [text "a"] [italics [text "b"]]] [text "c"] [text " d"]]`,
+		"a<!-- comment -->b <B CLASS='x'>c</B> &lt;&#39;":         `[[text "a"] [text "b "] [bold [text "c"]] [text " <'"]]`,
+		"<ul><li>a<ul><li>b</li></ul></li><li>c</li></ul>":        `[[list 1 true [text "a"]] [list 2 true [text "b"]] [list 1 true [text "c"]]]`,
+	} {
+		if got := Debug(ParseHTML(text)); got != want {
+			t.Errorf("error parsing %q: want %s, got %s", text, want, got)
+		}
+	}
+}
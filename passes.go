@@ -0,0 +1,420 @@
+package formatting
+
+import "strings"
+
+/*
+MergeText merges the adjacent TextNode siblings of the passed tree, in place, into a single TextNode.
+
+The parser often splits text into several nodes, for example around punctuation or escapes,
+as in "*", "hi", "*" for \*hi\*. Merging them simplifies processing the text and reduces the node count.
+The merged node has the span of all the merged nodes, and is not Escaped. Text nodes with attributes are never merged.
+
+MergeText can also be applied by the parser directly by setting ParserOptions.MergeText.
+*/
+func MergeText(n Node) {
+	mergeText(n, true)
+}
+
+// mergeText merges the adjacent TextNode siblings of the passed tree, like MergeText,
+// keeping escaped text nodes separate if escapes is false.
+func mergeText(n Node, escapes bool) {
+	preorder(n, func(n Node) []Node {
+		mergeChildren(n, escapes)
+		return n.Children()
+	})
+}
+
+// mergeChildren merges the adjacent TextNode children of n, like mergeText, without merging the text of its descendants.
+func mergeChildren(n Node, escapes bool) {
+	children := n.Children()
+	merged := make([]Node, 0, len(children))
+	var last *TextNode
+	for _, c := range children {
+		t, ok := c.(*TextNode)
+		if !ok || t.attrs != nil || !escapes && t.Escaped {
+			last = nil
+			merged = append(merged, c)
+			continue
+		}
+		if last == nil {
+			last = t
+			merged = append(merged, t)
+			continue
+		}
+		last.Content += t.Content
+		last.Escaped = false
+		last.span.End = t.span.End
+	}
+	if len(merged) != len(children) {
+		n.SetChildren(merged)
+	}
+}
+
+// preorder calls visit on each node of the tree rooted at n, parents before children, iteratively with an explicit stack
+// so that deep trees cannot overflow the goroutine stack. visit can modify the children of the node it is passed,
+// and returns the children to visit next.
+func preorder(n Node, visit func(n Node) []Node) {
+	stack := []Node{n}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		children := visit(n)
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, children[i])
+		}
+	}
+}
+
+/*
+Pass is a transformation of an AST. It can modify the passed tree in place and return it, or return a new tree.
+
+Passes can be chained with a Pipeline. Several built-in passes are provided, such as MergeTextPass.
+*/
+type Pass func(n Node) Node
+
+/*
+Pipeline is a list of passes, applied in order by Apply.
+*/
+type Pipeline []Pass
+
+/*
+Apply applies the passes of the Pipeline in order to the passed tree, each pass receiving the tree returned
+by the previous pass, and returns the tree returned by the last pass.
+*/
+func (p Pipeline) Apply(n Node) Node {
+	for _, pass := range p {
+		n = pass(n)
+	}
+	return n
+}
+
+/*
+MergeTextPass is a Pass that merges adjacent TextNode siblings, with MergeText.
+*/
+var MergeTextPass Pass = func(n Node) Node {
+	MergeText(n)
+	return n
+}
+
+/*
+RemoveEmptyTextPass is a Pass that removes TextNode nodes with an empty Content, such as the ones parsed from soft hyphens
+or from invisible characters with ParserOptions.StripInvisibleCharacters.
+*/
+var RemoveEmptyTextPass = FilterPass(func(n Node) bool {
+	t, ok := n.(*TextNode)
+	return !ok || t.Content != ""
+})
+
+/*
+FilterPass returns a Pass that removes the nodes for which keep returns false, along with their children.
+The root node is never removed.
+*/
+func FilterPass(keep func(n Node) bool) Pass {
+	filter := func(n Node) []Node {
+		children := n.Children()
+		kept := make([]Node, 0, len(children))
+		for _, c := range children {
+			if keep(c) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) != len(children) {
+			n.SetChildren(kept)
+		}
+		return kept
+	}
+	return func(n Node) Node {
+		preorder(n, filter)
+		return n
+	}
+}
+
+/*
+UnwrapPass returns a Pass that replaces the nodes of the passed kinds with their children,
+for example to remove spoilers but keep their content with UnwrapPass(KindSpoiler).
+The root node is never unwrapped.
+*/
+func UnwrapPass(kinds ...NodeKind) Pass {
+	unwrap := make(map[NodeKind]bool, len(kinds))
+	for _, k := range kinds {
+		unwrap[k] = true
+	}
+	return func(n Node) Node {
+		preorder(n, func(n Node) []Node {
+			result := flatten(n, func(c Node) bool {
+				return unwrap[c.Kind()]
+			}, nil)
+			n.SetChildren(result)
+			return result
+		})
+		return n
+	}
+}
+
+// flatten returns the children of n, with the nodes for which unwrap returns true replaced with their own flattened
+// children. If after is not nil, the nodes it returns for an unwrapped node are added after its children.
+func flatten(n Node, unwrap func(c Node) bool, after func(c Node) Node) []Node {
+	var result []Node
+	// stack contains the nodes to add to the result, the next node last
+	var stack []Node
+	push := func(nodes []Node) {
+		for i := len(nodes) - 1; i >= 0; i-- {
+			stack = append(stack, nodes[i])
+		}
+	}
+	push(n.Children())
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !unwrap(c) {
+			result = append(result, c)
+			continue
+		}
+		if after != nil {
+			if a := after(c); a != nil {
+				stack = append(stack, a)
+			}
+		}
+		push(c.Children())
+	}
+	return result
+}
+
+/*
+RedactSpoilersPass returns a Pass that replaces the content of each SpoilerNode with a TextNode containing placeholder,
+such as [spoiler], for outputs that cannot hide spoilers, such as IRC or notifications.
+
+If placeholder is empty, the content is replaced with block characters (█) instead, one per visible character
+of the content as counted by VisibleLength.
+*/
+func RedactSpoilersPass(placeholder string) Pass {
+	return func(n Node) Node {
+		for _, s := range FindAll[*SpoilerNode](n) {
+			text := placeholder
+			if text == "" {
+				text = strings.Repeat("█", VisibleLength(s, nil))
+			}
+			s.SetChildren([]Node{&TextNode{Content: text}})
+		}
+		return n
+	}
+}
+
+/*
+StripFormattingPass is a Pass that replaces all the formatting nodes, such as BoldNode, BlockQuoteNode or BulletListNode,
+with their children, leaving only the leaves of the tree: text, code, links, emoji, mentions, timestamps and unknown tags.
+
+The newline ending a list item is kept as a TextNode, so that the items are still displayed on separate lines.
+*/
+var StripFormattingPass Pass = func(n Node) Node {
+	n.SetChildren(flatten(n, func(c Node) bool {
+		switch c.(type) {
+		case *BlockQuoteNode, *SpoilerNode, *HeaderNode, *BoldNode, *UnderlineNode, *ItalicsNode, *StrikethroughNode, *HighlightNode, *ParagraphNode, *BulletListNode:
+			return true
+		default:
+			return false
+		}
+	}, func(c Node) Node {
+		if l, ok := c.(*BulletListNode); ok && l.IncludesNewline {
+			return &TextNode{Content: "\n"}
+		}
+		return nil
+	}))
+	return n
+}
+
+/*
+ParagraphPass is a Pass that groups the inline content between blank lines and block nodes into ParagraphNode nodes,
+in the root node and in block quotes, for renderers emitting paragraphs, such as RenderHTML.
+
+Block nodes are block quotes, code blocks, headers and list items. The blank lines, and the line breaks between
+a paragraph and a block node, are kept as TextNode siblings of the paragraphs, so that Text and RenderMarkdown
+are unchanged.
+*/
+var ParagraphPass Pass = func(n Node) Node {
+	preorder(n, func(n Node) []Node {
+		paragraphs(n)
+		var quotes []Node
+		for _, c := range n.Children() {
+			if c.Kind() == KindBlockQuote {
+				quotes = append(quotes, c)
+			}
+		}
+		return quotes
+	})
+	return n
+}
+
+// paragraphs groups the inline children of n into paragraphs, as done by ParagraphPass, without grouping
+// the children of its block quotes.
+func paragraphs(n Node) {
+	var result, run []Node
+	flush := func() {
+		result = append(result, paragraph(run)...)
+		run = nil
+	}
+	// blank lines can be split across several text nodes
+	children := n.Children()
+	merged := make([]Node, 0, len(children))
+	var lastText *TextNode
+	for _, c := range children {
+		t, ok := c.(*TextNode)
+		if !ok || t.attrs != nil {
+			lastText = nil
+			merged = append(merged, c)
+			continue
+		}
+		if lastText == nil {
+			lastText = &TextNode{Content: t.Content}
+			lastText.setSpan(t.Span())
+			merged = append(merged, lastText)
+			continue
+		}
+		lastText.Content += t.Content
+		lastText.span.End = t.span.End
+	}
+	for _, c := range merged {
+		switch c := c.(type) {
+		case *BlockQuoteNode:
+			flush()
+			result = append(result, c)
+			continue
+		case *HeaderNode, *BulletListNode:
+			flush()
+			result = append(result, c)
+			continue
+		case *CodeNode:
+			if !c.Inline {
+				flush()
+				result = append(result, c)
+				continue
+			}
+		case *TextNode:
+			// split the text at blank lines
+			start := 0
+			for _, blank := range blankLines(c.Content) {
+				if blank.Start > start {
+					run = append(run, textPart(c, start, blank.Start))
+				}
+				flush()
+				result = append(result, textPart(c, blank.Start, blank.End))
+				start = blank.End
+			}
+			if start == 0 {
+				run = append(run, c)
+			} else if start < len(c.Content) {
+				run = append(run, textPart(c, start, len(c.Content)))
+			}
+			continue
+		}
+		run = append(run, c)
+	}
+	flush()
+	n.SetChildren(result)
+}
+
+// paragraph returns the nodes of a run of inline nodes wrapped in a ParagraphNode,
+// with the line breaks starting and ending the run kept outside of the paragraph.
+func paragraph(run []Node) []Node {
+	var result []Node
+	if len(run) == 0 {
+		return nil
+	}
+	if t, ok := run[0].(*TextNode); ok && strings.HasPrefix(t.Content, "\n") {
+		i := len(t.Content) - len(strings.TrimLeft(t.Content, "\n"))
+		result = append(result, textPart(t, 0, i))
+		if i == len(t.Content) {
+			run = run[1:]
+		} else {
+			run[0] = textPart(t, i, len(t.Content))
+		}
+	}
+	if len(run) == 0 {
+		return result
+	}
+	var after Node
+	if t, ok := run[len(run)-1].(*TextNode); ok && strings.HasSuffix(t.Content, "\n") {
+		i := len(strings.TrimRight(t.Content, "\n"))
+		after = textPart(t, i, len(t.Content))
+		if i == 0 {
+			run = run[:len(run)-1]
+		} else {
+			run[len(run)-1] = textPart(t, 0, i)
+		}
+	}
+	if len(run) > 0 {
+		p := &ParagraphNode{}
+		p.setSpan(Span{Start: run[0].Span().Start, End: run[len(run)-1].Span().End})
+		p.SetChildren(run)
+		result = append(result, p)
+	}
+	if after != nil {
+		result = append(result, after)
+	}
+	return result
+}
+
+// blankLines returns the ranges of s made of blank lines: line breaks, spaces and tabs, with at least two line breaks.
+func blankLines(s string) []Span {
+	var blanks []Span
+	for i := 0; i < len(s); {
+		if s[i] != '\n' {
+			i++
+			continue
+		}
+		end := i
+		lines := 0
+		for j := i; j < len(s) && (s[j] == '\n' || s[j] == ' ' || s[j] == '\t'); j++ {
+			if s[j] == '\n' {
+				lines++
+				end = j + 1
+			}
+		}
+		if lines >= 2 {
+			blanks = append(blanks, Span{Start: i, End: end})
+		}
+		i = end
+	}
+	return blanks
+}
+
+// textPart returns a TextNode with the content of t from start to end, with the matching span if t has a span
+// matching its content.
+func textPart(t *TextNode, start int, end int) *TextNode {
+	part := &TextNode{Content: t.Content[start:end]}
+	span := t.Span()
+	if span.End-span.Start == len(t.Content) {
+		part.setSpan(Span{Start: span.Start + start, End: span.Start + end})
+	} else {
+		part.setSpan(span)
+	}
+	return part
+}
+
+/*
+ReplacePass returns a Pass that replaces each node for which replace returns a non-nil Node with that Node,
+which gets the span of the replaced node. The children of replaced nodes are not visited.
+If replace returns a non-nil Node for the root node, that Node is returned by the Pass.
+*/
+func ReplacePass(replace func(n Node) Node) Pass {
+	visit := func(n Node) []Node {
+		var visited []Node
+		for _, c := range n.Children() {
+			if r := replace(c); r != nil {
+				r.setSpan(c.Span())
+				n.ReplaceChild(c, r)
+			} else {
+				visited = append(visited, c)
+			}
+		}
+		return visited
+	}
+	return func(n Node) Node {
+		if r := replace(n); r != nil {
+			r.setSpan(n.Span())
+			return r
+		}
+		preorder(n, visit)
+		return n
+	}
+}
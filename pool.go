@@ -0,0 +1,65 @@
+package formatting
+
+import "sync"
+
+// nodePools are the pools of released nodes, by kind.
+var nodePools = func() map[NodeKind]*sync.Pool {
+	pools := make(map[NodeKind]*sync.Pool, len(kindNames))
+	for k := range kindNames {
+		pools[k] = &sync.Pool{}
+	}
+	return pools
+}()
+
+// kindOf returns the kind of the nodes of type T.
+func kindOf[T any]() NodeKind {
+	// Kind does not use its receiver, so the kind can be read from a nil pointer
+	return any((*T)(nil)).(Node).Kind()
+}
+
+// allocNode returns a pointer to a Node with the value v, from the Arena of the options if set, or from the pools
+// of released nodes if ParserOptions.PoolNodes is set. v must have no children.
+func allocNode[T any](options *ParserOptions, v T) *T {
+	var n *T
+	if options.Arena != nil {
+		n = arenaAlloc[T](options.Arena)
+	} else if options.PoolNodes {
+		n, _ = nodePools[kindOf[T]()].Get().(*T)
+	}
+	if n == nil {
+		n = new(T)
+		*n = v
+		return n
+	}
+	// reuse the children slice of the previous node
+	children := any(n).(Node).base().children
+	*n = v
+	any(n).(Node).base().children = children[:0]
+	return n
+}
+
+/*
+Release returns all the nodes of the passed tree to the pools used by parsers with ParserOptions.PoolNodes set,
+so that they are reused by the next parsed messages, along with their children slices.
+
+The nodes of the tree must not be used after calling Release, including nodes retained elsewhere,
+such as a node returned by Find.
+*/
+func Release(root Node) {
+	Walk(root, func(n Node, entering bool) {
+		if entering {
+			return
+		}
+		b := n.base()
+		for i := range b.children {
+			b.children[i] = nil
+		}
+		b.children = b.children[:0]
+		b.self = nil
+		b.parent = nil
+		b.attrs = nil
+		if pool, ok := nodePools[n.Kind()]; ok {
+			pool.Put(n)
+		}
+	})
+}
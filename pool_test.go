@@ -0,0 +1,43 @@
+package formatting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRelease(t *testing.T) {
+	options := MessageParserOptions
+	options.PoolNodes = true
+	parser := NewParser(&options)
+	reference := NewParser(&MessageParserOptions)
+	texts := []string{
+		"**bold _italics_** <@1234> https://example.com",
+		"> quote\n- item\n# header",
+		"`code` ||spoiler|| ~~strike~~ __underline__",
+	}
+	for i := 0; i < 3; i++ {
+		for _, text := range texts {
+			root := parser.Parse(text)
+			if got, want := Debug(root), Debug(reference.Parse(text)); got != want {
+				t.Errorf("parsing %q with pooled nodes: want %s, got %s", text, want, got)
+			}
+			for _, c := range root.Children() {
+				if c.Parent() != root {
+					t.Errorf("parsing %q with pooled nodes: invalid parent", text)
+				}
+			}
+			Release(root)
+		}
+	}
+}
+
+func BenchmarkParsePooled(b *testing.B) {
+	source := strings.Repeat("Hello **world**, this is a _message_ with <@1234> and https://example.com links.\n", 100)
+	options := MessageParserOptions
+	options.PoolNodes = true
+	parser := NewParser(&options)
+	b.SetBytes(int64(len(source)))
+	for i := 0; i < b.N; i++ {
+		Release(parser.Parse(source))
+	}
+}
@@ -0,0 +1,76 @@
+package formatting
+
+/*
+MessageParserOptions is the parser configuration matching how the Discord apps currently render normal messages.
+
+Headers, lists and masked links are parsed through Behavior2023, the behavior since which they are rendered
+in all messages. Setting BehaviorVersion to BehaviorLegacy on a copy parses messages as before that update,
+without them.
+*/
+var MessageParserOptions = ParserOptions{
+	EnableBlockQuote:            true,
+	EnableMentions:              true,
+	LiteralIntrawordUnderscores: true,
+	SafeMaskedLinks:             true,
+	MaskedLinkSchemes:           []string{"http", "https", "discord"},
+	NormalizeNewlines:           true,
+	BehaviorVersion:             Behavior2023,
+}
+
+/*
+ForumPostParserOptions is the parser configuration matching how the Discord apps render forum and media channel posts.
+
+Since the 2023 markdown update, forum posts are parsed like normal messages. They only differ with BehaviorLegacy:
+headers and lists were rendered in forum posts even before that update, so they are parsed regardless of BehaviorVersion.
+*/
+var ForumPostParserOptions = ParserOptions{
+	EnableBlockQuote:            true,
+	EnableMentions:              true,
+	EnableHeaders:               true,
+	EnableLists:                 true,
+	LiteralIntrawordUnderscores: true,
+	SafeMaskedLinks:             true,
+	MaskedLinkSchemes:           []string{"http", "https", "discord"},
+	NormalizeNewlines:           true,
+	BehaviorVersion:             Behavior2023,
+}
+
+/*
+EmbedDescriptionParserOptions is the parser configuration matching how the Discord apps render embed descriptions
+and embed field values.
+
+Unlike in normal messages, the title of masked links, as in [mask](url "title"), is displayed as a tooltip in embeds,
+so it is parsed into the Title of the URLNode. Embeds are sent by bots and webhooks, and masked links were rendered
+in embeds regardless of the author even before the 2023 markdown update, so they are parsed regardless of BehaviorVersion.
+*/
+var EmbedDescriptionParserOptions = ParserOptions{
+	EnableBlockQuote:            true,
+	EnableMaskedLinks:           true,
+	EnableMentions:              true,
+	LiteralIntrawordUnderscores: true,
+	SafeMaskedLinks:             true,
+	EnableMaskedLinkTitles:      true,
+	MaskedLinkSchemes:           []string{"http", "https", "discord"},
+	NormalizeNewlines:           true,
+	BehaviorVersion:             Behavior2023,
+}
+
+/*
+EmbedTitleParserOptions is the parser configuration matching how the Discord apps render embed titles
+and embed field names, which only support inline formatting, without links or block rules.
+*/
+var EmbedTitleParserOptions = ParserOptions{
+	InlineOnly:                  true,
+	DisableURLs:                 true,
+	LiteralIntrawordUnderscores: true,
+	NormalizeNewlines:           true,
+}
+
+/*
+BioParserOptions is the parser configuration matching how the Discord apps render user and server profile bios.
+*/
+var BioParserOptions = ParserOptions{
+	EnableBlockQuote:            true,
+	LiteralIntrawordUnderscores: true,
+	NormalizeNewlines:           true,
+}
@@ -0,0 +1,370 @@
+package formatting
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Field numbers of the Node message of formatting.proto.
+const (
+	protoKind            = 1
+	protoStart           = 2
+	protoEnd             = 3
+	protoChildren        = 4
+	protoContent         = 5
+	protoLanguage        = 6
+	protoRawLanguage     = 7
+	protoInline          = 8
+	protoDelimiter       = 9
+	protoURL             = 10
+	protoMask            = 11
+	protoTitle           = 12
+	protoInvite          = 13
+	protoAnimated        = 14
+	protoText            = 15
+	protoID              = 16
+	protoMention         = 17
+	protoStamp           = 18
+	protoFormat          = 19
+	protoRaw             = 20
+	protoName            = 21
+	protoLevel           = 22
+	protoNestedLevel     = 23
+	protoIncludesNewline = 24
+	protoClass           = 25
+	protoColor           = 26
+	protoSuppressed      = 27
+	protoMarker          = 28
+	protoEscaped         = 29
+	protoSkinTone        = 30
+)
+
+// Wire types of the protocol buffers encoding.
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("invalid protobuf: unexpected end of message")
+
+/*
+MarshalProto serializes an AST to the protocol buffers binary encoding of the Node message
+of the formatting.proto schema found at the root of this module, for example to send parsed messages
+to services written in other languages.
+
+Attributes set with SetAttr are not serialized. Use UnmarshalProto to deserialize the AST.
+*/
+func MarshalProto(n Node) []byte {
+	// compute the size of the message of each node first, so that the tree is encoded iteratively, in a single buffer,
+	// and deep trees cannot overflow the goroutine stack
+	childTag := appendVarint(nil, uint64(protoChildren)<<3|protoWireBytes)
+	sizes := make(map[Node]int)
+	var scratch []byte
+	walk(n, func(n Node, entering bool) WalkStatus {
+		if entering {
+			return WalkContinue
+		}
+		scratch = appendProtoFields(appendProtoHeader(scratch[:0], n), n)
+		size := len(scratch)
+		for _, c := range n.Children() {
+			size += len(childTag) + varintLen(uint64(sizes[c])) + sizes[c]
+		}
+		sizes[n] = size
+		return WalkContinue
+	})
+	b := make([]byte, 0, sizes[n])
+	walk(n, func(c Node, entering bool) WalkStatus {
+		if !entering {
+			// the fields of the node follow its children
+			b = appendProtoFields(b, c)
+			return WalkContinue
+		}
+		if c != n {
+			b = append(b, childTag...)
+			b = appendVarint(b, uint64(sizes[c]))
+		}
+		b = appendProtoHeader(b, c)
+		return WalkContinue
+	})
+	return b
+}
+
+// appendProtoHeader appends the kind and span fields of n.
+func appendProtoHeader(b []byte, n Node) []byte {
+	b = appendProtoVarint(b, protoKind, uint64(n.Kind()))
+	b = appendProtoVarint(b, protoStart, uint64(n.Span().Start))
+	return appendProtoVarint(b, protoEnd, uint64(n.Span().End))
+}
+
+// appendProtoFields appends the fields of the type of n.
+func appendProtoFields(b []byte, n Node) []byte {
+	switch n := n.(type) {
+	case *TextNode:
+		b = appendProtoBytes(b, protoContent, n.Content)
+		b = appendProtoBool(b, protoEscaped, n.Escaped)
+	case *BlockQuoteNode:
+		b = appendProtoBytes(b, protoDelimiter, n.Delimiter)
+	case *CodeNode:
+		b = appendProtoBytes(b, protoContent, n.Content)
+		b = appendProtoBytes(b, protoLanguage, n.Language)
+		b = appendProtoBytes(b, protoRawLanguage, n.RawLanguage)
+		b = appendProtoBool(b, protoInline, n.Inline)
+		b = appendProtoBytes(b, protoDelimiter, n.Delimiter)
+	case *URLNode:
+		b = appendProtoBytes(b, protoURL, n.URL)
+		b = appendProtoBytes(b, protoMask, n.Mask)
+		b = appendProtoBytes(b, protoTitle, n.Title)
+		b = appendProtoBytes(b, protoInvite, n.Invite)
+		b = appendProtoBool(b, protoSuppressed, n.Suppressed)
+	case *EmojiNode:
+		b = appendProtoBool(b, protoAnimated, n.Animated)
+		b = appendProtoBytes(b, protoText, n.Text)
+		b = appendProtoBytes(b, protoID, n.ID)
+	case *NamedEmojiNode:
+		b = appendProtoBytes(b, protoName, n.Name)
+		b = appendProtoVarint(b, protoSkinTone, uint64(n.SkinTone))
+	case *ChannelMentionNode:
+		b = appendProtoBytes(b, protoID, n.ID)
+	case *RoleMentionNode:
+		b = appendProtoBytes(b, protoID, n.ID)
+	case *UserMentionNode:
+		b = appendProtoBytes(b, protoID, n.ID)
+	case *SpecialMentionNode:
+		b = appendProtoBytes(b, protoMention, n.Mention)
+	case *TimestampNode:
+		b = appendProtoBytes(b, protoStamp, n.Stamp)
+		b = appendProtoBytes(b, protoFormat, n.Format)
+	case *UnknownTagNode:
+		b = appendProtoBytes(b, protoRaw, n.Raw)
+		b = appendProtoBytes(b, protoName, n.Name)
+		b = appendProtoBytes(b, protoContent, n.Content)
+	case *HeaderNode:
+		b = appendProtoVarint(b, protoLevel, uint64(n.Level))
+		b = appendProtoBytes(b, protoMarker, n.Marker)
+	case *BulletListNode:
+		b = appendProtoVarint(b, protoNestedLevel, uint64(n.NestedLevel))
+		b = appendProtoBool(b, protoIncludesNewline, n.IncludesNewline)
+		b = appendProtoBytes(b, protoDelimiter, n.Delimiter)
+	case *ItalicsNode:
+		b = appendProtoBytes(b, protoDelimiter, n.Delimiter)
+	case *HighlightNode:
+		b = appendProtoBytes(b, protoClass, n.Class)
+		b = appendProtoBytes(b, protoColor, n.Color)
+	}
+	return b
+}
+
+// appendProtoVarint appends a varint field, omitting it if it is zero, as in proto3.
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|protoWireVarint)
+	return appendVarint(b, v)
+}
+
+func appendProtoBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendProtoVarint(b, field, 1)
+}
+
+// appendProtoBytes appends a length-delimited field, omitting it if it is empty, as in proto3.
+func appendProtoBytes(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|protoWireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// varintLen returns the length in bytes of the varint encoding of v.
+func varintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+/*
+UnmarshalProto deserializes an AST serialized with MarshalProto, or by any implementation of the Node message
+of the formatting.proto schema.
+
+The returned tree is indexed. Unknown fields are ignored. An error is returned if the data is not a valid
+Node message, if it contains a node of an unknown kind, or if it contains a header or list item whose level
+is out of range.
+*/
+func UnmarshalProto(data []byte) (Node, error) {
+	// decode the tree iteratively with an explicit stack, so that deep trees cannot overflow the goroutine stack
+	type frame struct {
+		data   []byte
+		parent Node
+	}
+	var root Node
+	stack := []frame{{data: data}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n, children, err := unmarshalProtoNode(f.data)
+		if err != nil {
+			return nil, err
+		}
+		if f.parent == nil {
+			root = n
+		} else {
+			f.parent.AppendChild(n)
+		}
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, frame{data: children[i], parent: n})
+		}
+	}
+	Index(root)
+	return root, nil
+}
+
+// unmarshalProtoNode returns the node of a Node message, without its children, and the Node messages of its children.
+func unmarshalProtoNode(data []byte) (Node, [][]byte, error) {
+	var kind NodeKind
+	var span Span
+	var children [][]byte
+	varints := make(map[int]uint64)
+	texts := make(map[int]string)
+	for len(data) > 0 {
+		tag, n := readVarint(data)
+		if n == 0 {
+			return nil, nil, errProtoTruncated
+		}
+		data = data[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		switch wire {
+		case protoWireVarint:
+			v, n := readVarint(data)
+			if n == 0 {
+				return nil, nil, errProtoTruncated
+			}
+			data = data[n:]
+			switch field {
+			case protoKind:
+				kind = NodeKind(v)
+			case protoStart:
+				span.Start = int(v)
+			case protoEnd:
+				span.End = int(v)
+			default:
+				varints[field] = v
+			}
+		case protoWireBytes:
+			length, n := readVarint(data)
+			if n == 0 || uint64(len(data)-n) < length {
+				return nil, nil, errProtoTruncated
+			}
+			v := data[n : n+int(length)]
+			data = data[n+int(length):]
+			if field == protoChildren {
+				children = append(children, v)
+			} else {
+				texts[field] = string(v)
+			}
+		case protoWireFixed64:
+			if len(data) < 8 {
+				return nil, nil, errProtoTruncated
+			}
+			data = data[8:]
+		case protoWireFixed32:
+			if len(data) < 4 {
+				return nil, nil, errProtoTruncated
+			}
+			data = data[4:]
+		default:
+			return nil, nil, fmt.Errorf("invalid protobuf: unsupported wire type %d", wire)
+		}
+	}
+	n := newNodeOfKind(kind)
+	if n == nil {
+		return nil, nil, fmt.Errorf("invalid protobuf: unknown node kind %d", kind)
+	}
+	n.setSpan(span)
+	switch n := n.(type) {
+	case *TextNode:
+		n.Content = texts[protoContent]
+		n.Escaped = varints[protoEscaped] != 0
+	case *BlockQuoteNode:
+		n.Delimiter = texts[protoDelimiter]
+	case *CodeNode:
+		n.Content = texts[protoContent]
+		n.Language = texts[protoLanguage]
+		n.RawLanguage = texts[protoRawLanguage]
+		n.Inline = varints[protoInline] != 0
+		n.Delimiter = texts[protoDelimiter]
+	case *URLNode:
+		n.URL = texts[protoURL]
+		n.Mask = texts[protoMask]
+		n.Title = texts[protoTitle]
+		n.Invite = texts[protoInvite]
+		n.Suppressed = varints[protoSuppressed] != 0
+	case *EmojiNode:
+		n.Animated = varints[protoAnimated] != 0
+		n.Text = texts[protoText]
+		n.ID = texts[protoID]
+	case *NamedEmojiNode:
+		n.Name = texts[protoName]
+		n.SkinTone = int(varints[protoSkinTone])
+	case *ChannelMentionNode:
+		n.ID = texts[protoID]
+	case *RoleMentionNode:
+		n.ID = texts[protoID]
+	case *UserMentionNode:
+		n.ID = texts[protoID]
+	case *SpecialMentionNode:
+		n.Mention = texts[protoMention]
+	case *TimestampNode:
+		n.Stamp = texts[protoStamp]
+		n.Format = texts[protoFormat]
+	case *UnknownTagNode:
+		n.Raw = texts[protoRaw]
+		n.Name = texts[protoName]
+		n.Content = texts[protoContent]
+	case *HeaderNode:
+		n.Level = int(varints[protoLevel])
+		n.Marker = texts[protoMarker]
+	case *BulletListNode:
+		n.NestedLevel = int(varints[protoNestedLevel])
+		n.IncludesNewline = varints[protoIncludesNewline] != 0
+		n.Delimiter = texts[protoDelimiter]
+	case *ItalicsNode:
+		n.Delimiter = texts[protoDelimiter]
+	case *HighlightNode:
+		n.Class = texts[protoClass]
+		n.Color = texts[protoColor]
+	}
+	if err := checkLevels(n); err != nil {
+		return nil, nil, fmt.Errorf("invalid protobuf: %w", err)
+	}
+	return n, children, nil
+}
+
+// readVarint returns the varint at the start of b and its length in bytes, or a length of 0 if it is invalid.
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7F) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
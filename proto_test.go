@@ -0,0 +1,47 @@
+package formatting
+
+import "testing"
+
+func TestProto(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	for _, text := range []string{
+		"",
+		"hi **bold _it_** ||spoiler|| ~~s~~ __u__",
+		"> quote\n# header\n- item\n  * nested",
+		"`code` ```go\nfunc()\n``` [mask](https://example.com \"title\") https://discord.gg/abc <https://example.com>",
+		"<a:e:1> <#1> <@&2> <@3> @everyone <t:1234:R>",
+	} {
+		root := parser.Parse(text)
+		got, err := UnmarshalProto(MarshalProto(root))
+		if err != nil {
+			t.Errorf("unmarshaling %q: %v", text, err)
+			continue
+		}
+		if !Equal(got, root) || Debug(got) != Debug(root) {
+			t.Errorf("round trip of %q: want %s, got %s", text, Debug(root), Debug(got))
+		}
+		if got.Span() != root.Span() || len(got.Children()) > 0 && got.Children()[0].Span() != root.Children()[0].Span() {
+			t.Errorf("round trip of %q: spans differ", text)
+		}
+		for _, c := range got.Children() {
+			if c.Parent() != got {
+				t.Errorf("round trip of %q: tree is not indexed", text)
+			}
+		}
+	}
+
+	if got, err := UnmarshalProto(MarshalProto(parser.Parse("\\*"))); err != nil || !got.Children()[0].(*TextNode).Escaped {
+		t.Errorf("round trip of escape: want escaped text, got %v", err)
+	}
+	if _, err := UnmarshalProto(MarshalProto(NewBold(NewText("hi")))[:5]); err == nil {
+		t.Errorf("unmarshaling truncated data: want error")
+	}
+	if _, err := UnmarshalProto([]byte{protoKind << 3, 100}); err == nil {
+		t.Errorf("unmarshaling unknown kind: want error")
+	}
+	for _, n := range []Node{&BulletListNode{}, &HeaderNode{Level: 4}} {
+		if _, err := UnmarshalProto(MarshalProto(n)); err == nil {
+			t.Errorf("unmarshaling %s: want error", Debug(n))
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package formatting
+
+import "strings"
+
+/*
+Quote serializes the passed tree as a Discord block quote, for example to quote a message when replying to it
+or bridging a reply. The attribution is an optional first line of the quote, such as **user** wrote:,
+which is written as is and should be escaped with Escape if needed.
+
+Every line of the quote is prefixed with >, so that text written after the returned quote, which ends with
+a newline, is not quoted. Discord does not support nested block quotes, so block quotes of the passed tree
+are replaced with their content. The content is serialized with RenderMarkdown, with minimal escaping.
+As the lines of a block quote are parsed together, formatting and code blocks spanning several lines are kept.
+*/
+func Quote(n Node, attribution string) string {
+	n = UnwrapPass(KindBlockQuote)(Clone(n))
+	content := RenderMarkdown(n, &MarkdownOptions{
+		MinimalEscaping: true,
+	})
+	var sb strings.Builder
+	if attribution != "" {
+		sb.WriteString("> " + attribution + "\n")
+	}
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		sb.WriteString("> " + line + "\n")
+	}
+	return sb.String()
+}
+
+/*
+QuoteText parses the passed message text with DefaultParserOptions and serializes it as a block quote with Quote.
+*/
+func QuoteText(text string, attribution string) string {
+	return Quote(NewParser(nil).Parse(text), attribution)
+}
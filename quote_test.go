@@ -0,0 +1,52 @@
+package formatting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	for _, tc := range []struct {
+		text        string
+		attribution string
+		want        string
+	}{
+		{"hello", "", "> hello\n"},
+		{"hello\n**world**\n", "**user** wrote:", "> **user** wrote:\n> hello\n> **world**\n"},
+		{"> quoted\nreply", "", "> quoted\n> reply\n"},
+		{">>> long\nquote", "", "> long\n> quote\n"},
+		{"```go\nfmt.Println()\n```", "", "> ```go\n> fmt.Println()\n> ```\n"},
+		{"```\na\n\nb\n```", "", "> ```\n> a\n> \n> b\n> ```\n"},
+		{"a ```x\ny``` b", "", "> a ```x\n> y\n> ``` b\n"},
+		{"*a\nb*", "", "> *a\n> b*\n"},
+	} {
+		got := QuoteText(tc.text, tc.attribution)
+		if got != tc.want {
+			t.Errorf("error quoting %q: want %q, got %q", tc.text, tc.want, got)
+			continue
+		}
+		if tc.attribution != "" {
+			continue
+		}
+		// the quote must be parsed back as a block quote of the quoted message
+		text := tc.text
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		want := Pipeline{UnwrapPass(KindBlockQuote), MergeTextPass}.Apply(NewParser(nil).Parse(text))
+		root := NewParser(nil).Parse(got)
+		children := root.Children()
+		if len(children) != 1 {
+			t.Errorf("error parsing quote %q of %q: want a single block quote, got %s", got, tc.text, Debug(root))
+			continue
+		}
+		if _, ok := children[0].(*BlockQuoteNode); !ok {
+			t.Errorf("error parsing quote %q of %q: want a single block quote, got %s", got, tc.text, Debug(root))
+			continue
+		}
+		quoted := MergeTextPass(NewRoot(children[0].Children()...))
+		if !Equal(quoted, want) {
+			t.Errorf("error parsing quote %q of %q: want %s, got %s", got, tc.text, Debug(want), Debug(quoted))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !noregexp
+
+package formatting
+
+import (
+	"regexp"
+	"sync"
+)
+
+// hasRegexp is whether the patterns of the rules can be run, which is not the case with the noregexp build tag.
+const hasRegexp = true
+
+// lazyRegexp is a regular expression compiled on first use, so that programs that never parse messages
+// do not compile the patterns on initialization.
+type lazyRegexp struct {
+	expr string
+	once sync.Once
+	re   *regexp.Regexp
+}
+
+func lazyCompile(expr string) *lazyRegexp {
+	return &lazyRegexp{expr: expr}
+}
+
+func (r *lazyRegexp) regexp() *regexp.Regexp {
+	r.once.Do(func() {
+		r.re = regexp.MustCompile(r.expr)
+	})
+	return r.re
+}
+
+func (r *lazyRegexp) FindStringSubmatchIndex(s string) []int {
+	return r.regexp().FindStringSubmatchIndex(s)
+}
+
+func (r *lazyRegexp) FindStringSubmatch(s string) []string {
+	return r.regexp().FindStringSubmatch(s)
+}
+
+func (r *lazyRegexp) String() string {
+	return r.expr
+}
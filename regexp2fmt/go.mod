@@ -0,0 +1,10 @@
+module github.com/delthas/discord-formatting/regexp2fmt
+
+go 1.18
+
+require (
+	github.com/delthas/discord-formatting v0.0.0-20261016151629-267fe1d31ab5
+	github.com/dlclark/regexp2 v1.11.5
+)
+
+replace github.com/delthas/discord-formatting => ../
@@ -0,0 +1,96 @@
+/*
+Package regexp2fmt backs the rules of the formatting parser with regexp2, a backtracking regular expression engine
+that supports the JavaScript syntax of the patterns of the Discord client, such as lookaheads.
+
+The regexp package used by default does not support lookarounds, so some rules of the parser use approximations
+of the Discord patterns. The patterns of this package are the Discord patterns, so that the parsed messages match
+the client exactly, at the cost of a slower parsing, which can be exponential on pathological messages.
+
+To parse messages with these patterns, create a parser with options returned by Options:
+
+	parser := formatting.NewParser(regexp2fmt.Options(&formatting.MessageParserOptions))
+
+Matches are computed on characters rather than on the UTF-16 code units of JavaScript strings, so characters
+outside of the Basic Multilingual Plane, such as most emoji, can still be matched differently.
+*/
+package regexp2fmt
+
+import (
+	formatting "github.com/delthas/discord-formatting"
+	"github.com/dlclark/regexp2"
+)
+
+/*
+Patterns are the JavaScript patterns of the rules whose default patterns are approximations, by rule name.
+
+Each pattern has the same groups as the default pattern of its rule, returned by formatting.RulePattern.
+*/
+var Patterns = map[string]string{
+	formatting.RuleMaskedLink: `^(\[(?:\[[^\]]*\]|[^\[\]]|\](?=[^\[]*\]))*\])\(\s*<?((?:[^\s\\]|\\.)*?)>?(?:\s+['"]([\s\S]*?)['"])?\s*\)`,
+	formatting.RuleBold:       `^(\*\*([\s\S]+?)\*\*)(?!\*)`,
+	formatting.RuleUnderline:  `^(__([\s\S]+?)__)(?!_)`,
+	formatting.RuleItalics:    `^(\b_((?:__|\\[\s\S]|[^\\_])+?)_\b)|^(\*(?=\S)((?:\*\*|\\[\s\S]|\s+(?:\\[\s\S]|[^\s\*\\]|\*\*)|[^\s\*\\])+?)\*(?!\*))`,
+}
+
+type matcher struct {
+	re *regexp2.Regexp
+}
+
+func (m matcher) FindStringSubmatchIndex(s string) []int {
+	match, err := m.re.FindStringMatch(s)
+	if err != nil || match == nil {
+		return nil
+	}
+	groups := match.Groups()
+	// regexp2 returns offsets in characters: convert them to byte offsets
+	end := match.Index + match.Length
+	offsets := make([]int, 0, end+1)
+	for i := range s {
+		if len(offsets) > end {
+			break
+		}
+		offsets = append(offsets, i)
+	}
+	for len(offsets) <= end {
+		offsets = append(offsets, len(s))
+	}
+	indices := make([]int, 0, len(groups)*2)
+	for _, g := range groups {
+		if len(g.Captures) == 0 {
+			indices = append(indices, -1, -1)
+			continue
+		}
+		indices = append(indices, offsets[g.Index], offsets[g.Index+g.Length])
+	}
+	return indices
+}
+
+/*
+Matchers returns matchers of the Patterns, to be set in formatting.ParserOptions.Matchers.
+*/
+func Matchers() map[string]formatting.Matcher {
+	matchers := make(map[string]formatting.Matcher, len(Patterns))
+	for name, pattern := range Patterns {
+		matchers[name] = matcher{re: regexp2.MustCompile(pattern, regexp2.ECMAScript)}
+	}
+	return matchers
+}
+
+/*
+Options returns a copy of the passed options that parses messages with the Patterns.
+
+As a special case, passing nil is equivalent to passing formatting.DefaultParserOptions.
+*/
+func Options(options *formatting.ParserOptions) *formatting.ParserOptions {
+	if options == nil {
+		options = &formatting.DefaultParserOptions
+	}
+	o := *options
+	o.Matchers = Matchers()
+	for name, m := range options.Matchers {
+		if _, ok := o.Matchers[name]; !ok {
+			o.Matchers[name] = m
+		}
+	}
+	return &o
+}
@@ -0,0 +1,33 @@
+package regexp2fmt
+
+import (
+	"testing"
+
+	formatting "github.com/delthas/discord-formatting"
+)
+
+func TestMatchers(t *testing.T) {
+	for name, pattern := range Patterns {
+		if formatting.RulePattern(name) == "" {
+			t.Errorf("pattern of unknown rule %q: %s", name, pattern)
+		}
+	}
+
+	parser := formatting.NewParser(Options(&formatting.MessageParserOptions))
+	reference := formatting.NewParser(&formatting.MessageParserOptions)
+	for _, text := range []string{
+		"**bold** __underline__ *italics* _italics_ ~~strike~~",
+		"***bold italics*** é **😀 bold**",
+		"[mask](https://example.com) [a [b] c](https://example.com \"title\")",
+		"a_b_c *a **b** c*",
+	} {
+		if got, want := formatting.Debug(parser.Parse(text)), formatting.Debug(reference.Parse(text)); got != want {
+			t.Errorf("parsing %q: want %s, got %s", text, want, got)
+		}
+	}
+
+	// the JavaScript italics pattern skips escaped asterisks
+	if got, want := formatting.Debug(parser.Parse(`*a\*b*`)), `[[italics [text "a"] [text "*"] [text "b"]]]`; got != want {
+		t.Errorf("parsing escaped italics: want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,91 @@
+package formatting
+
+import (
+	"io"
+	"strconv"
+)
+
+/*
+Highlighter is a syntax highlighter for code blocks, used by the renderers when set in RenderOptions.
+
+Highlight returns the highlighted code as a Node tree, made of TextNode leaves that are possibly
+wrapped in HighlightNode spans. It can be implemented on top of a highlighting library such as chroma,
+by mapping each token of the code to a HighlightNode containing a TextNode.
+
+If Highlight returns an error or a nil Node, the code block is rendered without highlighting.
+*/
+type Highlighter interface {
+	Highlight(language string, code string) (Node, error)
+}
+
+/*
+RenderOptions is a configuration object used for rendering a message AST, for example with RenderHTML.
+
+An empty RenderOptions, or passing nil instead, is the default configuration.
+*/
+type RenderOptions struct {
+	// Highlighter is an optional syntax highlighter for code blocks. Inline code is never highlighted.
+	Highlighter Highlighter
+	// Timestamps is the configuration used for formatting timestamps with FormatTimestamp.
+	Timestamps *TimestampOptions
+	// EmojiMapper, if set, returns the URL of the image and the alternative text of custom emoji, for example
+	// to use proxied URLs, guild-specific names or text fallbacks. Emoji with an empty URL are rendered as their
+	// alternative text. By default, the URL is the one returned by EmojiNode.URL, and the alternative text is :name:.
+	// Renderers that do not display images, such as RenderANSI, render emoji as their alternative text.
+	EmojiMapper func(n *EmojiNode) (url string, altText string)
+}
+
+// highlight returns the highlighted tree of a code block, or nil if it should be rendered as is.
+func highlight(n *CodeNode, options *RenderOptions) Node {
+	if n.Inline || options.Highlighter == nil {
+		return nil
+	}
+	h, err := options.Highlighter.Highlight(n.Language, n.Content)
+	if err != nil {
+		return nil
+	}
+	return h
+}
+
+// timestampText returns the text of a timestamp as displayed by Discord, or its raw syntax if it is invalid.
+func timestampText(n *TimestampNode, options *RenderOptions) string {
+	if text, err := FormatTimestamp(n, options.Timestamps); err == nil {
+		return text
+	}
+	text := "<t:" + n.Stamp
+	if n.Format != "" {
+		text += ":" + n.Format
+	}
+	return text + ">"
+}
+
+// emojiImage returns the URL of the image and the alternative text of a custom emoji,
+// as returned by the EmojiMapper of the options if any.
+func emojiImage(n *EmojiNode, options *RenderOptions) (url string, altText string) {
+	if options.EmojiMapper != nil {
+		return options.EmojiMapper(n)
+	}
+	return n.URL("", 0), ":" + n.Text + ":"
+}
+
+// errWriter is a writer that keeps the first write error, and ignores the writes after it.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) WriteString(s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = io.WriteString(w.w, s)
+}
+
+// hexColor returns the RGB value of a color in the #rrggbb form. ok is false if color is not in that form.
+func hexColor(color string) (rgb uint64, ok bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return 0, false
+	}
+	rgb, err := strconv.ParseUint(color[1:], 16, 32)
+	return rgb, err == nil
+}
@@ -0,0 +1,153 @@
+package formatting
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keywordHighlighter highlights the word func in go code blocks.
+type keywordHighlighter struct{}
+
+func (keywordHighlighter) Highlight(language string, code string) (Node, error) {
+	if language != "go" {
+		return nil, errors.New("unsupported language")
+	}
+	root := &node{}
+	for i, part := range strings.Split(code, "func") {
+		if i > 0 {
+			keyword := &HighlightNode{Class: "keyword", Color: "#ff0000"}
+			keyword.addChild(&TextNode{Content: "func"})
+			root.addChild(keyword)
+		}
+		root.addChild(&TextNode{Content: part})
+	}
+	return root, nil
+}
+
+func testRender(t *testing.T, render func(Node, *RenderOptions) string, options *RenderOptions, text string, want string) {
+	got := render(NewParser(&ParserOptions{
+		EnableBlockQuote:            true,
+		EnableMaskedLinks:           true,
+		EnableMentions:              true,
+		EnableForumMarkdown:         true,
+		LiteralIntrawordUnderscores: true,
+		SafeMaskedLinks:             true,
+		MaskedLinkSchemes:           DiscordMaskedLinkSchemes,
+		NormalizeNewlines:           true,
+	}).Parse(text), options)
+	if got != want {
+		t.Errorf("error rendering %q: want %q, got %q", text, want, got)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	test := func(text string, want string) {
+		testRender(t, RenderHTML, nil, text, want)
+	}
+	test("**bold** *it* __u__ ~~s~~ ||sp||", `<strong>bold</strong> <em>it</em> <u>u</u> <s>s</s> <span class="spoiler">sp</span>`)
+	test("a <b>\nc", "a &lt;b&gt;<br>c")
+	test(">>> quote", "<blockquote>quote</blockquote>")
+	test("`a<b`", "<code>a&lt;b</code>")
+	test("```go\nfunc main() {}\n```", `<pre><code class="language-go">func main() {}</code></pre>`)
+	test("[site](https://example.com?a&b)", `<a href="https://example.com?a&amp;b">site</a>`)
+	titled := RenderHTML(NewParser(&EmbedDescriptionParserOptions).Parse(`[site](https://example.com "a tip")`), nil)
+	if want := `<a href="https://example.com" title="a tip">site</a>`; titled != want {
+		t.Errorf("error rendering masked link title: want %q, got %q", want, titled)
+	}
+	test("<a:wave:1234>", `<img class="emoji" src="https://cdn.discordapp.com/emojis/1234.gif" alt=":wave:">`)
+	test("<@1234> @here", `<span class="mention">@1234</span> <span class="mention">@here</span>`)
+	test("## title", "<h2>title</h2>")
+	test("- item", "<ul><li>item</li></ul>")
+
+	link := RenderHTML(NewParser(&ParserOptions{EnableMaskedLinks: true}).Parse("[click](javascript:alert(1))"), nil)
+	if want := "click)"; link != want {
+		t.Errorf("error rendering javascript link: want %q, got %q", want, link)
+	}
+	span := NewRoot(&HighlightNode{Color: "red\" onmouseover=\"alert(1)"})
+	if got, want := RenderHTML(span, nil), "<span></span>"; got != want {
+		t.Errorf("error rendering invalid color: want %q, got %q", want, got)
+	}
+
+	timestamps := &RenderOptions{Timestamps: &TimestampOptions{Location: time.UTC}}
+	testRender(t, RenderHTML, timestamps, "<t:1234567890:D>", `<time class="timestamp" datetime="2009-02-13T23:31:30Z">February 13, 2009</time>`)
+
+	highlighted := &RenderOptions{Highlighter: keywordHighlighter{}}
+	testRender(t, RenderHTML, highlighted, "```go\nfunc a()\nfunc b()\n```", `<pre><code class="language-go"><span class="hljs-keyword" style="color: #ff0000">func</span> a()`+"\n"+`<span class="hljs-keyword" style="color: #ff0000">func</span> b()</code></pre>`)
+	testRender(t, RenderHTML, highlighted, "```js\nfunc\n```", `<pre><code class="language-js">func</code></pre>`)
+	testRender(t, RenderHTML, highlighted, "`func`", `<code>func</code>`)
+
+	mapped := &RenderOptions{EmojiMapper: func(n *EmojiNode) (string, string) {
+		if n.ID == "1" {
+			return "", "[" + n.Text + "]"
+		}
+		return "https://proxy.example/" + n.ID + "?s=48&f=" + n.URL("webp", 48), "<" + n.Text + ">"
+	}}
+	testRender(t, RenderHTML, mapped, "<:a:1> <a:b:2>", `[a] <img class="emoji" src="https://proxy.example/2?s=48&amp;f=https://cdn.discordapp.com/emojis/2.webp?size=48" alt="&lt;b&gt;">`)
+	testRender(t, RenderANSI, mapped, "<:a:1> <a:b:2>", "[a] <b>")
+	testRender(t, RenderIRC, mapped, "<:a:1> <a:b:2>", "[a] <b>")
+}
+
+func TestRenderANSI(t *testing.T) {
+	test := func(text string, want string) {
+		testRender(t, RenderANSI, nil, text, want)
+	}
+	test("**bold *it***", "\x1b[1mbold \x1b[3mit\x1b[0m\x1b[1m\x1b[0m")
+	test("> a\n> b", "\x1b[90m▎\x1b[39m a\n\x1b[90m▎\x1b[39m b")
+	test(">>> a\nb", "\x1b[90m▎\x1b[39m a\n\x1b[90m▎\x1b[39m b")
+	test("<https://example.com>", "\x1b[4;34m\x1b]8;;https://example.com\x1b\\https://example.com\x1b]8;;\x1b\\\x1b[0m")
+
+	test("a\x1b[2Jb\u009b", "a[2Jb")
+	test("https://a.com/\x1b]0;pwned\a", "\x1b[4;34m\x1b]8;;https://a.com/]0;pwned\x1b\\https://a.com/]0;pwned\x1b]8;;\x1b\\\x1b[0m")
+
+	testRender(t, RenderANSI, &RenderOptions{Highlighter: keywordHighlighter{}}, "```go\nfunc a\n```", "\x1b[38;2;255;0;0mfunc\x1b[0m a")
+	if got, want := RenderANSI(NewRoot(&BulletListNode{}), nil), "• "; got != want {
+		t.Errorf("error rendering list item without level: want %q, got %q", want, got)
+	}
+}
+
+// limitWriter fails writes once n bytes were written.
+type limitWriter struct {
+	n  int
+	sb strings.Builder
+}
+
+var errLimit = errors.New("write limit reached")
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.sb.Len()+len(p) > w.n {
+		return 0, errLimit
+	}
+	return w.sb.Write(p)
+}
+
+func TestWriteError(t *testing.T) {
+	n := NewParser(nil).Parse("**a** *b* ~~c~~")
+	for name, write := range map[string]func(io.Writer, Node, *RenderOptions) error{
+		"html": WriteHTML,
+		"ansi": WriteANSI,
+	} {
+		w := &limitWriter{n: 10}
+		if err := write(w, n, nil); !errors.Is(err, errLimit) {
+			t.Errorf("error writing %s: want write error, got %v", name, err)
+		}
+		w = &limitWriter{n: 1000}
+		if err := write(w, n, nil); err != nil || w.sb.Len() == 0 {
+			t.Errorf("error writing %s: got %q (%v)", name, w.sb.String(), err)
+		}
+	}
+
+	calls := 0
+	err := WalkErr(n, func(n Node, entering bool) error {
+		calls++
+		if _, ok := n.(*ItalicsNode); ok {
+			return errLimit
+		}
+		return nil
+	})
+	if err != errLimit || calls != 8 {
+		t.Errorf("error walking: want error after 8 calls, got %v after %d calls", err, calls)
+	}
+}
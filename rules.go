@@ -0,0 +1,174 @@
+package formatting
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Rule names are the stable names of the parser rules, used in ParserOptions.DisabledRules and ParserOptions.RuleOrder.
+const (
+	RuleSoftHyphen     = "softhyphen"
+	RuleInvisible      = "invisible"
+	RuleEscape         = "escape"
+	RuleBlockQuote     = "blockquote"
+	RuleCodeBlock      = "codeblock"
+	RuleCodeInline     = "codeinline"
+	RuleSpoiler        = "spoiler"
+	RuleMaskedLink     = "maskedlink"
+	RuleURLNoEmbed     = "urlnoembed"
+	RuleURL            = "url"
+	RuleEmail          = "email"
+	RuleCustomEmoji    = "customemoji"
+	RuleNamedEmoji     = "namedemoji"
+	RuleEmoticon       = "emoticon"
+	RuleChannelMention = "channelmention"
+	RuleRoleMention    = "rolemention"
+	RuleUserMention    = "usermention"
+	RuleSpecialMention = "specialmention"
+	RuleTimestamp      = "timestamp"
+	RuleUnknownTag     = "unknowntag"
+	RuleHeader         = "header"
+	RuleList           = "list"
+	RuleLineBreak      = "linebreak"
+	RuleNewline        = "newline"
+	RuleBold           = "bold"
+	RuleUnderline      = "underline"
+	RuleItalics        = "italics"
+	RuleStrikethrough  = "strikethrough"
+	RuleText           = "text"
+)
+
+/*
+RuleNames returns the names of all the parser rules, by order of default priority.
+*/
+func RuleNames() []string {
+	names := make([]string, len(parserRules))
+	for i, r := range parserRules {
+		names[i] = r.name
+	}
+	return names
+}
+
+// orderedRules returns the parser rules, with the named rules reordered among the slots they occupy by default.
+func orderedRules(order []string) []rule {
+	if len(order) == 0 {
+		return parserRules
+	}
+	index := make(map[string]int, len(parserRules))
+	for i, r := range parserRules {
+		index[r.name] = i
+	}
+	var slots []int
+	var reordered []rule
+	seen := make(map[string]bool, len(order))
+	for _, name := range order {
+		i, ok := index[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		slots = append(slots, i)
+		reordered = append(reordered, parserRules[i])
+	}
+	sort.Ints(slots)
+	rules := make([]rule, len(parserRules))
+	copy(rules, parserRules)
+	for i, slot := range slots {
+		rules[slot] = reordered[i]
+	}
+	return rules
+}
+
+/*
+Matcher matches the pattern of a parser rule, set in ParserOptions.Matchers.
+
+FindStringSubmatchIndex returns the byte offsets of the match of the pattern at the start of s and of its groups,
+or nil if the pattern does not match, like the method of the same name of regexp.Regexp.
+*/
+type Matcher interface {
+	FindStringSubmatchIndex(s string) []int
+}
+
+/*
+RulePattern returns the regular expression matched by the named rule, such as RuleBold, in the syntax of the regexp
+package, or an empty string if there is no such rule.
+
+A Matcher of the rule set in ParserOptions.Matchers must return the same groups as this pattern.
+*/
+func RulePattern(name string) string {
+	for _, r := range parserRules {
+		if r.name == name {
+			return r.pattern.String()
+		}
+	}
+	return ""
+}
+
+var (
+	ruleSetsLock sync.RWMutex
+	// ruleSets are the rule sets of the options without DisabledRules, RuleOrder nor Matchers,
+	// by mask of their enabled rules of parserRules.
+	ruleSets = make(map[uint64]*ruleSet)
+)
+
+// rulesFor returns the rule set of the parser options, reusing the rule set of previous options
+// with the same enabled rules if possible, so that creating parsers does not allocate.
+func rulesFor(options *ParserOptions) *ruleSet {
+	if len(options.DisabledRules) > 0 || len(options.RuleOrder) > 0 || len(options.Matchers) > 0 {
+		return newRuleSet(enabledRules(options))
+	}
+	var mask uint64
+	for i, r := range parserRules {
+		if r.enabled == nil || r.enabled(options) {
+			mask |= 1 << i
+		}
+	}
+	ruleSetsLock.RLock()
+	s, ok := ruleSets[mask]
+	ruleSetsLock.RUnlock()
+	if ok {
+		return s
+	}
+	s = newRuleSet(enabledRules(options))
+	ruleSetsLock.Lock()
+	ruleSets[mask] = s
+	ruleSetsLock.Unlock()
+	return s
+}
+
+// ruleSet is a list of rules by order of priority, with the rules that can match at each position precomputed,
+// so that the parser only tries these rules.
+type ruleSet struct {
+	rules []rule
+	// candidates are the indexes in rules of the rules that can match a source starting with each byte,
+	// by order of priority, when not at the start of a line (0), and at the start of a line (1).
+	candidates [2][256][]uint8
+}
+
+func newRuleSet(rules []rule) *ruleSet {
+	s := &ruleSet{rules: rules}
+	// most bytes have the same candidates, which can share their slice
+	shared := make(map[string][]uint8)
+	for lineStart := range s.candidates {
+		for c := range s.candidates[lineStart] {
+			var candidates []uint8
+			for i, r := range rules {
+				if r.block && lineStart == 0 {
+					continue
+				}
+				if r.first != "" && strings.IndexByte(r.first, byte(c)) < 0 {
+					continue
+				}
+				candidates = append(candidates, uint8(i))
+			}
+			if v, ok := shared[string(candidates)]; ok {
+				candidates = v
+			} else {
+				shared[string(candidates)] = candidates
+			}
+			s.candidates[lineStart][c] = candidates
+		}
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package formatting
+
+import "strings"
+
+// mentionSanitizer inserts a zero-width space in the mention syntaxes, so that they do not ping anyone.
+var mentionSanitizer = strings.NewReplacer(
+	"@everyone", "@\u200beveryone",
+	"@here", "@\u200bhere",
+	"<@", "<@\u200b",
+)
+
+/*
+SanitizeMentions returns the passed text with a zero-width space inserted in all the mentions that could ping users,
+such as @everyone, @here, <@id> and <@&id>, so that they are displayed as text without pinging anyone.
+
+This is typically used by bots echoing user-provided text. To sanitize a parsed message, use SanitizeMentionsPass.
+*/
+func SanitizeMentions(text string) string {
+	return mentionSanitizer.Replace(text)
+}
+
+/*
+SanitizeMentionsPass is a Pass that replaces the UserMentionNode, RoleMentionNode and SpecialMentionNode nodes
+with a TextNode containing their syntax sanitized with SanitizeMentions, so that they do not ping anyone
+when the message is sent back, for example after serializing it with RenderMarkdown.
+*/
+var SanitizeMentionsPass = ReplacePass(func(n Node) Node {
+	switch n := n.(type) {
+	case *UserMentionNode:
+		return &TextNode{Content: SanitizeMentions("<@" + n.ID + ">")}
+	case *RoleMentionNode:
+		return &TextNode{Content: SanitizeMentions("<@&" + n.ID + ">")}
+	case *SpecialMentionNode:
+		return &TextNode{Content: SanitizeMentions("@" + n.Mention)}
+	default:
+		return nil
+	}
+})
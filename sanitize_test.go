@@ -0,0 +1,23 @@
+package formatting
+
+import "testing"
+
+func TestSanitizeMentions(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	text := SanitizeMentions("hi @everyone and @here, <@1234> <@!1234> <@&1234> <#1234>")
+	if root := parser.Parse(text); Find(root, func(n Node) bool {
+		switch n.(type) {
+		case *UserMentionNode, *RoleMentionNode, *SpecialMentionNode:
+			return true
+		}
+		return false
+	}) != nil {
+		t.Errorf("error sanitizing %q: got %s", text, Debug(root))
+	}
+
+	root := SanitizeMentionsPass(parser.Parse("**<@1234>** @here <#1234> `@everyone`"))
+	want := `[[bold [text "<@\u200b1234>"]] [text " "] [text "@\u200bhere"] [text " "] [channelmention "1234"] [text " "] [code "" "@everyone"]]`
+	if got := Debug(root); got != want {
+		t.Errorf("error sanitizing mention nodes: want %s, got %s", want, got)
+	}
+}
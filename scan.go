@@ -0,0 +1,1095 @@
+package formatting
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The scanners of this file are hand-written equivalents of the rule patterns, used instead of the patterns
+// on the hot path of the parser. Each scanner appends to dst exactly what FindStringSubmatchIndex of its pattern returns,
+// and returns nil if the pattern does not match.
+
+/*
+scanMemo records what the scanners learn about the source during a parse, so that they do not scan the same parts
+of the source again at each offset, which would take a time quadratic in the length of the source.
+*/
+type scanMemo struct {
+	// skip is set by a scanner that did not match a source to the number of bytes at its start at which it cannot match
+	// either, for the sources ending at the same position or before, or left to 0.
+	skip int
+	// misses are, by rule index, the last skip of the rule.
+	misses []scanMiss
+	// end is the position in the message of the end of the sources the runs were scanned from.
+	// As the sources all end at the same position, the positions of the runs are stored as distances to their end,
+	// which do not depend on the source offset.
+	end int
+	// word and email are the last runs of \w and [\w.+-] scanned by scanText.
+	word, email textRun
+	// line is the last run of bytes other than \n scanned by scanList.
+	line textRun
+	// delimiters is the last run of delimiters scanned by scanDoubleDelimited.
+	delimiters delimiterRun
+}
+
+// scanMiss records that a rule does not match at the offsets of span, for the sources ending at end or before.
+type scanMiss struct {
+	span Span
+	end  int
+}
+
+// textRun is a run of bytes scanned by scanText, with the distances to the end of the source of its start and end,
+// and of the end of the match following it, or -1.
+type textRun struct {
+	start, end, match int
+}
+
+/*
+delimiterRun is a run of a delimiter byte c, with the positions in the message of its start and end, and the end
+of the source it was scanned in, which is the end of the run if the run was cut by the end of the source.
+
+Unlike textRun, its positions do not depend on the end of the source, as the nested matches in a run of delimiters,
+such as in ******, end at different positions.
+*/
+type delimiterRun struct {
+	c                 byte
+	start, end, limit int
+}
+
+func newScanMemo(rules int) *scanMemo {
+	return &scanMemo{
+		misses: make([]scanMiss, rules),
+	}
+}
+
+// setEnd sets the position in the message of the end of the next sources, clearing the runs if it changed.
+func (m *scanMemo) setEnd(end int) {
+	if end != m.end {
+		m.end = end
+		m.word, m.email, m.line = textRun{}, textRun{}, textRun{}
+	}
+}
+
+// skipped returns whether the rule at index i is known not to match at offset, for a source ending at end.
+func (m *scanMemo) skipped(i int, offset int, end int) bool {
+	miss := m.misses[i]
+	return offset >= miss.span.Start && offset < miss.span.End && end <= miss.end
+}
+
+// run returns the end of the run r of bytes of s matching in starting at p or containing it, and the end of the match
+// following the run as returned by after, or -1. after can be nil if there is no such match.
+func (m *scanMemo) run(r *textRun, s string, p int, in func(c byte) bool, after func(s string, i int) int) (end int, match int) {
+	if d := len(s) - p; d > r.start || d <= r.end {
+		end = p
+		for end < len(s) && in(s[end]) {
+			end++
+		}
+		match = -1
+		if after != nil {
+			match = after(s, end)
+		}
+		*r = textRun{start: d, end: len(s) - end, match: -1}
+		if match >= 0 {
+			r.match = len(s) - match
+		}
+		return end, match
+	}
+	end, match = len(s)-r.end, -1
+	if r.match >= 0 {
+		match = len(s) - r.match
+	}
+	return end, match
+}
+
+// delimiterEnd returns the end of the run of the byte c of s starting at p or containing it.
+func (m *scanMemo) delimiterEnd(s string, p int, c byte) int {
+	// base is the position in the message of the start of s
+	base := m.end - len(s)
+	r := &m.delimiters
+	if r.c == c && base+p >= r.start && base+p < r.end && (r.end < r.limit || m.end <= r.limit) {
+		if r.end < m.end {
+			return r.end - base
+		}
+		return len(s)
+	}
+	end := p
+	for end < len(s) && s[end] == c {
+		end++
+	}
+	*r = delimiterRun{c: c, start: base + p, end: base + end, limit: m.end}
+	return end
+}
+
+// alphanumeric are the ASCII letters and digits, used in the first bytes of rules.
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// isSpaceByte returns whether c matches \s, that is [\t\n\f\r ].
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// isListSpaceByte returns whether c matches [^\S\r\n], that is [\t\f ].
+func isListSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\f'
+}
+
+// isDigitByte returns whether c matches \d, that is [0-9].
+func isDigitByte(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isAlphanumericByte returns whether c matches [a-zA-Z0-9].
+func isAlphanumericByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigitByte(c)
+}
+
+// isWordByte returns whether c matches \w, that is [0-9A-Za-z_].
+func isWordByte(c byte) bool {
+	return isAlphanumericByte(c) || c == '_'
+}
+
+// digits returns the end of the run of digits of s starting at i.
+func digits(s string, i int) int {
+	for i < len(s) && isDigitByte(s[i]) {
+		i++
+	}
+	return i
+}
+
+// scanSoftHyphen matches patternSoftHyphen.
+func scanSoftHyphen(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "\u00ad") {
+		return nil
+	}
+	return append(dst, 0, 2)
+}
+
+// isInvisibleRune returns whether r is one of the invisible characters matched by patternInvisible.
+func isInvisibleRune(r rune) bool {
+	switch {
+	case r == 0x061C, r == 0x200B, r == 0x200C, r == 0x200E, r == 0x200F, r == 0xFEFF:
+		return true
+	case 0x202A <= r && r <= 0x202E, 0x2060 <= r && r <= 0x2064, 0x2066 <= r && r <= 0x2069:
+		return true
+	}
+	return false
+}
+
+// scanInvisible matches patternInvisible.
+func scanInvisible(dst []int, s string, memo *scanMemo) []int {
+	i := 0
+	for i < len(s) {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		if !isInvisibleRune(r) {
+			break
+		}
+		i += w
+	}
+	if i == 0 {
+		return nil
+	}
+	return append(dst, 0, i)
+}
+
+// scanTextVisible returns a scanner matching the text matched by scan up to the first invisible character
+// after its first character, which is then matched by RuleInvisible.
+func scanTextVisible(scan func(dst []int, s string, memo *scanMemo) []int) func(dst []int, s string, memo *scanMemo) []int {
+	return func(dst []int, s string, memo *scanMemo) []int {
+		n := len(dst)
+		dst = scan(dst, s, memo)
+		if len(dst) < n+4 {
+			return dst
+		}
+		_, w := utf8.DecodeRuneInString(s)
+		for i, r := range s[w:dst[n+3]] {
+			if isInvisibleRune(r) {
+				return append(dst[:n], 0, w+i, 0, w+i)
+			}
+		}
+		return dst
+	}
+}
+
+// scanEscape matches patternEscape.
+func scanEscape(dst []int, s string, memo *scanMemo) []int {
+	if len(s) < 2 || s[0] != '\\' || isAlphanumericByte(s[1]) || isSpaceByte(s[1]) {
+		return nil
+	}
+	_, w := utf8.DecodeRuneInString(s[1:])
+	return append(dst, 0, 1+w, 1, 1+w)
+}
+
+// scanCodeInline matches patternCodeInline.
+func scanCodeInline(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "`") {
+		return nil
+	}
+	if strings.HasPrefix(s, "``") {
+		if i := strings.IndexByte(s[2:], '`'); i >= 0 && strings.HasPrefix(s[2+i:], "``") {
+			return append(dst, 0, 2+i+2, 2, 2+i, -1, -1)
+		}
+	}
+	i := strings.IndexByte(s[1:], '`')
+	if i < 0 {
+		return nil
+	}
+	return append(dst, 0, 1+i+1, -1, -1, 1, 1+i)
+}
+
+// scanBlockQuote matches patternBlockQuote.
+func scanBlockQuote(dst []int, s string, memo *scanMemo) []int {
+	i := 0
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	if strings.HasPrefix(s[i:], ">>> ") {
+		return append(dst, 0, len(s), i+4, len(s), -1, -1)
+	}
+	if !strings.HasPrefix(s[i:], "> ") {
+		return nil
+	}
+	// the quote continues with the next lines made of spaces, > and a space
+	end := len(s)
+	for {
+		j := strings.IndexByte(s[i:], '\n')
+		if j < 0 {
+			break
+		}
+		end = i + j
+		i = end + 1
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if !strings.HasPrefix(s[i:], "> ") {
+			end++
+			break
+		}
+		end = len(s)
+	}
+	return append(dst, 0, end, -1, -1, 0, end)
+}
+
+// scanCodeBlock matches patternCodeBlock.
+//
+// The content starts with a character other than \n and ends before the first following ```,
+// so whether there is a match only depends on where the content starts: the positions allowed by the language
+// and the whitespace before the content are tried in the order of the pattern.
+func scanCodeBlock(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "```") {
+		return nil
+	}
+	// the content must start before the last ```
+	last := strings.LastIndex(s[3:], "```") + 3
+	content := func(q int) bool {
+		return q < len(s) && s[q] != '\n' && q+1 <= last
+	}
+	language := 3
+	for language < len(s) && (isWordByte(s[language]) || strings.IndexByte("+-.", s[language]) >= 0) {
+		language++
+	}
+	// the whitespace can only start after the whole language, which has no whitespace
+	space := language
+	for space < len(s) && isSpaceByte(s[space]) {
+		space++
+	}
+	for k := space - 1; k >= language; k-- {
+		if s[k] == '\n' && content(k+1) {
+			if language == 3 {
+				return appendCodeBlock(dst, s, -1, -1, language, k+1)
+			}
+			return appendCodeBlock(dst, s, 3, language, language, k+1)
+		}
+	}
+	if content(3) {
+		return appendCodeBlock(dst, s, -1, -1, -1, 3)
+	}
+	return nil
+}
+
+// appendCodeBlock appends the match of patternCodeBlock with the passed language and whitespace groups,
+// and the content starting at q.
+func appendCodeBlock(dst []int, s string, languageStart int, languageEnd int, space int, q int) []int {
+	t := q + 1 + strings.Index(s[q+1:], "```")
+	c := t
+	for c > q+1 && s[c-1] == '\n' {
+		c--
+	}
+	spaceEnd := -1
+	if space >= 0 {
+		spaceEnd = q
+	}
+	return append(dst, 0, t+3, languageStart, languageEnd, space, spaceEnd, q, c)
+}
+
+// scanURLEnd matches https?://[^\s<]+[^<.,:;"')\]\s] at the start of s, and returns the ends of the matches,
+// from the longest, to yield, until yield returns true. It returns the end passed to yield that returned true, or -1.
+func scanURLEnd(s string, yield func(end int) bool) int {
+	var p int
+	if strings.HasPrefix(s, "http://") {
+		p = 7
+	} else if strings.HasPrefix(s, "https://") {
+		p = 8
+	} else {
+		return -1
+	}
+	e := p
+	for e < len(s) && !isSpaceByte(s[e]) && s[e] != '<' {
+		e++
+	}
+	for end := e; end > p; {
+		r, w := utf8.DecodeLastRuneInString(s[p:end])
+		if end-w <= p {
+			// the last character must follow at least one character
+			break
+		}
+		if !strings.ContainsRune(".,:;\"')]", r) && yield(end) {
+			return end
+		}
+		end -= w
+	}
+	return -1
+}
+
+// scanURL matches patternURL.
+func scanURL(dst []int, s string, memo *scanMemo) []int {
+	end := scanURLEnd(s, func(end int) bool {
+		return true
+	})
+	if end < 0 {
+		return nil
+	}
+	return append(dst, 0, end, 0, end)
+}
+
+// scanURLNoEmbed matches patternURLNoEmbed.
+func scanURLNoEmbed(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "<") {
+		return nil
+	}
+	url := s[1:]
+	end := scanURLEnd(url, func(end int) bool {
+		return end < len(url) && url[end] == '>'
+	})
+	if end < 0 {
+		return nil
+	}
+	return append(dst, 0, end+2, 1, end+1)
+}
+
+// scanSpoiler matches patternSpoiler.
+func scanSpoiler(dst []int, s string, memo *scanMemo) []int {
+	if len(s) < 3 || !strings.HasPrefix(s, "||") {
+		return nil
+	}
+	_, w := utf8.DecodeRuneInString(s[2:])
+	i := strings.Index(s[2+w:], "||")
+	if i < 0 {
+		return nil
+	}
+	end := 2 + w + i
+	return append(dst, 0, end+2, 2, end)
+}
+
+// scanCustomEmoji matches patternCustomEmoji.
+func scanCustomEmoji(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "<") {
+		return nil
+	}
+	animatedStart, animatedEnd := -1, -1
+	i := 1
+	if strings.HasPrefix(s[i:], "a") {
+		animatedStart, animatedEnd = 1, 2
+		i++
+	}
+	if !strings.HasPrefix(s[i:], ":") {
+		return nil
+	}
+	nameStart := i + 1
+	nameEnd := nameStart
+	for nameEnd < len(s) && isWordByte(s[nameEnd]) {
+		nameEnd++
+	}
+	if nameEnd == nameStart || !strings.HasPrefix(s[nameEnd:], ":") {
+		return nil
+	}
+	idStart := nameEnd + 1
+	idEnd := digits(s, idStart)
+	if idEnd == idStart || !strings.HasPrefix(s[idEnd:], ">") {
+		return nil
+	}
+	return append(dst, 0, idEnd+1, animatedStart, animatedEnd, nameStart, nameEnd, idStart, idEnd)
+}
+
+// scanNamedEmoji matches patternNamedEmoji.
+//
+// The name is made of characters other than whitespace and :, so it can only end before the : ending its run,
+// optionally followed by a skin tone.
+func scanNamedEmoji(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, ":") {
+		return nil
+	}
+	i := 1
+	for i < len(s) && s[i] != ':' && !isSpaceByte(s[i]) {
+		i++
+	}
+	if i == 1 || i == len(s) || s[i] != ':' {
+		return nil
+	}
+	if tone := i + len("::skin-tone-"); len(s) > tone+1 && strings.HasPrefix(s[i:], "::skin-tone-") && isDigitByte(s[tone]) && s[tone+1] == ':' {
+		return append(dst, 0, tone+2, 1, tone+1)
+	}
+	return append(dst, 0, i+1, 1, i)
+}
+
+// scanEmoticon matches patternUnescapeEmoticon.
+func scanEmoticon(dst []int, s string, memo *scanMemo) []int {
+	const emoticon = "¯\\_(ツ)_/¯"
+	if !strings.HasPrefix(s, emoticon) {
+		return nil
+	}
+	return append(dst, 0, len(emoticon), 0, len(emoticon))
+}
+
+// scanID matches prefix followed by an ID made of digits and >, as in patternChannelMention.
+func scanID(dst []int, s string, prefix string) []int {
+	if !strings.HasPrefix(s, prefix) {
+		return nil
+	}
+	end := digits(s, len(prefix))
+	if end == len(prefix) || !strings.HasPrefix(s[end:], ">") {
+		return nil
+	}
+	return append(dst, 0, end+1, len(prefix), end)
+}
+
+// scanChannelMention matches patternChannelMention.
+func scanChannelMention(dst []int, s string, memo *scanMemo) []int {
+	return scanID(dst, s, "<#")
+}
+
+// scanRoleMention matches patternRoleMention.
+func scanRoleMention(dst []int, s string, memo *scanMemo) []int {
+	return scanID(dst, s, "<@&")
+}
+
+// scanUserMention matches patternUserMention.
+func scanUserMention(dst []int, s string, memo *scanMemo) []int {
+	if strings.HasPrefix(s, "<@!") {
+		return scanID(dst, s, "<@!")
+	}
+	return scanID(dst, s, "<@")
+}
+
+// scanSpecialMention matches patternSpecialMention.
+func scanSpecialMention(dst []int, s string, memo *scanMemo) []int {
+	for _, mention := range []string{"@everyone", "@here"} {
+		if strings.HasPrefix(s, mention) {
+			return append(dst, 0, len(mention), 1, len(mention))
+		}
+	}
+	return nil
+}
+
+// scanTimestamp matches patternTimestamp.
+func scanTimestamp(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "<t:") {
+		return nil
+	}
+	stampStart := 3
+	i := stampStart
+	if strings.HasPrefix(s[i:], "-") {
+		i++
+	}
+	end := digits(s, i)
+	if end == i || end-i > 17 {
+		return nil
+	}
+	if len(s) >= end+3 && s[end] == ':' && strings.IndexByte("tTdDfFR", s[end+1]) >= 0 && s[end+2] == '>' {
+		return append(dst, 0, end+3, stampStart, end, end+1, end+2)
+	}
+	if !strings.HasPrefix(s[end:], ">") {
+		return nil
+	}
+	return append(dst, 0, end+1, stampStart, end, -1, -1)
+}
+
+// scanUnknownTag matches patternUnknownTag.
+func scanUnknownTag(dst []int, s string, memo *scanMemo) []int {
+	if !strings.HasPrefix(s, "<") {
+		return nil
+	}
+	i := 1
+	for i < len(s) && isWordByte(s[i]) {
+		i++
+	}
+	if i == 1 || i == len(s) || s[i] != ':' {
+		return nil
+	}
+	content := i + 1
+	if content < len(s) && s[content] == '>' {
+		return append(dst, 0, content+1, 1, i, content, content)
+	}
+	if content == len(s) || strings.IndexByte("/<>", s[content]) >= 0 || isSpaceByte(s[content]) {
+		return nil
+	}
+	end := strings.IndexAny(s[content+1:], "<>\n")
+	if end < 0 || s[content+1+end] != '>' {
+		return nil
+	}
+	end += content + 1
+	return append(dst, 0, end+1, 1, i, content, end)
+}
+
+// scanNewline matches patternNewline.
+func scanNewline(dst []int, s string, memo *scanMemo) []int {
+	last := -1
+	for i := 0; i < len(s) && s[i] == '\n'; {
+		last = i
+		i++
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+	}
+	if last < 0 {
+		return nil
+	}
+	return append(dst, 0, last+1)
+}
+
+// scanHeader matches patternHeaderItem.
+//
+// The content is the rest of the line after the # and their space, so only the whitespace before the # is scanned
+// before knowing whether there is a match.
+func scanHeader(dst []int, s string, memo *scanMemo) []int {
+	i := 0
+	for i < len(s) && isSpaceByte(s[i]) {
+		i++
+	}
+	n := 0
+	for i+n < len(s) && s[i+n] == '#' {
+		n++
+	}
+	if n == 0 || n > 3 || i+n == len(s) || s[i+n] != ' ' {
+		// the whitespace at any later offset of the run would end at the same position
+		memo.skip = i
+		return nil
+	}
+	content := i + n + 1
+	end := len(s)
+	if j := strings.IndexByte(s[content:], '\n'); j >= 0 {
+		end = content + j
+	}
+	matchEnd := end
+	if end < len(s) {
+		matchEnd++
+	}
+	return append(dst, 0, matchEnd, 0, end, i, i+n, content, end)
+}
+
+/*
+scanList matches patternListItem.
+
+The content of a list item can itself start with a list item, as in "- - a", so the end of the line of the content
+is recorded in memo, so that it is not scanned again for each of the nested items, which would take a time quadratic
+in the length of the line.
+*/
+func scanList(dst []int, s string, memo *scanMemo) []int {
+	i := 0
+	for i < len(s) && isListSpaceByte(s[i]) {
+		i++
+	}
+	if i == len(s) || s[i] != '*' && s[i] != '-' || i+1 == len(s) || !isSpaceByte(s[i+1]) {
+		// the indentation at any later offset of the run would end at the same position
+		memo.skip = i
+		return nil
+	}
+	// the whitespace after the delimiter, including line breaks, is never part of the content
+	content := i + 1
+	for content < len(s) && isSpaceByte(s[content]) {
+		content++
+	}
+	lineEnd, _ := memo.run(&memo.line, s, content, func(c byte) bool { return c != '\n' }, nil)
+	// the item continues with the following indented lines, that do not start with whitespace,
+	// or with * or - and whitespace
+	end := lineEnd
+	for end < len(s) {
+		q := end + 1
+		for q < len(s) && isListSpaceByte(s[q]) {
+			q++
+		}
+		if q == end+1 || q == len(s) || isSpaceByte(s[q]) {
+			break
+		}
+		if (s[q] == '*' || s[q] == '-') && (q+1 == len(s) || isSpaceByte(s[q+1])) {
+			break
+		}
+		end = q + strings.IndexByte(s[q:], '\n')
+		if end < q {
+			end = len(s)
+		}
+	}
+	if end == len(s) {
+		return append(dst, 0, end, 0, i, content, lineEnd, lineEnd, end, -1, -1)
+	}
+	return append(dst, 0, end+1, 0, i, content, lineEnd, lineEnd, end, end, end+1)
+}
+
+// scanLineBreak matches patternLineBreak.
+func scanLineBreak(dst []int, s string, memo *scanMemo) []int {
+	if strings.HasPrefix(s, "\\\n") {
+		return append(dst, 0, 2)
+	}
+	i := 0
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	if i < 2 || i == len(s) || s[i] != '\n' {
+		memo.skip = i
+		return nil
+	}
+	return append(dst, 0, i+1)
+}
+
+// scanDoubleDelimited returns a scanner matching a content delimited by two c,
+// not followed by another c, as in patternBold.
+func scanDoubleDelimited(c byte) func(dst []int, s string, memo *scanMemo) []int {
+	delimiter := string([]byte{c, c})
+	return func(dst []int, s string, memo *scanMemo) []int {
+		if len(s) < 3 || !strings.HasPrefix(s, delimiter) {
+			return nil
+		}
+		_, w := utf8.DecodeRuneInString(s[2:])
+		from := 2 + w
+		i := strings.Index(s[from:], delimiter)
+		if i < 0 {
+			// the content of a match at any later offset would start after from
+			memo.skip = len(s)
+			return nil
+		}
+		// the delimiter must end a run of c, as it cannot be followed by another c
+		after := memo.delimiterEnd(s, from+i+2, c)
+		end := after - 2
+		if after == len(s) {
+			return append(dst, 0, after, 0, after, 2, end)
+		}
+		_, w = utf8.DecodeRuneInString(s[after:])
+		return append(dst, 0, after+w, 0, after, 2, end)
+	}
+}
+
+var (
+	scanBold      = scanDoubleDelimited('*')
+	scanUnderline = scanDoubleDelimited('_')
+)
+
+// scanStrikethrough matches patternStrikethrough.
+func scanStrikethrough(dst []int, s string, memo *scanMemo) []int {
+	if len(s) < 3 || !strings.HasPrefix(s, "~~") || isSpaceByte(s[2]) {
+		return nil
+	}
+	_, w := utf8.DecodeRuneInString(s[2:])
+	for from := 2 + w; ; {
+		i := strings.Index(s[from:], "~~")
+		if i < 0 {
+			// the closing delimiters of a match at any later offset would be among the ones already rejected
+			memo.skip = len(s)
+			return nil
+		}
+		end := from + i
+		if !isSpaceByte(s[end-1]) {
+			return append(dst, 0, end+2, 2, end)
+		}
+		from = end + 1
+	}
+}
+
+// isTextRune returns whether r matches [0-9A-Za-z\s\x{00c0}-\x{ffff}], the characters that do not end text.
+func isTextRune(r rune) bool {
+	return r < utf8.RuneSelf && (isAlphanumericByte(byte(r)) || isSpaceByte(byte(r))) || 0xC0 <= r && r <= 0xFFFF
+}
+
+// isEmailByte returns whether c matches [\w.+-], the characters of the local part of an email in patternText.
+func isEmailByte(c byte) bool {
+	return isWordByte(c) || c == '.' || c == '+' || c == '-'
+}
+
+/*
+scanText matches patternText: the shortest non-empty text followed by either a character that could start
+another rule, a newline, at least two spaces and a newline, a word followed by a colon and a non-space character
+(as in a URL scheme), an email address, or the end of the source.
+
+The alternatives that start with a run of characters, such as \w+:\S, hold for all the positions of the run
+if they hold for one, so they are only checked once per run, keeping the scanner linear.
+*/
+func scanText(dst []int, s string, memo *scanMemo) []int {
+	if s == "" {
+		return nil
+	}
+	_, w := utf8.DecodeRuneInString(s)
+	// wordEnd and emailEnd are the ends of the runs of \w and [\w.+-] containing the position,
+	// and wordMatch and emailMatch are the ends of the match of \w+:\S and of the email from these runs, or -1.
+	wordEnd, wordMatch := -1, -1
+	emailEnd, emailMatch := -1, -1
+	for p := w; p < len(s); {
+		c := s[p]
+		r, rw := utf8.DecodeRuneInString(s[p:])
+		if !isTextRune(r) {
+			return append(dst, 0, p+rw, 0, p)
+		}
+		if c == '\n' {
+			return append(dst, 0, p+1, 0, p)
+		}
+		if c == ' ' {
+			i := p
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+			if i-p >= 2 && i < len(s) && s[i] == '\n' {
+				return append(dst, 0, i+1, 0, p)
+			}
+			// the spaces of the rest of the run are followed by the same byte, and are not in \w or [\w.+-]
+			p = i
+			continue
+		}
+		if isWordByte(c) {
+			if p >= wordEnd {
+				wordEnd, wordMatch = memo.run(&memo.word, s, p, isWordByte, scanTextWordColon)
+			}
+			if wordMatch >= 0 {
+				return append(dst, 0, wordMatch, 0, p)
+			}
+		}
+		if isEmailByte(c) {
+			if p >= emailEnd {
+				emailEnd, emailMatch = memo.run(&memo.email, s, p, isEmailByte, scanTextEmailDomain)
+			}
+			if emailMatch >= 0 {
+				return append(dst, 0, emailMatch, 0, p)
+			}
+		}
+		p += rw
+	}
+	return append(dst, 0, len(s), 0, len(s))
+}
+
+// scanTextWordColon matches :\S at i, returning the end of the match or -1.
+func scanTextWordColon(s string, i int) int {
+	if len(s) < i+2 || s[i] != ':' || isSpaceByte(s[i+1]) {
+		return -1
+	}
+	_, w := utf8.DecodeRuneInString(s[i+1:])
+	return i + 1 + w
+}
+
+// scanTextEmailDomain matches @[a-zA-Z0-9][a-zA-Z0-9-]*\.[a-zA-Z0-9] at i, returning the end of the match or -1.
+func scanTextEmailDomain(s string, i int) int {
+	if len(s) < i+2 || s[i] != '@' || !isAlphanumericByte(s[i+1]) {
+		return -1
+	}
+	i += 2
+	for i < len(s) && (isAlphanumericByte(s[i]) || s[i] == '-') {
+		i++
+	}
+	if len(s) < i+2 || s[i] != '.' || !isAlphanumericByte(s[i+1]) {
+		return -1
+	}
+	return i + 2
+}
+
+// scanItalics matches patternItalics.
+//
+// The tokens of the content of both alternatives can be found from their first character only,
+// so the content is scanned token by token, checking for the closing delimiter between tokens.
+func scanItalics(dst []int, s string, memo *scanMemo) []int {
+	if strings.HasPrefix(s, "_") {
+		// the content is made of __, escaped characters, and characters other than \ and _
+		for q, tokens := 1, 0; q < len(s); tokens++ {
+			if tokens > 0 && s[q] == '_' && (q+1 == len(s) || !isWordByte(s[q+1])) {
+				return append(dst, 0, q+1, 0, q+1, 1, q, -1, -1, -1, -1)
+			}
+			switch s[q] {
+			case '_':
+				if !strings.HasPrefix(s[q:], "__") {
+					return nil
+				}
+				q += 2
+			case '\\':
+				if q+1 == len(s) {
+					return nil
+				}
+				_, w := utf8.DecodeRuneInString(s[q+1:])
+				q += 1 + w
+			default:
+				_, w := utf8.DecodeRuneInString(s[q:])
+				q += w
+			}
+		}
+		return nil
+	}
+	if strings.HasPrefix(s, "*") {
+		// the content is made of **, spaces followed by ** or a character other than * and spaces,
+		// and characters other than * and spaces
+		for q, tokens := 1, 0; q < len(s); tokens++ {
+			if tokens > 0 && s[q] == '*' && (q+1 == len(s) || s[q+1] != '*') {
+				end := q + 1
+				if end < len(s) {
+					_, w := utf8.DecodeRuneInString(s[end:])
+					end += w
+				}
+				return append(dst, 0, end, -1, -1, -1, -1, 0, q+1, 1, q)
+			}
+			switch {
+			case s[q] == '*':
+				if !strings.HasPrefix(s[q:], "**") {
+					return nil
+				}
+				q += 2
+			case isSpaceByte(s[q]):
+				if tokens == 0 {
+					return nil
+				}
+				for q < len(s) && isSpaceByte(s[q]) {
+					q++
+				}
+				if strings.HasPrefix(s[q:], "**") {
+					q += 2
+				} else if q < len(s) && s[q] != '*' {
+					_, w := utf8.DecodeRuneInString(s[q:])
+					q += w
+				} else {
+					return nil
+				}
+			default:
+				_, w := utf8.DecodeRuneInString(s[q:])
+				q += w
+			}
+		}
+	}
+	return nil
+}
+
+/*
+scanMaskedLink matches patternMaskedLink.
+
+The mask is made of [ followed by either bracketed texts without ] or characters other than ], and ], so its brackets
+can end at each ] following a [ after the previous ], and the ones ending further are tried first. The brackets can be
+followed by a text without [ and a ], so the mask ends either after one of the ] of the text that follows, from the last
+one to the first one, or right after the brackets. The masks are tried in this order, until one is followed by a target.
+*/
+func scanMaskedLink(dst []int, s string, memo *scanMemo) []int {
+	// a masked link contains ]( followed by ), which are much faster to look for than running the pattern
+	i := strings.Index(s, "](")
+	if i < 0 || strings.IndexByte(s[i:], ')') < 0 {
+		memo.skip = len(s)
+		return nil
+	}
+	if s[0] != '[' {
+		return nil
+	}
+	// brackets are the positions of the ] that can end the brackets of the mask
+	var brackets []int
+	for p := 1; ; {
+		j := strings.IndexByte(s[p:], ']')
+		if j < 0 {
+			break
+		}
+		brackets = append(brackets, p+j)
+		if strings.IndexByte(s[p:p+j], '[') < 0 {
+			break
+		}
+		p += j + 1
+	}
+	targets := targetMemo{failed: -1, quoteFrom: len(s), quote: -1}
+	for k := len(brackets) - 1; k >= 0; k-- {
+		p := brackets[k] + 1
+		text := len(s)
+		if j := strings.IndexByte(s[p:], '['); j >= 0 {
+			text = p + j
+		}
+		for m := text; m >= p; m-- {
+			if m > p && s[m-1] != ']' || m == len(s) || s[m] != '(' {
+				continue
+			}
+			if g := appendMaskedLinkTarget(dst, s, m, &targets); len(g) > len(dst) {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+/*
+targetMemo records what appendMaskedLinkTarget learned about the source when matching the targets of the previous masks,
+which end further, so that the parts of the targets they share are not scanned again.
+*/
+type targetMemo struct {
+	// failed is the start of the last target that did not match.
+	failed int
+	// quote is the first quote after quoteFrom that can end a title, or -1.
+	quoteFrom, quote int
+}
+
+// titleEnd returns the first quote after k followed by optional whitespace and ), or -1.
+func (m *targetMemo) titleEnd(s string, k int) int {
+	if k >= m.quoteFrom && (m.quote < 0 || k < m.quote) {
+		return m.quote
+	}
+	end, quote := len(s), -1
+	if k < m.quoteFrom {
+		// the quotes after quoteFrom were already scanned
+		end, quote = m.quoteFrom+1, m.quote
+	}
+	for q := k + 1; q < end && q < len(s); q++ {
+		if s[q] != '"' && s[q] != '\'' {
+			continue
+		}
+		e := q + 1
+		for e < len(s) && isSpaceByte(s[e]) {
+			e++
+		}
+		if e < len(s) && s[e] == ')' {
+			quote = q
+			break
+		}
+	}
+	m.quoteFrom, m.quote = k, quote
+	return quote
+}
+
+/*
+appendMaskedLinkTarget appends the match of patternMaskedLink with the mask ending at the ( at m, if the target
+and optional title of the link that follow match.
+
+The target is made of non-space characters other than \ and escaped characters, optionally between < and >, followed
+by either whitespace, a quoted title and ), or ). It is as short as possible, so the ends of its tokens are tried in order.
+When no target matches after the whitespace following the (, the target can also be empty, before the last of these
+spaces, and followed by a title.
+
+Whether a target matches after one of its tokens only depends on the position of the token, so the target is not matched
+further when it reaches the start of a target that did not match, which is stored in memo.
+*/
+func appendMaskedLinkTarget(dst []int, s string, m int, memo *targetMemo) []int {
+	space := func(i int) int {
+		for i < len(s) && isSpaceByte(s[i]) {
+			i++
+		}
+		return i
+	}
+	// title matches the optional title and ) after the target ending at t, returning the title start and end or -1,
+	// and the end of the match or -1
+	title := func(t int) (int, int, int) {
+		if k := space(t); k > t && k < len(s) && (s[k] == '"' || s[k] == '\'') {
+			if q := memo.titleEnd(s, k); q >= 0 {
+				return k + 1, q, space(q+1) + 1
+			}
+		}
+		if e := space(t); e < len(s) && s[e] == ')' {
+			return -1, -1, e + 1
+		}
+		return -1, -1, -1
+	}
+	w := space(m + 1)
+	starts := []int{w}
+	if w < len(s) && s[w] == '<' {
+		starts = []int{w + 1, w}
+	}
+	for _, start := range starts {
+		for t := start; t != memo.failed; {
+			ends := []int{t}
+			if t < len(s) && s[t] == '>' {
+				ends = []int{t + 1, t}
+			}
+			for _, end := range ends {
+				if titleStart, titleEnd, e := title(end); e >= 0 {
+					return append(dst, 0, e, 0, m, start, t, titleStart, titleEnd)
+				}
+			}
+			if t == len(s) || isSpaceByte(s[t]) {
+				break
+			}
+			if s[t] == '\\' {
+				if t+1 == len(s) || s[t+1] == '\n' {
+					break
+				}
+				_, rw := utf8.DecodeRuneInString(s[t+1:])
+				t += 1 + rw
+			} else {
+				_, rw := utf8.DecodeRuneInString(s[t:])
+				t += rw
+			}
+		}
+		memo.failed = start
+	}
+	if w > m+1 {
+		if titleStart, titleEnd, e := title(w - 1); titleStart >= 0 {
+			return append(dst, 0, e, 0, m, w-1, w-1, titleStart, titleEnd)
+		}
+	}
+	return dst
+}
+
+// isEmailLocalByte returns whether c matches [a-zA-Z0-9.!#$%&'+/=?^_{}-], the characters of the local part of an email
+// in patternEmail.
+func isEmailLocalByte(c byte) bool {
+	return isAlphanumericByte(c) || strings.IndexByte(".!#$%&'+/=?^_{}-", c) >= 0
+}
+
+// emailLabel returns the end of the longest domain label of patternEmail starting at i, made of up to 63 letters,
+// digits and -, starting and ending with a letter or a digit, and the end of the run of letters, digits and - starting
+// at i, or -1 and -1 if there is no label at i.
+func emailLabel(s string, i int) (end int, run int) {
+	if i >= len(s) || !isAlphanumericByte(s[i]) {
+		return -1, -1
+	}
+	run = i + 1
+	for run < len(s) && (isAlphanumericByte(s[run]) || s[run] == '-') {
+		run++
+	}
+	end = run
+	if end > i+63 {
+		end = i + 63
+	}
+	for s[end-1] == '-' {
+		end--
+	}
+	return end, run
+}
+
+/*
+scanEmail matches patternEmail.
+
+The labels of the domain cannot contain ., so the first one must be the whole run of letters, digits and - after the @,
+as it must be followed by a ., and the following ones are the longest labels at their position.
+*/
+func scanEmail(dst []int, s string, memo *scanMemo) []int {
+	i := 0
+	for i < len(s) && isEmailLocalByte(s[i]) {
+		i++
+	}
+	if i == 0 || i == len(s) || s[i] != '@' {
+		// the local part at any later offset of the run would end at the same position
+		memo.skip = i
+		return nil
+	}
+	end, run := emailLabel(s, i+1)
+	if end < 0 || end != run || end+1 >= len(s) || s[end] != '.' {
+		// the domain at any later offset of the run would be the same
+		memo.skip = i
+		return nil
+	}
+	for end+1 < len(s) && s[end] == '.' {
+		label, run := emailLabel(s, end+1)
+		if label < 0 {
+			break
+		}
+		end = label
+		if label != run {
+			break
+		}
+	}
+	if end == run {
+		// the first label must be followed by at least one other label
+		memo.skip = i
+		return nil
+	}
+	return append(dst, 0, end, 0, end)
+}
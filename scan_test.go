@@ -0,0 +1,220 @@
+//go:build !noregexp
+
+package formatting
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanCorpus returns inputs for comparing the scanners to the patterns: the test messages of this package,
+// and random combinations of characters that are meaningful to the rules.
+func scanCorpus() []string {
+	corpus := []string{
+		"", "a", "**a**", "**a***", "***a***", "** **", "__a__b", "___a___", "~~ a~~", "~~a ~~a~~", "~~a~~~",
+		"||a||", "||||||", "`a`", "``a`b``", "``a``", "``", "<#1>", "<#>", "<@&12>", "<@!3>", "<@!>", "<@4>",
+		"<a:b_c:1>", "<:a:1>", "<a:1>", "<t:1>", "<t:-1:R>", "<t:1:x>", "<t:123456789012345678>", "@everyone", "@her",
+		"­", "¯\\_(ツ)_/¯", "\\*", "\\a", "\\", "\\é", "\n", "\n  \n \nx", "a  \nb", "ab:c", "ab: c", "a.b+c@d.e",
+		"a@b", "éa", "a😀", "a\xffb", "a_b:c", "a-b@c-d.e", "word:", "x@y.", "a b", "a\tb",
+		"```js\n```", "```js\na```", "``` \n\na\n\n```", "```a```", "``````", "```\n```", "```js \n \nb\n```", "```a\n\n```\n```",
+		"<http://a>>", "<http://a.>", "<http://a", "http://a.b.", "http://a)", "https://é.", "http://😀", "http://a\xff.",
+		">>> a", "  > a\nb", "> \n", ">>>  \n", "> >", ">>a",
+		"a\\\nb", "   \n", " \n", "  a\n", "\u200b", "a\u200bb", "\u2066a\u2069", "\u200e\u200f\ufeffa", "a\u200d",
+		"# a", "## a\nb", "#### a", "#a", " \n ### a  \n", "# ", "#", "\t# a\r\n",
+		"- a", "- a\n  b\n  - c\n  -d\n", "* \n\na", "-", "- ", " \t- a\n\tb", "-a", "- a\n\n  b", "- a\n  \n", "- a\n  *", "- a\n b\xff",
+		"******", "**a*****", "_____a__", "__a__ __",
+		"[a](<b> 'c')", "[a]( \"t\")", "[[a]b](c)", "[a]]x](y)", "[a](b\\ c)", "a@b-.c.d", "a@b.c-d.e", ":a::skin-tone-2:",
+		":a b:", "<x:y>", "<x:>", "<x:/y>", "<x:y\n>",
+	}
+	alphabet := []string{"*", "_", "~", "|", "`", "<", ">", "@", "#", ":", "-", "!", "&", "\n", " ", "\t", "\\", "a", "t",
+		"1", ".", "+", "[", "]", "(", ")", "\"", "https://a", "a@b.c", "{", "é", "😀", "¯", "­", "\xff", "everyone", "here", "<t:", "<@", "<#", "<a:",
+		"```", "js", "> ", ">>> ", "http://", "https://", "'", ";", "\u200b", "\u202e", "# ", "## ", "\r", "- ", "  - "}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		var sb strings.Builder
+		for j := r.Intn(12); j >= 0; j-- {
+			sb.WriteString(alphabet[r.Intn(len(alphabet))])
+		}
+		corpus = append(corpus, sb.String())
+	}
+	return corpus
+}
+
+func TestScanners(t *testing.T) {
+	corpus := scanCorpus()
+	for _, r := range parserRules {
+		if r.scan == nil {
+			continue
+		}
+		for _, s := range corpus {
+			want := r.pattern.FindStringSubmatchIndex(s)
+			if got := r.scan(nil, s, newScanMemo(0)); !reflect.DeepEqual(got, want) {
+				t.Errorf("rule %s on %q: want %v, got %v", r.name, s, want, got)
+			}
+		}
+	}
+}
+
+func TestInviteCode(t *testing.T) {
+	urls := []string{
+		"https://discord.gg/abc", "http://discord.gg/abc/", "https://www.discord.gg/a-b?x", "https://discord.com/invite/abc#x",
+		"https://discordapp.com/invite/abc/?x\ny", "https://discordapp.com/invite/abc/?x", "https://discord.gg/", "https://discord.gg/abc/d",
+		"https://discord.gg/abc//", "https://www.www.discord.gg/abc", "ftp://discord.gg/abc", "https://discord.com/abc", "https://discord.gg/é",
+	}
+	for _, url := range urls {
+		want := ""
+		if match := patternInvite.FindStringSubmatch(url); match != nil {
+			want = match[1]
+		}
+		if got := inviteCode(url); got != want {
+			t.Errorf("invite code of %q: want %q, got %q", url, want, got)
+		}
+	}
+}
+
+func TestScanMemo(t *testing.T) {
+	corpus := scanCorpus()
+	r := rand.New(rand.NewSource(1))
+	for i, rule := range parserRules {
+		if rule.scan == nil {
+			continue
+		}
+		// scan each source at all its offsets with the same memo, as the parser does for consecutive nodes,
+		// with random ends, as the parser does for nested nodes
+		for j := 0; j+8 <= len(corpus); j += 8 {
+			source := strings.Join(corpus[j:j+8], "")
+			memo := newScanMemo(len(parserRules))
+			end := len(source)
+			for offset := 0; offset < len(source); offset++ {
+				if end <= offset || r.Intn(4) == 0 {
+					end = offset + 1 + r.Intn(len(source)-offset)
+				}
+				want := rule.scan(nil, source[offset:end], newScanMemo(0))
+				if memo.skipped(i, offset, end) {
+					if want != nil {
+						t.Errorf("rule %s on %q at %d: skipped, but matched %v", rule.name, source[:end], offset, want)
+					}
+					continue
+				}
+				memo.setEnd(end)
+				memo.skip = 0
+				got := rule.scan(nil, source[offset:end], memo)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("rule %s on %q at %d: want %v, got %v", rule.name, source[:end], offset, want, got)
+				}
+				if got == nil && memo.skip > 0 {
+					memo.misses[i] = scanMiss{span: Span{Start: offset, End: offset + memo.skip}, end: end}
+				}
+			}
+		}
+	}
+}
+
+func TestScannersFirst(t *testing.T) {
+	corpus := scanCorpus()
+	for _, r := range parserRules {
+		if r.first == "" {
+			continue
+		}
+		for _, s := range corpus {
+			g := r.pattern.FindStringSubmatchIndex(s)
+			if g != nil && g[1] > 0 && !strings.Contains(r.first, s[:1]) {
+				t.Errorf("rule %s on %q: matched, but %q is not in its first bytes", r.name, s, s[:1])
+			}
+		}
+	}
+}
+
+func TestRuleSet(t *testing.T) {
+	if len(parserRules) > 64 {
+		t.Fatalf("rule sets are cached by a mask of %d bits, but there are %d rules", 64, len(parserRules))
+	}
+	rules := enabledRules(&DefaultParserOptions)
+	set := newRuleSet(rules)
+	for lineStart := range set.candidates {
+		for c := range set.candidates[lineStart] {
+			var want []uint8
+			for i, r := range rules {
+				if r.block && lineStart == 0 || r.first != "" && !strings.Contains(r.first, string([]byte{byte(c)})) {
+					continue
+				}
+				want = append(want, uint8(i))
+			}
+			if got := set.candidates[lineStart][c]; !reflect.DeepEqual(got, want) {
+				t.Errorf("line start %d, byte %q: want %v, got %v", lineStart, c, want, got)
+			}
+		}
+	}
+}
+
+func TestNewParser(t *testing.T) {
+	if Default() != Default() {
+		t.Errorf("Default returned different parsers")
+	}
+	options := DefaultParserOptions
+	options.EnableMaskedLinks = true
+	if a, b := NewParser(&options), NewParser(&options); a.rules != b.rules {
+		t.Errorf("parsers with the same options have different rule sets")
+	}
+	if a, b := NewParser(&options), NewParser(nil); a.rules == b.rules {
+		t.Errorf("parsers with different options have the same rule set")
+	}
+	// only the Parser itself is allocated
+	if allocs := testing.AllocsPerRun(100, func() { NewParser(&options) }); allocs > 1 {
+		t.Errorf("NewParser: want at most 1 allocation, got %v", allocs)
+	}
+}
+
+// adversarialUnits are repeated to build messages that used to take a time quadratic in their length,
+// or worse, to parse.
+var adversarialUnits = []string{
+	"||", "**", "__", "~~", "*", "_", "`", "```", "[", "[a]", "](", "[a](", "[](", "<", "<@", "<t:1", ":", ":a",
+	"> ", ">>> ", "# ", "\n", "*a", "_a", "a_", "~~a ", "\\", "http://a", "<http://a", "a@", "a:", "||a", "**a", "`a",
+	" ", "- ", "* ", " - ", "\n- ", "\n  - ", "- a\n  ", "## ", "\n# ", " # ", "> # ", "> - ",
+	"[a]( '", "](b ", "a@b", "<a:b",
+}
+
+// parseDuration returns the shortest time taken to parse text, out of a few runs.
+func parseDuration(parser *Parser, text string) time.Duration {
+	shortest := time.Duration(-1)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		parser.Parse(text)
+		if d := time.Since(start); shortest < 0 || d < shortest {
+			shortest = d
+		}
+	}
+	return shortest
+}
+
+func TestAdversarial(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	for _, unit := range adversarialUnits {
+		// more than twice the maximum length of a message
+		text := strings.Repeat(unit, 10000/len(unit))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if _, err := parser.ParseContext(ctx, text); err != nil {
+			t.Errorf("parsing %q repeated: %v", unit, err)
+		}
+		cancel()
+		// parsing a message 16 times longer takes 16 times longer in linear time, and 256 times longer in quadratic time
+		short := parseDuration(parser, strings.Repeat(unit, 1000/len(unit)))
+		long := parseDuration(parser, strings.Repeat(unit, 16000/len(unit)))
+		if long > 64*short {
+			t.Errorf("parsing %q repeated: 16 times more repetitions took %.1f times longer", unit, float64(long)/float64(short))
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	source := strings.Repeat("Hello **world**, this is a _message_ with <@1234> and https://example.com links.\n", 100)
+	parser := NewParser(&MessageParserOptions)
+	b.SetBytes(int64(len(source)))
+	for i := 0; i < b.N; i++ {
+		parser.Parse(source)
+	}
+}
@@ -0,0 +1,133 @@
+package formatting
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maxTimestamp is the maximum absolute value of a timestamp, in seconds, that Discord can display.
+const maxTimestamp = 8640000000000
+
+/*
+TimestampStyle is the display style of a TimestampNode, input in Discord as the suffix of <t:stamp:style>.
+*/
+type TimestampStyle string
+
+const (
+	// TimestampShortTime is displayed as 4:20 PM.
+	TimestampShortTime TimestampStyle = "t"
+	// TimestampLongTime is displayed as 4:20:30 PM.
+	TimestampLongTime TimestampStyle = "T"
+	// TimestampShortDate is displayed as 10/16/2026.
+	TimestampShortDate TimestampStyle = "d"
+	// TimestampLongDate is displayed as October 16, 2026.
+	TimestampLongDate TimestampStyle = "D"
+	// TimestampShortDateTime is displayed as October 16, 2026 4:20 PM. This is the default style.
+	TimestampShortDateTime TimestampStyle = "f"
+	// TimestampLongDateTime is displayed as Friday, October 16, 2026 4:20 PM.
+	TimestampLongDateTime TimestampStyle = "F"
+	// TimestampRelative is displayed as 2 hours ago.
+	TimestampRelative TimestampStyle = "R"
+)
+
+/*
+Valid returns whether the style is one of the known Discord timestamp styles.
+*/
+func (s TimestampStyle) Valid() bool {
+	switch s {
+	case TimestampShortTime, TimestampLongTime, TimestampShortDate, TimestampLongDate,
+		TimestampShortDateTime, TimestampLongDateTime, TimestampRelative:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Style returns the display style of the timestamp.
+
+If the timestamp has no explicit Format, the default style TimestampShortDateTime is returned.
+An error is returned if the Format is not a valid style.
+*/
+func (n *TimestampNode) Style() (TimestampStyle, error) {
+	if n.Format == "" {
+		return TimestampShortDateTime, nil
+	}
+	style := TimestampStyle(n.Format)
+	if !style.Valid() {
+		return "", fmt.Errorf("invalid timestamp style: %q", n.Format)
+	}
+	return style, nil
+}
+
+/*
+Time returns the time represented by the timestamp Stamp, which is a number of seconds since the Unix epoch.
+
+An error is returned if the Stamp is not a number, or is out of the range of times that Discord can display.
+*/
+func (n *TimestampNode) Time() (time.Time, error) {
+	stamp, err := strconv.ParseInt(n.Stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %v", err)
+	}
+	if stamp > maxTimestamp || stamp < -maxTimestamp {
+		return time.Time{}, fmt.Errorf("timestamp out of range: %d", stamp)
+	}
+	return time.Unix(stamp, 0), nil
+}
+
+/*
+TimestampOptions is a configuration object used for formatting timestamps with FormatTimestamp.
+
+An empty TimestampOptions, or passing nil instead, is the default configuration.
+*/
+type TimestampOptions struct {
+	// Now is the current time, used for formatting relative timestamps. If zero, the current time is used.
+	Now time.Time
+	// Location is the time zone in which the timestamp is displayed. If nil, the local time zone is used.
+	Location *time.Location
+	// Locale is the locale in which the timestamp is displayed. If nil, LocaleEnglishUS is used.
+	Locale *Locale
+}
+
+// relativeUnits are the durations in seconds of the relative time units, in the order of Locale.Units.
+var relativeUnits = []int64{365 * 24 * 60 * 60, 30 * 24 * 60 * 60, 24 * 60 * 60, 60 * 60, 60, 1}
+
+/*
+FormatTimestamp formats a timestamp the way the Discord apps display it, according to its style and the Locale of the options.
+
+Relative timestamps are formatted relatively to the Now time of the options, for example "3 hours ago" or "in 2 days".
+
+The options parameter can be nil, which is equivalent to passing an empty TimestampOptions.
+An error is returned if the timestamp or its style is invalid.
+*/
+func FormatTimestamp(n *TimestampNode, options *TimestampOptions) (string, error) {
+	if options == nil {
+		options = &TimestampOptions{}
+	}
+	t, err := n.Time()
+	if err != nil {
+		return "", err
+	}
+	style, err := n.Style()
+	if err != nil {
+		return "", err
+	}
+	location := options.Location
+	if location == nil {
+		location = time.Local
+	}
+	locale := options.Locale
+	if locale == nil {
+		locale = &LocaleEnglishUS
+	}
+	if style == TimestampRelative {
+		now := options.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		return locale.relative(t.Unix() - now.Unix()), nil
+	}
+	return locale.format(t.In(location), locale.layout(style)), nil
+}
@@ -0,0 +1,87 @@
+package formatting
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimestamp(t *testing.T) {
+	n := NewParser(nil).Parse("<t:1234567890:R>").Children()[0].(*TimestampNode)
+	if style, err := n.Style(); err != nil || style != TimestampRelative {
+		t.Errorf("want style %q, got %q (%v)", TimestampRelative, style, err)
+	}
+	if tt, err := n.Time(); err != nil || !tt.Equal(time.Unix(1234567890, 0)) {
+		t.Errorf("want time %v, got %v (%v)", time.Unix(1234567890, 0), tt, err)
+	}
+
+	n = NewParser(nil).Parse("<t:-1>").Children()[0].(*TimestampNode)
+	if style, err := n.Style(); err != nil || style != TimestampShortDateTime {
+		t.Errorf("want style %q, got %q (%v)", TimestampShortDateTime, style, err)
+	}
+	if tt, err := n.Time(); err != nil || tt.Unix() != -1 {
+		t.Errorf("want time %v, got %v (%v)", time.Unix(-1, 0), tt, err)
+	}
+
+	if _, err := (&TimestampNode{Stamp: "1", Format: "x"}).Style(); err == nil {
+		t.Errorf("want error for invalid style, got none")
+	}
+	if _, err := (&TimestampNode{Stamp: "99999999999999999"}).Time(); err == nil {
+		t.Errorf("want error for out of range timestamp, got none")
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	now := time.Date(2026, time.October, 16, 16, 20, 30, 0, time.UTC)
+	options := &TimestampOptions{
+		Now:      now,
+		Location: time.UTC,
+	}
+	test := func(stamp int64, style TimestampStyle, want string) {
+		n := &TimestampNode{Stamp: strconv.FormatInt(stamp, 10), Format: string(style)}
+		got, err := FormatTimestamp(n, options)
+		if err != nil || got != want {
+			t.Errorf("error formatting %q with style %q: want %q, got %q (%v)", n.Stamp, style, want, got, err)
+		}
+	}
+	test(now.Unix(), TimestampShortTime, "4:20 PM")
+	test(now.Unix(), TimestampLongTime, "4:20:30 PM")
+	test(now.Unix(), TimestampShortDate, "10/16/2026")
+	test(now.Unix(), TimestampLongDate, "October 16, 2026")
+	test(now.Unix(), "", "October 16, 2026 4:20 PM")
+	test(now.Unix(), TimestampLongDateTime, "Friday, October 16, 2026 4:20 PM")
+	test(now.Unix(), TimestampRelative, "now")
+	test(now.Unix()-3*60*60-20*60, TimestampRelative, "3 hours ago")
+	test(now.Unix()+2*24*60*60, TimestampRelative, "in 2 days")
+	test(now.Unix()-1, TimestampRelative, "1 second ago")
+	test(now.Unix()+400*24*60*60, TimestampRelative, "in 1 year")
+
+	if _, err := FormatTimestamp(&TimestampNode{Stamp: "1", Format: "x"}, options); err == nil {
+		t.Errorf("want error for invalid style, got none")
+	}
+}
+
+func TestFormatTimestampLocale(t *testing.T) {
+	now := time.Date(2026, time.October, 6, 9, 5, 0, 0, time.UTC)
+	n := &TimestampNode{Stamp: strconv.FormatInt(now.Unix(), 10)}
+	for locale, want := range map[*Locale][4]string{
+		&LocaleEnglishUS: {"9:05 AM", "10/06/2026", "Tuesday, October 6, 2026 9:05 AM", "2 hours ago"},
+		&LocaleEnglishGB: {"09:05", "06/10/2026", "Tuesday, 6 October 2026 09:05", "2 hours ago"},
+		&LocaleFrench:    {"09:05", "06/10/2026", "mardi 6 octobre 2026 09:05", "il y a 2 heures"},
+		&LocaleGerman:    {"09:05", "06.10.2026", "Dienstag, 6. Oktober 2026 09:05", "vor 2 Stunden"},
+		&LocaleSpanish:   {"9:05", "06/10/2026", "martes, 6 de octubre de 2026 9:05", "hace 2 horas"},
+	} {
+		options := &TimestampOptions{
+			Now:      now.Add(2 * time.Hour),
+			Location: time.UTC,
+			Locale:   locale,
+		}
+		for i, style := range []TimestampStyle{TimestampShortTime, TimestampShortDate, TimestampLongDateTime, TimestampRelative} {
+			n.Format = string(style)
+			got, err := FormatTimestamp(n, options)
+			if err != nil || got != want[i] {
+				t.Errorf("error formatting style %q: want %q, got %q (%v)", style, want[i], got, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package formatting
+
+import "unicode/utf8"
+
+/*
+Truncate returns a copy of the passed tree shortened to at most n visible characters, as counted by VisibleLength
+with DefaultWidthPolicy, for example for message previews or log lines.
+
+If the tree is longer than n, its content is cut so that the tree and the ellipsis, which is appended as an unformatted
+TextNode at its end, fit in n characters. Text and code are cut at a character boundary, and formatting nodes whose
+content is cut are kept with the rest of their content. Other leaf nodes, such as mentions, emoji and links,
+are never cut in half: they are either kept as is, or removed.
+
+If the tree is not longer than n, an unchanged copy is returned, without the ellipsis. Otherwise, if the passed node
+is not a root node, such as a single TextNode or BoldNode, the returned tree is a root node containing it.
+*/
+func Truncate(n Node, length int, ellipsis string) Node {
+	n = Clone(n)
+	if VisibleLength(n, nil) <= length {
+		return n
+	}
+	if n.Kind() != KindRoot {
+		// cut the node itself rather than its children, and append the ellipsis after it
+		n = NewRoot(n)
+	}
+	budget := length - utf8.RuneCountInString(ellipsis)
+	if budget < 0 {
+		budget = 0
+	}
+	truncate(n, budget)
+	if ellipsis != "" {
+		n.AppendChild(&TextNode{Content: ellipsis})
+	}
+	return n
+}
+
+// truncate cuts the children of n in place to fit in budget characters.
+// It returns the remaining budget, and whether the content of n was cut.
+func truncate(n Node, budget int) (int, bool) {
+	children := n.Children()
+	for i, c := range children {
+		cut, empty := false, false
+		switch c := c.(type) {
+		case *TextNode:
+			c.Content, budget, cut = truncateText(c.Content, budget)
+			empty = c.Content == ""
+		case *CodeNode:
+			c.Content, budget, cut = truncateText(c.Content, budget)
+			empty = c.Content == ""
+		default:
+			if nodeText(c) != "" {
+				if w := DefaultWidthPolicy.Width(c); w <= budget {
+					budget -= w
+				} else {
+					cut, empty = true, true
+				}
+				break
+			}
+			budget, cut = truncate(c, budget)
+			empty = len(c.Children()) == 0
+			if l, ok := c.(*BulletListNode); ok && l.IncludesNewline && !cut {
+				if budget > 0 {
+					budget--
+				} else {
+					l.IncludesNewline = false
+					cut = true
+				}
+			}
+		}
+		if cut {
+			if empty {
+				n.SetChildren(children[:i])
+			} else {
+				n.SetChildren(children[:i+1])
+			}
+			return 0, true
+		}
+	}
+	return budget, false
+}
+
+// truncateText cuts text to fit in budget characters.
+// It returns the cut text, the remaining budget, and whether the text was cut.
+func truncateText(text string, budget int) (string, int, bool) {
+	length := utf8.RuneCountInString(text)
+	if length <= budget {
+		return text, budget - length, false
+	}
+	i := 0
+	for j := 0; j < budget; j++ {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return text[:i], 0, true
+}
@@ -0,0 +1,39 @@
+package formatting
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	parser := NewParser(&MessageParserOptions)
+	for _, tc := range []struct {
+		text   string
+		length int
+		want   string
+	}{
+		{"hello **world**", 15, `[[text "hello "] [bold [text "world"]]]`},
+		{"hello **world**", 9, `[[text "hello "] [bold [text "wo"]] [text "…"]]`},
+		{"héllo wörld", 4, `[[text "hél"] [text "…"]]`},
+		{"hi <@1234> there", 6, `[[text "hi "] [text "…"]]`},
+		{"hi <@1234> there", 9, `[[text "hi "] [usermention "1234"] [text "…"]]`},
+		{"**bold** text", 1, `[[text "…"]]`},
+		{"- a\n- b", 2, `[[list 1 false [text "a"]] [text "…"]]`},
+	} {
+		root := Truncate(parser.Parse(tc.text), tc.length, "…")
+		if got := Debug(root); got != tc.want {
+			t.Errorf("error truncating %q to %d: want %s, got %s", tc.text, tc.length, tc.want, got)
+		}
+		if got := VisibleLength(root, nil); got > tc.length {
+			t.Errorf("error truncating %q to %d: got length %d", tc.text, tc.length, got)
+		}
+	}
+	for _, tc := range []struct {
+		node Node
+		want string
+	}{
+		{NewText("hello world"), `[[text "hell"] [text "…"]]`},
+		{NewBold(NewText("hello world")), `[[bold [text "hell"]] [text "…"]]`},
+	} {
+		if got := Debug(Truncate(tc.node, 5, "…")); got != tc.want {
+			t.Errorf("error truncating %s: want %s, got %s", Debug(tc.node), tc.want, got)
+		}
+	}
+}
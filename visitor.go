@@ -0,0 +1,187 @@
+package formatting
+
+/*
+Visitor is a typed visitor of an AST, with a method per Node type, used by Visit.
+
+Each method is called on entering and leaving each node of its type, like a StatusWalker, and returns a WalkStatus.
+VisitOther is called for the other nodes, such as the root node.
+
+Implementing all the methods of Visitor ensures at compile time that all node types are handled,
+since new methods are added to Visitor when new node types are added. Embed BaseVisitor to only implement some methods instead.
+*/
+type Visitor interface {
+	VisitText(n *TextNode, entering bool) WalkStatus
+	VisitBlockQuote(n *BlockQuoteNode, entering bool) WalkStatus
+	VisitCode(n *CodeNode, entering bool) WalkStatus
+	VisitSpoiler(n *SpoilerNode, entering bool) WalkStatus
+	VisitURL(n *URLNode, entering bool) WalkStatus
+	VisitEmoji(n *EmojiNode, entering bool) WalkStatus
+	VisitChannelMention(n *ChannelMentionNode, entering bool) WalkStatus
+	VisitRoleMention(n *RoleMentionNode, entering bool) WalkStatus
+	VisitUserMention(n *UserMentionNode, entering bool) WalkStatus
+	VisitSpecialMention(n *SpecialMentionNode, entering bool) WalkStatus
+	VisitTimestamp(n *TimestampNode, entering bool) WalkStatus
+	VisitUnknownTag(n *UnknownTagNode, entering bool) WalkStatus
+	VisitHeader(n *HeaderNode, entering bool) WalkStatus
+	VisitBulletList(n *BulletListNode, entering bool) WalkStatus
+	VisitBold(n *BoldNode, entering bool) WalkStatus
+	VisitUnderline(n *UnderlineNode, entering bool) WalkStatus
+	VisitItalics(n *ItalicsNode, entering bool) WalkStatus
+	VisitStrikethrough(n *StrikethroughNode, entering bool) WalkStatus
+	VisitHighlight(n *HighlightNode, entering bool) WalkStatus
+	VisitLineBreak(n *LineBreakNode, entering bool) WalkStatus
+	VisitParagraph(n *ParagraphNode, entering bool) WalkStatus
+	VisitNamedEmoji(n *NamedEmojiNode, entering bool) WalkStatus
+	VisitOther(n Node, entering bool) WalkStatus
+}
+
+/*
+BaseVisitor is a Visitor whose methods do nothing and return WalkContinue.
+It can be embedded in a struct to implement only some methods of Visitor.
+*/
+type BaseVisitor struct{}
+
+func (BaseVisitor) VisitText(n *TextNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitBlockQuote(n *BlockQuoteNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitCode(n *CodeNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitSpoiler(n *SpoilerNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitURL(n *URLNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitEmoji(n *EmojiNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitChannelMention(n *ChannelMentionNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+func (BaseVisitor) VisitRoleMention(n *RoleMentionNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+func (BaseVisitor) VisitUserMention(n *UserMentionNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+func (BaseVisitor) VisitSpecialMention(n *SpecialMentionNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+func (BaseVisitor) VisitTimestamp(n *TimestampNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitUnknownTag(n *UnknownTagNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitHeader(n *HeaderNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitBulletList(n *BulletListNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitBold(n *BoldNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitUnderline(n *UnderlineNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitItalics(n *ItalicsNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitStrikethrough(n *StrikethroughNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+func (BaseVisitor) VisitHighlight(n *HighlightNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitLineBreak(n *LineBreakNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitParagraph(n *ParagraphNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitNamedEmoji(n *NamedEmojiNode, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+func (BaseVisitor) VisitOther(n Node, entering bool) WalkStatus {
+	return WalkContinue
+}
+
+/*
+Visit walks the passed AST like WalkWithStatus, calling the method of the Visitor matching the type of each node.
+
+WalkStop is returned if the walk was stopped, and WalkContinue otherwise.
+*/
+func Visit(n Node, v Visitor) WalkStatus {
+	return walk(n, func(n Node, entering bool) WalkStatus {
+		switch n := n.(type) {
+		case *TextNode:
+			return v.VisitText(n, entering)
+		case *BlockQuoteNode:
+			return v.VisitBlockQuote(n, entering)
+		case *CodeNode:
+			return v.VisitCode(n, entering)
+		case *SpoilerNode:
+			return v.VisitSpoiler(n, entering)
+		case *URLNode:
+			return v.VisitURL(n, entering)
+		case *EmojiNode:
+			return v.VisitEmoji(n, entering)
+		case *ChannelMentionNode:
+			return v.VisitChannelMention(n, entering)
+		case *RoleMentionNode:
+			return v.VisitRoleMention(n, entering)
+		case *UserMentionNode:
+			return v.VisitUserMention(n, entering)
+		case *SpecialMentionNode:
+			return v.VisitSpecialMention(n, entering)
+		case *TimestampNode:
+			return v.VisitTimestamp(n, entering)
+		case *UnknownTagNode:
+			return v.VisitUnknownTag(n, entering)
+		case *HeaderNode:
+			return v.VisitHeader(n, entering)
+		case *BulletListNode:
+			return v.VisitBulletList(n, entering)
+		case *BoldNode:
+			return v.VisitBold(n, entering)
+		case *UnderlineNode:
+			return v.VisitUnderline(n, entering)
+		case *ItalicsNode:
+			return v.VisitItalics(n, entering)
+		case *StrikethroughNode:
+			return v.VisitStrikethrough(n, entering)
+		case *HighlightNode:
+			return v.VisitHighlight(n, entering)
+		case *LineBreakNode:
+			return v.VisitLineBreak(n, entering)
+		case *ParagraphNode:
+			return v.VisitParagraph(n, entering)
+		case *NamedEmojiNode:
+			return v.VisitNamedEmoji(n, entering)
+		default:
+			return v.VisitOther(n, entering)
+		}
+	})
+}